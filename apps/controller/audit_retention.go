@@ -0,0 +1,127 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// auditDeleteBatch is how many audit rows one DELETE removes, so pruning a
+// large backlog doesn't hold long locks on audit_logs.
+const auditDeleteBatch = 5000
+
+// parseAuditKeepActions parses AUDIT_KEEP_ACTIONS ("LOGIN, password_changed")
+// into upper-case action names.
+func parseAuditKeepActions(raw string) []string {
+	actions := []string{}
+	for _, a := range strings.Split(raw, ",") {
+		if a = strings.ToUpper(strings.TrimSpace(a)); a != "" {
+			actions = append(actions, a)
+		}
+	}
+	return actions
+}
+
+// StartAuditRetention prunes audit_logs every hour: rows past
+// AUDIT_RETENTION_DAYS, kept actions past AUDIT_KEEP_DAYS, and the oldest
+// other rows beyond AUDIT_MAX_ROWS.
+func (c *Controller) StartAuditRetention() {
+	if c.Config.AuditRetention <= 0 && c.Config.AuditKeepRetention <= 0 && c.Config.AuditMaxRows <= 0 {
+		log.Println("[AUDIT] Retention disabled, audit_logs is kept in full")
+		return
+	}
+	for {
+		c.pruneAuditLogs(time.Now())
+		time.Sleep(time.Hour)
+	}
+}
+
+// pruneAuditLogs applies the audit retention policy as of now.
+func (c *Controller) pruneAuditLogs(now time.Time) {
+	keep := pq.Array(c.Config.AuditKeepActions)
+	total := int64(0)
+
+	if retention := c.Config.AuditRetention; retention > 0 {
+		n, err := c.deleteAuditBatches(`
+			DELETE FROM audit_logs WHERE id IN (
+				SELECT id FROM audit_logs WHERE created_at < $1 AND NOT (action = ANY($2)) LIMIT $3
+			)`, now.Add(-retention), keep)
+		total += n
+		if err != nil {
+			log.Printf("[AUDIT] Failed to prune rows older than %v: %v", retention, err)
+		}
+	}
+	if retention := c.Config.AuditKeepRetention; retention > 0 && len(c.Config.AuditKeepActions) > 0 {
+		n, err := c.deleteAuditBatches(`
+			DELETE FROM audit_logs WHERE id IN (
+				SELECT id FROM audit_logs WHERE created_at < $1 AND action = ANY($2) LIMIT $3
+			)`, now.Add(-retention), keep)
+		total += n
+		if err != nil {
+			log.Printf("[AUDIT] Failed to prune kept actions older than %v: %v", retention, err)
+		}
+	}
+	if max := c.Config.AuditMaxRows; max > 0 {
+		n, err := c.capAuditRows(max, keep)
+		total += n
+		if err != nil {
+			log.Printf("[AUDIT] Failed to cap audit_logs at %d rows: %v", max, err)
+		}
+	}
+
+	if total > 0 {
+		c.Log("info", "audit", fmt.Sprintf("Pruned %d audit log rows", total))
+	}
+}
+
+// deleteAuditBatches runs a batched DELETE (cutoff $1, kept actions $2, batch
+// size $3) until a batch comes back short, and returns the rows removed.
+func (c *Controller) deleteAuditBatches(query string, cutoff time.Time, keep interface{}) (int64, error) {
+	var total int64
+	for {
+		res, err := c.DB.Exec(query, cutoff, keep, auditDeleteBatch)
+		if err != nil {
+			return total, err
+		}
+		n, _ := res.RowsAffected()
+		total += n
+		if n < auditDeleteBatch {
+			return total, nil
+		}
+		time.Sleep(100 * time.Millisecond) // Let inserts through between batches
+	}
+}
+
+// capAuditRows deletes the oldest rows, other than kept actions, beyond max.
+func (c *Controller) capAuditRows(max int, keep interface{}) (int64, error) {
+	var count int64
+	if err := c.DB.QueryRow("SELECT COUNT(*) FROM audit_logs WHERE NOT (action = ANY($1))", keep).Scan(&count); err != nil {
+		return 0, err
+	}
+
+	var total int64
+	for excess := count - int64(max); excess > 0; {
+		batch := excess
+		if batch > auditDeleteBatch {
+			batch = auditDeleteBatch
+		}
+		res, err := c.DB.Exec(`
+			DELETE FROM audit_logs WHERE id IN (
+				SELECT id FROM audit_logs WHERE NOT (action = ANY($1)) ORDER BY created_at, id LIMIT $2
+			)`, keep, batch)
+		if err != nil {
+			return total, err
+		}
+		n, _ := res.RowsAffected()
+		if n == 0 {
+			break
+		}
+		total += n
+		excess -= n
+		time.Sleep(100 * time.Millisecond)
+	}
+	return total, nil
+}
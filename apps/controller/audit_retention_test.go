@@ -0,0 +1,65 @@
+package main
+
+import (
+	"database/sql/driver"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPruneAuditLogsCutoff(t *testing.T) {
+	now := time.Now()
+	type row struct {
+		action string
+		at     time.Time
+	}
+	rows := map[string]row{
+		"old-publish":   {"STREAM_PUBLISH", now.AddDate(0, 0, -100)},
+		"new-publish":   {"STREAM_PUBLISH", now.AddDate(0, 0, -10)},
+		"old-login":     {"LOGIN", now.AddDate(0, 0, -100)},
+		"ancient-login": {"LOGIN", now.AddDate(0, 0, -400)},
+	}
+	db := newFakeDB(t, func(query string, args []driver.Value) ([]string, [][]driver.Value, error) {
+		if !strings.HasPrefix(strings.TrimSpace(query), "DELETE FROM audit_logs") {
+			return nil, nil, nil
+		}
+		cutoff := args[0].(time.Time)
+		keep := args[1].(string) // pq.Array encodes as {"LOGIN",...}
+		keptOnly := !strings.Contains(query, "NOT (action")
+		var deleted [][]driver.Value
+		for id, r := range rows {
+			if r.at.Before(cutoff) && strings.Contains(keep, `"`+r.action+`"`) == keptOnly {
+				delete(rows, id)
+				deleted = append(deleted, []driver.Value{id})
+			}
+		}
+		return nil, deleted, nil
+	})
+	c := &Controller{Config: &Config{
+		AuditRetention:     90 * 24 * time.Hour,
+		AuditKeepActions:   []string{"LOGIN"},
+		AuditKeepRetention: 365 * 24 * time.Hour,
+	}, DB: db}
+
+	c.pruneAuditLogs(now)
+
+	for _, id := range []string{"new-publish", "old-login"} {
+		if _, ok := rows[id]; !ok {
+			t.Errorf("%s was deleted but is within its retention", id)
+		}
+	}
+	for _, id := range []string{"old-publish", "ancient-login"} {
+		if _, ok := rows[id]; ok {
+			t.Errorf("%s was kept past its cutoff", id)
+		}
+	}
+}
+
+func TestPruneAuditLogsDefaultKeepsEverything(t *testing.T) {
+	db := newFakeDB(t, func(query string, args []driver.Value) ([]string, [][]driver.Value, error) {
+		t.Errorf("unexpected statement with retention disabled: %q", query)
+		return nil, nil, nil
+	})
+	c := &Controller{Config: &Config{AuditKeepActions: []string{"LOGIN"}}, DB: db}
+	c.pruneAuditLogs(time.Now())
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"strings"
+)
+
+// User roles, lowest to highest privilege.
+const (
+	RoleViewer   = "VIEWER"
+	RoleOperator = "OPERATOR"
+	RoleAdmin    = "ADMIN"
+)
+
+var roleRank = map[string]int{
+	RoleViewer:   1,
+	RoleOperator: 2,
+	RoleAdmin:    3,
+}
+
+// normalizeRole upper-cases role and reports whether it is one of the known
+// roles. User create/update validate with it so the DB CHECK constraint never
+// surfaces as a 500 and unknown roles can't reach requestRole.
+func normalizeRole(role string) (string, bool) {
+	role = strings.ToUpper(strings.TrimSpace(role))
+	_, ok := roleRank[role]
+	return role, ok
+}
+
+// Identity is the signed-in user a request acts for. requireLiveSession loads
+// it from the request's session, so role, email and organization come from
+// the users table rather than from anything the client sends.
+type Identity struct {
+	UserID string
+	Email  string
+	Role   string
+	Org    string // "" when the user belongs to no organization
+}
+
+type identityKey struct{}
+
+// withIdentity returns r carrying id as its signed-in user.
+func withIdentity(r *http.Request, id Identity) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), identityKey{}, id))
+}
+
+// requestIdentity returns the signed-in user requireLiveSession attached to r.
+func requestIdentity(r *http.Request) (Identity, bool) {
+	id, ok := r.Context().Value(identityKey{}).(Identity)
+	return id, ok
+}
+
+// requestRole returns the signed-in user's role. Requests without a session
+// (SRS hooks, probes) and unknown roles are treated as VIEWER.
+func requestRole(r *http.Request) string {
+	id, _ := requestIdentity(r)
+	role, ok := normalizeRole(id.Role)
+	if !ok {
+		return RoleViewer
+	}
+	return role
+}
+
+// requestOrg returns the signed-in user's organization ID, or "" when they
+// have none.
+func requestOrg(r *http.Request) string {
+	id, _ := requestIdentity(r)
+	return id.Org
+}
+
+// requestEmail returns the signed-in user's email, or "" without a session.
+func requestEmail(r *http.Request) string {
+	id, _ := requestIdentity(r)
+	return id.Email
+}
+
+// scopedOrg returns the organization a request is limited to, "" meaning all
+// of them. Admins may pick one with requested (e.g. ?org=) and otherwise get
+// their own; everyone else is held to their user's organization whatever they
+// ask for, and ok is false for a non-admin who belongs to none.
+func scopedOrg(r *http.Request, requested string) (org string, ok bool) {
+	if hasRole(r, RoleAdmin) {
+		if requested != "" {
+			return requested, true
+		}
+		return requestOrg(r), true
+	}
+	org = requestOrg(r)
+	return org, org != ""
+}
+
+// requireOrgMember writes a 403 and returns false if a non-admin caller
+// belongs to no organization, as they may create nothing.
+func requireOrgMember(w http.ResponseWriter, r *http.Request) bool {
+	if _, ok := scopedOrg(r, ""); !ok {
+		http.Error(w, "Your account does not belong to an organization", http.StatusForbidden)
+		return false
+	}
+	return true
+}
+
+// hasRole reports whether the caller's role is at least minRole.
+func hasRole(r *http.Request, minRole string) bool {
+	return roleRank[requestRole(r)] >= roleRank[minRole]
+}
+
+// requireRole writes a 403 and returns false if the caller lacks minRole.
+func requireRole(w http.ResponseWriter, r *http.Request, minRole string) bool {
+	if !hasRole(r, minRole) {
+		http.Error(w, "Insufficient permissions", http.StatusForbidden)
+		return false
+	}
+	return true
+}
+
+// sessionHeader carries the session ID the admin UI got from /api/auth/login.
+const sessionHeader = "X-Session-ID"
+
+// sessionExemptPaths are served without a session: probes, the API
+// description, SRS's hooks and the login that issues sessions.
+var sessionExemptPaths = map[string]bool{
+	"/health":                 true,
+	"/ready":                  true,
+	"/api/openapi.json":       true,
+	"/api/hooks/on_connect":   true,
+	"/api/hooks/on_publish":   true,
+	"/api/hooks/on_unpublish": true,
+	"/api/auth/login":         true,
+}
+
+// requireLiveSession rejects requests that lack an X-Session-ID or whose
+// session is revoked or expired, so revoking a session locks its holder out
+// on their next request, and attaches the session's user as the request's
+// Identity. Preflights and sessionExemptPaths pass through without one.
+func (c *Controller) requireLiveSession(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "OPTIONS" || sessionExemptPaths[r.URL.Path] {
+			next.ServeHTTP(w, r)
+			return
+		}
+		sid := strings.TrimSpace(r.Header.Get(sessionHeader))
+		if sid == "" {
+			c.setCORS(w)
+			http.Error(w, "Not signed in", http.StatusUnauthorized)
+			return
+		}
+		id, active, err := c.sessionIdentity(r.Context(), sid)
+		if err != nil {
+			log.Printf("[AUTH] Session check failed: %v", err)
+			http.Error(w, "Database error", http.StatusInternalServerError)
+			return
+		}
+		if !active {
+			c.setCORS(w)
+			http.Error(w, "Session revoked or expired", http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, withIdentity(r, id))
+	})
+}
@@ -0,0 +1,252 @@
+package main
+
+import (
+	"database/sql/driver"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRequireLiveSession(t *testing.T) {
+	// "live" is an open viewer session, "revoked" a revoked one and "inactive"
+	// one whose user was deactivated; other IDs don't exist.
+	cols := []string{"id", "email", "role", "organization_id", "active"}
+	db := newFakeDB(t, func(query string, args []driver.Value) ([]string, [][]driver.Value, error) {
+		if !strings.Contains(query, "FROM sessions") {
+			t.Fatalf("unexpected query %q", query)
+		}
+		switch args[0] {
+		case "live":
+			return cols, [][]driver.Value{{"u1", "viewer@example.com", RoleViewer, "org-a", true}}, nil
+		case "revoked", "inactive":
+			return cols, [][]driver.Value{{"u1", "viewer@example.com", RoleViewer, "org-a", false}}, nil
+		}
+		return cols, nil, nil
+	})
+	c := &Controller{Config: &Config{}, DB: db}
+	h := c.requireLiveSession(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+
+	tests := []struct {
+		name, method, path, session string
+		want                        int
+	}{
+		{"live session", "GET", "/api/channels", "live", http.StatusNoContent},
+		{"revoked session", "GET", "/api/channels", "revoked", http.StatusUnauthorized},
+		{"deactivated user", "GET", "/api/channels", "inactive", http.StatusUnauthorized},
+		{"unknown session", "POST", "/api/channels/1/enable", "forged", http.StatusUnauthorized},
+		{"missing session", "GET", "/api/channels", "", http.StatusUnauthorized},
+		{"preflight", "OPTIONS", "/api/channels", "", http.StatusNoContent},
+		{"login", "POST", "/api/auth/login", "", http.StatusNoContent},
+		{"SRS hook", "POST", "/api/hooks/on_publish", "", http.StatusNoContent},
+		{"probe", "GET", "/health", "", http.StatusNoContent},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.path, nil)
+			req.Header.Set("X-User-Role", RoleAdmin)
+			if tt.session != "" {
+				req.Header.Set(sessionHeader, tt.session)
+			}
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+			if rec.Code != tt.want {
+				t.Errorf("status = %d, want %d", rec.Code, tt.want)
+			}
+		})
+	}
+}
+
+func TestSessionIdentityIgnoresClientHeaders(t *testing.T) {
+	db := newFakeDB(t, func(query string, args []driver.Value) ([]string, [][]driver.Value, error) {
+		return []string{"id", "email", "role", "organization_id", "active"},
+			[][]driver.Value{{"u1", "viewer@example.com", RoleViewer, "org-a", true}}, nil
+	})
+	c := &Controller{Config: &Config{}, DB: db}
+	var role, email, org string
+	h := c.requireLiveSession(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		role, email, org = requestRole(r), requestEmail(r), requestOrg(r)
+	}))
+
+	req := httptest.NewRequest("GET", "/api/users/u2/sessions", nil)
+	req.Header.Set(sessionHeader, "live")
+	req.Header.Set("X-User-Role", RoleAdmin)
+	req.Header.Set("X-User-Email", "admin@example.com")
+	req.Header.Set("X-User-Org", "org-b")
+	h.ServeHTTP(httptest.NewRecorder(), req)
+	if role != RoleViewer || email != "viewer@example.com" || org != "org-a" {
+		t.Errorf("identity = %s %s %s, want the session user's VIEWER viewer@example.com org-a", role, email, org)
+	}
+
+	// Without a session there is no identity to claim
+	req = httptest.NewRequest("POST", "/api/hooks/on_publish", nil)
+	req.Header.Set("X-User-Role", RoleAdmin)
+	if hasRole(req, RoleOperator) || requestEmail(req) != "" {
+		t.Error("client headers granted an identity without a session")
+	}
+}
+
+func TestCanManageSessions(t *testing.T) {
+	c := &Controller{Config: &Config{}}
+	req := httptest.NewRequest("GET", "/api/users/u2/sessions", nil)
+	for _, tt := range []struct {
+		name   string
+		id     Identity
+		target string
+		want   bool
+	}{
+		{"own sessions", Identity{UserID: "u1", Email: "a@example.com", Role: RoleViewer}, "u1", true},
+		{"another user's", Identity{UserID: "u1", Email: "a@example.com", Role: RoleOperator}, "u2", false},
+		{"admin", Identity{UserID: "u1", Role: RoleAdmin}, "u2", true},
+		{"no session", Identity{}, "u2", false},
+	} {
+		if got := c.canManageSessions(withIdentity(req, tt.id), tt.target); got != tt.want {
+			t.Errorf("%s: canManageSessions = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestLoginHandlerAcceptsBcryptHash(t *testing.T) {
+	seedHash := "$2a$10$K7L1OJ45/4Y2nIvhRVpCe.Zo3nLZvhE7WLw4jrUP0e33DlpMT9jLm"
+	db := newFakeDB(t, func(query string, args []driver.Value) ([]string, [][]driver.Value, error) {
+		switch {
+		case strings.Contains(query, "FROM users"):
+			return []string{"id", "email", "name", "role", "password_hash"},
+				[][]driver.Value{{"u1", "admin@livestream.local", "System Administrator", RoleAdmin, seedHash}}, nil
+		case strings.Contains(query, "INSERT INTO sessions"):
+			return []string{"expires_at"}, [][]driver.Value{{time.Now().Add(time.Hour)}}, nil
+		}
+		return nil, nil, nil
+	})
+	c := &Controller{Config: &Config{SessionTTL: time.Hour}, DB: db}
+
+	for password, want := range map[string]int{"admin123": http.StatusOK, "wrong": http.StatusUnauthorized} {
+		body := `{"email":"admin@livestream.local","password":"` + password + `"}`
+		rec := httptest.NewRecorder()
+		c.LoginHandler(rec, httptest.NewRequest("POST", "/api/auth/login", strings.NewReader(body)))
+		if rec.Code != want {
+			t.Errorf("password %q: status = %d, want %d", password, rec.Code, want)
+		}
+		if want == http.StatusOK && !strings.Contains(rec.Body.String(), `"session_id"`) {
+			t.Errorf("password %q: no session_id in %s", password, rec.Body.String())
+		}
+	}
+}
+
+func TestUserRoleValidation(t *testing.T) {
+	tests := []struct {
+		role     string
+		want     int
+		wantRole string
+	}{
+		{"VIEWER", http.StatusOK, RoleViewer},
+		{"operator", http.StatusOK, RoleOperator},
+		{" Admin ", http.StatusOK, RoleAdmin},
+		{"SUPERUSER", http.StatusBadRequest, ""},
+		{"admin'; --", http.StatusBadRequest, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.role, func(t *testing.T) {
+			var stored []interface{}
+			db := newFakeDB(t, func(query string, args []driver.Value) ([]string, [][]driver.Value, error) {
+				switch {
+				case strings.Contains(query, "INSERT INTO users"):
+					stored = append(stored, args[3])
+					return []string{"id"}, [][]driver.Value{{"u1"}}, nil
+				case strings.Contains(query, "UPDATE users"):
+					stored = append(stored, args[0])
+				}
+				return nil, nil, nil
+			})
+			c := &Controller{Config: &Config{}, DB: db}
+
+			create := `{"email":"a@example.com","password":"correct horse","name":"A","role":` + strconv.Quote(tt.role) + `}`
+			rec := httptest.NewRecorder()
+			c.UsersHandler(rec, httptest.NewRequest("POST", "/api/users", strings.NewReader(create)))
+			if rec.Code != tt.want {
+				t.Errorf("create: status = %d, want %d", rec.Code, tt.want)
+			}
+
+			rec = httptest.NewRecorder()
+			c.UserActionHandler(rec, httptest.NewRequest("PUT", "/api/users/u1", strings.NewReader(`{"role":`+strconv.Quote(tt.role)+`}`)))
+			if rec.Code != tt.want {
+				t.Errorf("update: status = %d, want %d", rec.Code, tt.want)
+			}
+
+			if tt.wantRole == "" {
+				if len(stored) != 0 {
+					t.Errorf("invalid role reached the database: %v", stored)
+				}
+				return
+			}
+			if len(stored) != 2 || stored[0] != tt.wantRole || stored[1] != tt.wantRole {
+				t.Errorf("stored roles = %v, want %s for create and update", stored, tt.wantRole)
+			}
+		})
+	}
+}
+
+func TestChangePassword(t *testing.T) {
+	seedHash := "$2a$10$K7L1OJ45/4Y2nIvhRVpCe.Zo3nLZvhE7WLw4jrUP0e33DlpMT9jLm" // admin123
+	tests := []struct {
+		name, email, current, next string
+		want                       int
+	}{
+		{"not signed in", "", "admin123", "new password 1", http.StatusUnauthorized},
+		{"wrong current password", "admin@livestream.local", "admin1234", "new password 1", http.StatusForbidden},
+		{"too short", "admin@livestream.local", "admin123", "pw1", http.StatusBadRequest},
+		{"letters only", "admin@livestream.local", "admin123", "longpassword", http.StatusBadRequest},
+		{"same as current", "admin@livestream.local", "admin123", "admin123", http.StatusBadRequest},
+		{"over 72 bytes", "admin@livestream.local", "admin123", strings.Repeat("pass1", 15), http.StatusBadRequest},
+		{"success", "admin@livestream.local", "admin123", "new password 1", http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var newHash string
+			var audited []driver.Value
+			db := newFakeDB(t, func(query string, args []driver.Value) ([]string, [][]driver.Value, error) {
+				switch {
+				case strings.Contains(query, "FROM users"):
+					if args[0] != "u1" {
+						t.Errorf("looked up user %v, want the session's u1", args[0])
+					}
+					return []string{"email", "password_hash"}, [][]driver.Value{{"admin@livestream.local", seedHash}}, nil
+				case strings.Contains(query, "UPDATE users SET password_hash"):
+					newHash = args[0].(string)
+				case strings.Contains(query, "INSERT INTO audit_logs"):
+					audited = args
+				}
+				return nil, nil, nil
+			})
+			c := &Controller{Config: &Config{}, DB: db}
+
+			body := `{"current_password":` + strconv.Quote(tt.current) + `,"new_password":` + strconv.Quote(tt.next) + `}`
+			req := httptest.NewRequest("POST", "/api/auth/change-password", strings.NewReader(body))
+			if tt.email != "" {
+				req = withIdentity(req, Identity{UserID: "u1", Email: tt.email, Role: RoleAdmin})
+			}
+			rec := httptest.NewRecorder()
+			c.ChangePasswordHandler(rec, req)
+			if rec.Code != tt.want {
+				t.Fatalf("status = %d (%s), want %d", rec.Code, strings.TrimSpace(rec.Body.String()), tt.want)
+			}
+
+			if tt.want != http.StatusOK {
+				if newHash != "" || audited != nil {
+					t.Error("rejected change still updated or audited the password")
+				}
+				return
+			}
+			if !strings.HasPrefix(newHash, "$2a$") || !passwordMatches(newHash, tt.next) || passwordMatches(newHash, tt.current) {
+				t.Errorf("stored hash %q is not a bcrypt hash of the new password", newHash)
+			}
+			if len(audited) < 3 || audited[1] != tt.email || audited[2] != "PASSWORD_CHANGED" {
+				t.Errorf("audit row = %v, want PASSWORD_CHANGED by %s", audited, tt.email)
+			}
+		})
+	}
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestPasswordMatchesBcrypt(t *testing.T) {
+	// Reference hashes from the system crypt(3).
+	tests := []struct {
+		hash, password string
+		want           bool
+	}{
+		{"$2a$10$K7L1OJ45/4Y2nIvhRVpCe.Zo3nLZvhE7WLw4jrUP0e33DlpMT9jLm", "admin123", true},
+		{"$2a$10$K7L1OJ45/4Y2nIvhRVpCe.Zo3nLZvhE7WLw4jrUP0e33DlpMT9jLm", "admin124", false},
+		{"$2b$05$CCCCCCCCCCCCCCCCCCCCC.E5YPO9kmyuRGyh0XouQYb4YMJKvyOeW", "U*U", true},
+		{"$2a$06$If6bvum7DFjUnE9p2uDeDu0YHzrHM6tf.iqN8.yx.jNN1ILEf7h0i", "abc", true},
+		{"$2y$04$abcdefghijklmnopqrstuubyCG3zY1GIXMyxfivm.ClDiInHzxjiq", "", true},
+		// Only the first 72 bytes count
+		{"$2b$04$abcdefghijklmnopqrstuuBzzIgyKkz7xMWYSzkIjUSnxEQFQ0WNe", strings.Repeat("a", 80), true},
+		{"$2b$04$abcdefghijklmnopqrstuuBzzIgyKkz7xMWYSzkIjUSnxEQFQ0WNe", strings.Repeat("a", 72), true},
+		{"$2b$04$abcdefghijklmnopqrstuuMCu.k1vM/ywQwiONaEn3oEMlZoCVBd6", strings.Repeat("a", 71), true},
+		// The hash seeded before migration 24 matches nothing
+		{"$2a$10$K7L1OJ45/4Y2nIvhRVpCe.FSmhDdWoXehVzJptJ/op0lSsvqNu/X6", "admin123", false},
+		// Malformed
+		{"$2b$03$abcdefghijklmnopqrstuubyCG3zY1GIXMyxfivm.ClDiInHzxjiq", "", false},
+		{"$2b$04$abcdefghijklmnopqrstuubyCG3zY1GIXMyxfivm.ClDiInHzxji", "", false},
+		{"", "", false},
+	}
+	for _, tt := range tests {
+		if got := passwordMatches(tt.hash, tt.password); got != tt.want {
+			t.Errorf("passwordMatches(%q, %q) = %v, want %v", tt.hash, tt.password, got, tt.want)
+		}
+	}
+}
+
+func TestPasswordMatches(t *testing.T) {
+	hash, err := hashPassword("s3cret-pass")
+	if err != nil || !strings.HasPrefix(hash, "$2a$") {
+		t.Fatalf("hashPassword = %q, %v; want a bcrypt hash", hash, err)
+	}
+	if !passwordMatches(hash, "s3cret-pass") {
+		t.Error("bcrypt hash from hashPassword did not match")
+	}
+	if passwordMatches(hash, "other") {
+		t.Error("bcrypt hash matched the wrong password")
+	}
+	if _, err := hashPassword(strings.Repeat("a", 73)); !errors.Is(err, bcrypt.ErrPasswordTooLong) {
+		t.Errorf("73-byte password: err = %v, want ErrPasswordTooLong", err)
+	}
+
+	// SHA-256 hex written by older releases still signs in
+	legacy := "926d3a2dd68393416b7a8348aaadfe4e0c56de6259e17078a0fa1f4dd6e519ae"
+	if !passwordMatches(legacy, "s3cret-pass") || passwordMatches(legacy, "other") {
+		t.Error("legacy SHA-256 hash not checked against the password")
+	}
+}
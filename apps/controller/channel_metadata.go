@@ -0,0 +1,66 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+)
+
+// maxChannelMetadataBytes caps a channel's metadata once compacted, so
+// integrators can attach IDs, tags and notes without bloating every channel
+// listing.
+const maxChannelMetadataBytes = 16 << 10
+
+// normalizeChannelMetadata checks that raw is a JSON object within the size
+// limit and returns it compacted. An empty value or null becomes {}.
+func normalizeChannelMetadata(raw json.RawMessage) (json.RawMessage, error) {
+	if len(bytes.TrimSpace(raw)) == 0 || string(bytes.TrimSpace(raw)) == "null" {
+		return json.RawMessage("{}"), nil
+	}
+	var obj map[string]interface{}
+	if err := json.Unmarshal(raw, &obj); err != nil {
+		return nil, fmt.Errorf("metadata must be a JSON object")
+	}
+	var buf bytes.Buffer
+	if err := json.Compact(&buf, raw); err != nil {
+		return nil, fmt.Errorf("metadata must be a JSON object")
+	}
+	if buf.Len() > maxChannelMetadataBytes {
+		return nil, fmt.Errorf("metadata must be at most %d bytes", maxChannelMetadataBytes)
+	}
+	return json.RawMessage(buf.Bytes()), nil
+}
+
+// metadataMatches reports whether metadata has key set to value. String values
+// compare as-is and anything else by its JSON encoding, so ?metadata_value=42
+// or =true match numbers and booleans. An empty value only requires the key.
+func metadataMatches(metadata json.RawMessage, key, value string) bool {
+	var obj map[string]json.RawMessage
+	if json.Unmarshal(metadata, &obj) != nil {
+		return false
+	}
+	v, ok := obj[key]
+	if !ok {
+		return false
+	}
+	if value == "" {
+		return true
+	}
+	var s string
+	if json.Unmarshal(v, &s) == nil {
+		return s == value
+	}
+	return string(v) == value
+}
+
+// filterChannelsByMetadata keeps the channels whose metadata has key (set to
+// value, when given).
+func filterChannelsByMetadata(channels []Channel, key, value string) []Channel {
+	kept := channels[:0]
+	for _, ch := range channels {
+		if metadataMatches(ch.Metadata, key, value) {
+			kept = append(kept, ch)
+		}
+	}
+	return kept
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestNormalizeChannelMetadata(t *testing.T) {
+	for _, tt := range []struct {
+		name, raw, want, wantErr string
+	}{
+		{"absent", "", "{}", ""},
+		{"null", "null", "{}", ""},
+		{"compacted", `{ "external_id": "yt-42",  "tags": ["a", "b"] }`, `{"external_id":"yt-42","tags":["a","b"]}`, ""},
+		{"array", `["a"]`, "", "metadata must be a JSON object"},
+		{"string", `"notes"`, "", "metadata must be a JSON object"},
+		{"too large", `{"notes":"` + strings.Repeat("x", maxChannelMetadataBytes) + `"}`, "", "metadata must be at most 16384 bytes"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := normalizeChannelMetadata(json.RawMessage(tt.raw))
+			if tt.wantErr != "" {
+				if err == nil || err.Error() != tt.wantErr {
+					t.Errorf("err = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil || string(got) != tt.want {
+				t.Errorf("normalizeChannelMetadata(%s) = %s, %v; want %s", tt.raw, got, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestChannelMetadataRoundTripAndFilter(t *testing.T) {
+	// Channels created through the API, as the database would return them
+	var stored [][]byte
+	db := newFakeDB(t, func(query string, args []driver.Value) ([]string, [][]driver.Value, error) {
+		switch {
+		case strings.Contains(query, "SELECT EXISTS"):
+			return []string{"exists"}, [][]driver.Value{{false}}, nil
+		case strings.Contains(query, "FROM organizations"):
+			return []string{"id"}, [][]driver.Value{{"org-a"}}, nil
+		case strings.Contains(query, "INSERT INTO channels"):
+			stored = append(stored, []byte(args[27].(string)))
+			return []string{"id"}, [][]driver.Value{{int64(len(stored))}}, nil
+		case strings.Contains(query, "FROM channels") && strings.Contains(query, "metadata"):
+			var overrides []map[string]driver.Value
+			for i, m := range stored {
+				overrides = append(overrides, map[string]driver.Value{
+					"id": int64(i + 1), "name": []string{"news", "sports", "music"}[i], "metadata": m,
+				})
+			}
+			cols, rows := channelRows(overrides...)
+			return cols, rows, nil
+		}
+		return nil, nil, nil
+	})
+	c := newTestController(&Config{SRSApiURL: fakeSRS(t, "[]").URL, SRSApp: "live"}, db)
+
+	for _, body := range []string{
+		`{"name":"news","display_name":"News","metadata":{"external_id":"yt-42","tags":["live"]}}`,
+		`{"name":"sports","display_name":"Sports","metadata":{"external_id":"yt-7","priority":2}}`,
+		`{"name":"music","display_name":"Music"}`,
+	} {
+		req := httptest.NewRequest("POST", "/api/channels", strings.NewReader(body))
+		req = withIdentity(req, Identity{Role: RoleAdmin})
+		rec := httptest.NewRecorder()
+		c.ChannelsHandler(rec, req)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("create %s: status %d (%s)", body, rec.Code, strings.TrimSpace(rec.Body.String()))
+		}
+	}
+
+	list := func(query string) map[string]string {
+		t.Helper()
+		req := httptest.NewRequest("GET", "/api/channels"+query, nil)
+		req = withIdentity(req, Identity{Role: RoleAdmin})
+		rec := httptest.NewRecorder()
+		c.ChannelsHandler(rec, req)
+		var channels []struct {
+			Name     string          `json:"name"`
+			Metadata json.RawMessage `json:"metadata"`
+		}
+		if err := json.NewDecoder(rec.Body).Decode(&channels); err != nil {
+			t.Fatalf("GET %s: %v", query, err)
+		}
+		got := make(map[string]string)
+		for _, ch := range channels {
+			got[ch.Name] = string(ch.Metadata)
+		}
+		return got
+	}
+
+	all := list("")
+	if all["news"] != `{"external_id":"yt-42","tags":["live"]}` || all["music"] != "{}" {
+		t.Errorf("metadata after a round trip = %v", all)
+	}
+	for _, tt := range []struct {
+		query string
+		want  []string
+	}{
+		{"?metadata_key=external_id&metadata_value=yt-42", []string{"news"}},
+		{"?metadata_key=external_id", []string{"news", "sports"}},
+		{"?metadata_key=priority&metadata_value=2", []string{"sports"}},
+		{"?metadata_key=external_id&metadata_value=yt-1", nil},
+	} {
+		got := list(tt.query)
+		if len(got) != len(tt.want) {
+			t.Errorf("%s listed %v, want %v", tt.query, got, tt.want)
+			continue
+		}
+		for _, name := range tt.want {
+			if _, ok := got[name]; !ok {
+				t.Errorf("%s listed %v, want %v", tt.query, got, tt.want)
+			}
+		}
+	}
+
+	req := httptest.NewRequest("POST", "/api/channels", strings.NewReader(`{"name":"bad","display_name":"Bad","metadata":["x"]}`))
+	req = withIdentity(req, Identity{Role: RoleAdmin})
+	rec := httptest.NewRecorder()
+	c.ChannelsHandler(rec, req)
+	if rec.Code != http.StatusBadRequest || len(stored) != 3 {
+		t.Errorf("non-object metadata: status %d, %d channels stored; want 400 and nothing stored", rec.Code, len(stored))
+	}
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/client"
+)
+
+// pausedContainers are the containers a pause stops and a resume starts, in
+// resume order: the loop publishes first so the relay has a source to pull.
+func pausedContainers(channel string) []string {
+	return []string{"loop-" + channel, "relay-" + channel}
+}
+
+// holdPausedChannel is the reconcile step for a paused channel: its
+// containers are stopped, never removed or recreated, so a resume is a plain
+// Docker start.
+func (c *Controller) holdPausedChannel(ch Channel) {
+	ctx := context.Background()
+	for _, name := range pausedContainers(ch.Name) {
+		info, err := c.Docker.ContainerInspect(ctx, name)
+		if err != nil || !info.State.Running {
+			continue
+		}
+		c.Log("info", "docker", fmt.Sprintf("Stopping %s, channel %s is paused", name, ch.Name))
+		if err := c.Docker.ContainerStop(ctx, name, container.StopOptions{}); err != nil {
+			c.Log("warn", "docker", fmt.Sprintf("Failed to stop %s: %v", name, err))
+		}
+	}
+	for _, dest := range ch.Destinations {
+		if dest.Status != "DISCONNECTED" {
+			c.UpdateDestinationStatus(dest.ID, "DISCONNECTED")
+		}
+	}
+}
+
+// pauseChannel stops a channel's loop and relay containers without removing
+// them. The channel stays enabled and keeps its settings; the reconciler
+// leaves it stopped until /resume.
+// POST /api/channels/{id}/pause
+func (c *Controller) pauseChannel(w http.ResponseWriter, r *http.Request, ch Channel) {
+	ctx := context.Background()
+	steps := map[string]string{}
+
+	// Flag first so a reconcile in between doesn't restart what we stop
+	if _, err := c.DB.Exec("UPDATE channels SET paused = true WHERE id = $1", ch.ID); err != nil {
+		c.Log("error", "api", fmt.Sprintf("Failed to pause channel %s: %v", ch.Name, err))
+		http.Error(w, "Failed to pause channel", http.StatusInternalServerError)
+		return
+	}
+
+	// Relay first, so destinations see the stream end rather than a cut to nothing
+	names := pausedContainers(ch.Name)
+	for i := len(names) - 1; i >= 0; i-- {
+		err := c.Docker.ContainerStop(ctx, names[i], container.StopOptions{})
+		switch {
+		case client.IsErrNotFound(err):
+			steps[names[i]] = "absent"
+		case err != nil:
+			steps[names[i]] = err.Error()
+		default:
+			steps[names[i]] = "stopped"
+		}
+	}
+
+	c.Log("info", "api", fmt.Sprintf("Paused channel %s", ch.Name))
+	c.auditChannelPause(r, "CHANNEL_PAUSED", ch, steps)
+	c.RecordEvent("PAUSED", ch.Name, "api", "containers stopped")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"status":     "paused",
+		"channel":    ch.Name,
+		"containers": steps,
+	})
+}
+
+// resumeChannel starts the containers a pause stopped and reconciles the
+// channel straight away so its relay gets the current config. A container
+// that was removed meanwhile is simply recreated by that reconcile.
+// POST /api/channels/{id}/resume
+func (c *Controller) resumeChannel(w http.ResponseWriter, r *http.Request, ch Channel) {
+	ctx := context.Background()
+	steps := map[string]string{}
+
+	if _, err := c.DB.Exec("UPDATE channels SET paused = false WHERE id = $1", ch.ID); err != nil {
+		c.Log("error", "api", fmt.Sprintf("Failed to resume channel %s: %v", ch.Name, err))
+		http.Error(w, "Failed to resume channel", http.StatusInternalServerError)
+		return
+	}
+
+	for _, name := range pausedContainers(ch.Name) {
+		err := c.Docker.ContainerStart(ctx, name, container.StartOptions{})
+		switch {
+		case client.IsErrNotFound(err):
+			steps[name] = "absent"
+		case err != nil:
+			steps[name] = err.Error()
+		default:
+			steps[name] = "started"
+		}
+	}
+	c.requestReconcile(ch.Name)
+
+	c.Log("info", "api", fmt.Sprintf("Resumed channel %s", ch.Name))
+	c.auditChannelPause(r, "CHANNEL_RESUMED", ch, steps)
+	c.RecordEvent("RESUMED", ch.Name, "api", "containers started")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"status":     "resumed",
+		"channel":    ch.Name,
+		"containers": steps,
+	})
+}
+
+// auditChannelPause records a pause or resume and what happened to each
+// container.
+func (c *Controller) auditChannelPause(r *http.Request, action string, ch Channel, steps map[string]string) {
+	details, _ := json.Marshal(steps)
+	c.DB.Exec(`
+		INSERT INTO audit_logs (action, resource_type, resource_id, details, ip_address)
+		VALUES ($1, $2, $3, $4, $5)
+	`, action, "channel", ch.Name, string(details), r.RemoteAddr)
+}
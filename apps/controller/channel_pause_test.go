@@ -0,0 +1,85 @@
+package main
+
+import (
+	"database/sql/driver"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestPauseStopsWithoutRemoving(t *testing.T) {
+	var mu sync.Mutex
+	var calls, stmts []string
+	db := newFakeDB(t, func(query string, args []driver.Value) ([]string, [][]driver.Value, error) {
+		if cols, rows, ok := channelOwner(query, "org-a"); ok {
+			return cols, rows, nil
+		}
+		if strings.Contains(query, "SELECT id, name, display_name, enabled, loop_enabled") {
+			return []string{"id", "name", "display_name", "enabled", "loop_enabled"},
+				[][]driver.Value{{int64(1), "news", "News", true, true}}, nil
+		}
+		if strings.Contains(query, "paused =") {
+			mu.Lock()
+			stmts = append(stmts, strings.TrimSpace(query))
+			mu.Unlock()
+		}
+		return nil, nil, nil
+	})
+	c := newTestController(&Config{}, db)
+	c.Docker = newFakeDocker(t, func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		calls = append(calls, r.Method+" "+r.URL.Path)
+		mu.Unlock()
+		if r.Method == "GET" && strings.HasSuffix(r.URL.Path, "/json") {
+			w.Write([]byte(`{"Id":"x","State":{"Running":true}}`))
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+	took := func() []string {
+		mu.Lock()
+		defer mu.Unlock()
+		got := calls
+		calls = nil
+		return got
+	}
+	action := func(name string) {
+		t.Helper()
+		req := httptest.NewRequest("POST", "/api/channels/1/"+name, nil)
+		req = withIdentity(req, Identity{Role: RoleOperator, Org: "org-a"})
+		rec := httptest.NewRecorder()
+		c.ChannelActionHandler(rec, req)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("%s: status %d (%s)", name, rec.Code, strings.TrimSpace(rec.Body.String()))
+		}
+	}
+
+	action("pause")
+	got := strings.Join(took(), "; ")
+	if got != "POST /containers/relay-news/stop; POST /containers/loop-news/stop" {
+		t.Errorf("pause made Docker calls %q, want the relay then the loop stopped", got)
+	}
+	if len(stmts) != 1 || stmts[0] != "UPDATE channels SET paused = true WHERE id = $1" {
+		t.Errorf("pause statements = %q", stmts)
+	}
+
+	// The reconciler holds a paused channel stopped instead of recreating it
+	c.ReconcileChannel(Channel{Name: "news", Enabled: true, LoopEnabled: true, Paused: true, LoopToken: "loop-secret"}, map[string]SRSStream{})
+	held := took()
+	if !strings.Contains(strings.Join(held, "; "), "POST /containers/loop-news/stop") {
+		t.Errorf("reconcile of a paused channel left its running loop alone: %q", held)
+	}
+	for _, call := range held {
+		if strings.HasPrefix(call, "DELETE ") || strings.HasSuffix(call, "/create") || strings.HasSuffix(call, "/start") {
+			t.Errorf("reconcile of a paused channel made %q", call)
+		}
+	}
+
+	action("resume")
+	got = strings.Join(took(), "; ")
+	if got != "POST /containers/loop-news/start; POST /containers/relay-news/start" {
+		t.Errorf("resume made Docker calls %q, want the loop then the relay started", got)
+	}
+}
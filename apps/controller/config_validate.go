@@ -0,0 +1,150 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+)
+
+// configField describes one field of a known system_config value.
+type configField struct {
+	kind     string // "bool", "int" or "number"
+	min, max float64
+}
+
+// knownConfigKeys lists the system_config entries the controller understands
+// and the fields each may hold. Keys not listed here, and fields not listed
+// for a key, are accepted as-is; default_channel_settings is checked against
+// ChannelDefaults instead.
+var knownConfigKeys = map[string]map[string]configField{
+	"failover": {
+		"enabled":            {kind: "bool"},
+		"timeout_seconds":    {kind: "int", min: 1, max: 300},
+		"stability_window":   {kind: "int", min: 1, max: 60},
+		"anti_flap_cooldown": {kind: "int", min: 0, max: 3600},
+	},
+	"health_check": {
+		"interval_seconds": {kind: "int", min: 1, max: 300},
+		"timeout_seconds":  {kind: "int", min: 1, max: 60},
+	},
+	"resources": {
+		"loop_container_memory_mb": {kind: "int", min: 64, max: 65536},
+		"loop_container_cpu":       {kind: "number", min: 0.05, max: 64},
+	},
+}
+
+// ConfigProblem is one reason a stored or submitted config value is invalid.
+type ConfigProblem struct {
+	Key     string `json:"key"`
+	Problem string `json:"problem"`
+}
+
+// validateConfigValue checks a system_config value against the rules for its
+// key and returns what is wrong with it, or nothing if it is acceptable.
+func (c *Controller) validateConfigValue(key string, raw []byte) []string {
+	if key == channelDefaultsKey {
+		defaults := builtinChannelDefaults()
+		if err := json.Unmarshal(raw, &defaults); err != nil {
+			return []string{fmt.Sprintf("value is not valid channel defaults: %v", err)}
+		}
+		if err := defaults.validate(); err != nil {
+			return []string{err.Error()}
+		}
+		if err := c.validateBitrates(defaults.VideoBitrate, defaults.AudioBitrate); err != nil {
+			return []string{err.Error()}
+		}
+		return nil
+	}
+
+	fields, known := knownConfigKeys[key]
+	if !known {
+		return nil
+	}
+	var value map[string]interface{}
+	if err := json.Unmarshal(raw, &value); err != nil || value == nil {
+		return []string{"value must be a JSON object"}
+	}
+
+	names := make([]string, 0, len(fields))
+	for name := range fields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var problems []string
+	for _, name := range names {
+		v, ok := value[name]
+		if !ok {
+			continue
+		}
+		if msg := fields[name].check(v); msg != "" {
+			problems = append(problems, name+" "+msg)
+		}
+	}
+	return problems
+}
+
+// check describes what is wrong with v for this field, or returns "".
+func (f configField) check(v interface{}) string {
+	if f.kind == "bool" {
+		if _, ok := v.(bool); !ok {
+			return "must be true or false"
+		}
+		return ""
+	}
+	n, ok := v.(float64)
+	if !ok {
+		return "must be a number"
+	}
+	if f.kind == "int" && n != math.Trunc(n) {
+		return "must be a whole number"
+	}
+	if n < f.min || n > f.max {
+		return fmt.Sprintf("must be between %g and %g", f.min, f.max)
+	}
+	return ""
+}
+
+// SystemConfigValidateHandler checks every stored system_config entry and
+// reports the ones that would be rejected by PUT /api/config.
+// GET /api/system/config/validate
+func (c *Controller) SystemConfigValidateHandler(w http.ResponseWriter, r *http.Request) {
+	c.setCORS(w)
+	if r.Method == "OPTIONS" {
+		return
+	}
+	if r.Method != "GET" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	rows, err := c.DB.Query("SELECT key, value FROM system_config ORDER BY key")
+	if err != nil {
+		c.Log("error", "api", fmt.Sprintf("Failed to fetch config: %v", err))
+		http.Error(w, "Failed to fetch config", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	checked := 0
+	problems := []ConfigProblem{}
+	for rows.Next() {
+		var key string
+		var value []byte
+		if err := rows.Scan(&key, &value); err != nil {
+			continue
+		}
+		checked++
+		for _, p := range c.validateConfigValue(key, value) {
+			problems = append(problems, ConfigProblem{Key: key, Problem: p})
+		}
+	}
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"valid":    len(problems) == 0,
+		"checked":  checked,
+		"problems": problems,
+	})
+}
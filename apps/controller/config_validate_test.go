@@ -0,0 +1,99 @@
+package main
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestValidateConfigValue(t *testing.T) {
+	c := newTestController(&Config{}, nil)
+
+	for _, tt := range []struct {
+		name string
+		key  string
+		raw  string
+		want []string
+	}{
+		{"valid", "failover", `{"enabled":true,"timeout_seconds":10}`, nil},
+		{"string for int", "failover", `{"timeout_seconds":"ten"}`, []string{"timeout_seconds must be a number"}},
+		{"string for bool", "failover", `{"enabled":"yes"}`, []string{"enabled must be true or false"}},
+		{"fraction for int", "health_check", `{"interval_seconds":1.5}`, []string{"interval_seconds must be a whole number"}},
+		{"out of range", "health_check", `{"timeout_seconds":0}`, []string{"timeout_seconds must be between 1 and 60"}},
+		{"fraction for number", "resources", `{"loop_container_cpu":0.5}`, nil},
+		{"several problems", "failover", `{"enabled":1,"stability_window":"x"}`, []string{"enabled must be true or false", "stability_window must be a number"}},
+		{"unlisted field", "failover", `{"note":"anything"}`, nil},
+		{"unknown key", "feature_flags", `{"timeout_seconds":"ten"}`, nil},
+		{"not an object", "failover", `"on"`, []string{"value must be a JSON object"}},
+		{"null", "resources", `null`, []string{"value must be a JSON object"}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			got := c.validateConfigValue(tt.key, []byte(tt.raw))
+			if strings.Join(got, "; ") != strings.Join(tt.want, "; ") {
+				t.Errorf("validateConfigValue(%s, %s) = %q, want %q", tt.key, tt.raw, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSystemConfigPutRejectsBadTypes(t *testing.T) {
+	updated := false
+	db := newFakeDB(t, func(query string, args []driver.Value) ([]string, [][]driver.Value, error) {
+		if strings.HasPrefix(query, "UPDATE system_config") {
+			updated = true
+		}
+		return nil, nil, nil
+	})
+	c := newTestController(&Config{}, db)
+
+	rec := httptest.NewRecorder()
+	body := `{"key":"failover","value":{"enabled":true,"timeout_seconds":"30"}}`
+	c.SystemConfigHandler(rec, httptest.NewRequest("PUT", "/api/config", strings.NewReader(body)))
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("status = %d, want 400", rec.Code)
+	}
+	if !strings.Contains(rec.Body.String(), "timeout_seconds must be a number") {
+		t.Errorf("body = %q, want it to name the bad field", rec.Body.String())
+	}
+	if updated {
+		t.Error("invalid value was written to system_config")
+	}
+
+	rec = httptest.NewRecorder()
+	body = `{"key":"failover","value":{"enabled":true,"timeout_seconds":30}}`
+	c.SystemConfigHandler(rec, httptest.NewRequest("PUT", "/api/config", strings.NewReader(body)))
+	if rec.Code != http.StatusOK || !updated {
+		t.Errorf("valid value: status = %d, updated = %v; want 200 and an UPDATE", rec.Code, updated)
+	}
+}
+
+func TestSystemConfigValidateReportsStoredProblems(t *testing.T) {
+	db := newFakeDB(t, func(query string, args []driver.Value) ([]string, [][]driver.Value, error) {
+		if strings.HasPrefix(query, "SELECT key, value FROM system_config") {
+			return []string{"key", "value"}, [][]driver.Value{
+				{"failover", []byte(`{"enabled":"yes"}`)},
+				{"health_check", []byte(`{"interval_seconds":30}`)},
+			}, nil
+		}
+		return nil, nil, nil
+	})
+	c := newTestController(&Config{}, db)
+
+	rec := httptest.NewRecorder()
+	c.SystemConfigValidateHandler(rec, httptest.NewRequest("GET", "/api/system/config/validate", nil))
+	var resp struct {
+		Valid    bool            `json:"valid"`
+		Checked  int             `json:"checked"`
+		Problems []ConfigProblem `json:"problems"`
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+	want := []ConfigProblem{{Key: "failover", Problem: "enabled must be true or false"}}
+	if resp.Valid || resp.Checked != 2 || len(resp.Problems) != 1 || resp.Problems[0] != want[0] {
+		t.Errorf("got %+v, want valid=false checked=2 problems=%+v", resp, want)
+	}
+}
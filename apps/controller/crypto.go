@@ -3,14 +3,32 @@ package main
 import (
 	"crypto/aes"
 	"crypto/cipher"
+	"crypto/hmac"
 	"crypto/rand"
 	"crypto/sha256"
 	"encoding/hex"
+	"errors"
+	"fmt"
 	"io"
 	"os"
 )
 
-var encryptionKey []byte
+var (
+	encryptionKey    []byte
+	oldEncryptionKey []byte // ENCRYPTION_KEY_OLD, still accepted for decryption during a key rotation
+)
+
+// Errors returned (wrapped) by Encrypt and Decrypt; test with errors.Is.
+var (
+	// ErrInvalidHex means the stored ciphertext or IV isn't valid hex of the
+	// expected length, i.e. the column is corrupt or was written by hand.
+	ErrInvalidHex = errors.New("malformed ciphertext or IV")
+	// ErrDecryptAuth means GCM authentication failed: the data was encrypted
+	// with a different ENCRYPTION_KEY or has been tampered with.
+	ErrDecryptAuth = errors.New("decryption failed (wrong key or corrupt data)")
+	// ErrKeyInit means ENCRYPTION_KEY can't be used as an AES key.
+	ErrKeyInit = errors.New("invalid encryption key")
+)
 
 func InitCrypto() {
 	keyHex := os.Getenv("ENCRYPTION_KEY")
@@ -22,6 +40,12 @@ func InitCrypto() {
 	if err != nil {
 		panic("Invalid ENCRYPTION_KEY")
 	}
+	if oldHex := os.Getenv("ENCRYPTION_KEY_OLD"); oldHex != "" {
+		oldEncryptionKey, err = hex.DecodeString(oldHex)
+		if err != nil {
+			panic("Invalid ENCRYPTION_KEY_OLD")
+		}
+	}
 }
 
 func HashToken(token string) string {
@@ -30,42 +54,76 @@ func HashToken(token string) string {
 	return hex.EncodeToString(h.Sum(nil))
 }
 
+// RelaySecret is the shared secret a channel's relay requires on its control
+// API. Deriving it from ENCRYPTION_KEY keeps it stable across controller
+// restarts without storing it anywhere.
+func RelaySecret(channel string) string {
+	mac := hmac.New(sha256.New, encryptionKey)
+	mac.Write([]byte("relay:" + channel))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// newGCM builds the AES-GCM AEAD for a key.
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrKeyInit, err)
+	}
+	aesgcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrKeyInit, err)
+	}
+	return aesgcm, nil
+}
+
+// Decrypt tries the current key and then ENCRYPTION_KEY_OLD.
 func Decrypt(encryptedHex string, ivHex string) (string, error) {
+	plaintext, _, err := decryptAnyKey(encryptedHex, ivHex)
+	return plaintext, err
+}
+
+// decryptAnyKey is Decrypt that also reports whether only the old key worked,
+// i.e. the value needs re-encrypting.
+func decryptAnyKey(encryptedHex string, ivHex string) (string, bool, error) {
+	plaintext, err := decryptWithKey(encryptionKey, encryptedHex, ivHex)
+	if errors.Is(err, ErrDecryptAuth) && oldEncryptionKey != nil {
+		if plaintext, oldErr := decryptWithKey(oldEncryptionKey, encryptedHex, ivHex); oldErr == nil {
+			return plaintext, true, nil
+		}
+	}
+	return plaintext, false, err
+}
+
+func decryptWithKey(key []byte, encryptedHex string, ivHex string) (string, error) {
 	ciphertext, err := hex.DecodeString(encryptedHex)
 	if err != nil {
-		return "", err
+		return "", fmt.Errorf("%w: ciphertext: %v", ErrInvalidHex, err)
 	}
 	nonce, err := hex.DecodeString(ivHex)
 	if err != nil {
-		return "", err
+		return "", fmt.Errorf("%w: IV: %v", ErrInvalidHex, err)
 	}
 
-	block, err := aes.NewCipher(encryptionKey)
+	aesgcm, err := newGCM(key)
 	if err != nil {
 		return "", err
 	}
-
-	aesgcm, err := cipher.NewGCM(block)
-	if err != nil {
-		return "", err
+	// Open panics on a wrong-sized nonce rather than returning an error
+	if len(nonce) != aesgcm.NonceSize() {
+		return "", fmt.Errorf("%w: IV is %d bytes, want %d", ErrInvalidHex, len(nonce), aesgcm.NonceSize())
 	}
 
 	// Open decrypts and authenticates. ciphertext includes tag.
 	plaintext, err := aesgcm.Open(nil, nonce, ciphertext, nil)
 	if err != nil {
-		return "", err
+		return "", ErrDecryptAuth
 	}
 
 	return string(plaintext), nil
 }
 
 func Encrypt(plaintext string) (string, string, error) {
-	block, err := aes.NewCipher(encryptionKey)
-	if err != nil {
-		return "", "", err
-	}
-
-	aesgcm, err := cipher.NewGCM(block)
+	aesgcm, err := newGCM(encryptionKey)
 	if err != nil {
 		return "", "", err
 	}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+// useKeys swaps in ENCRYPTION_KEY and ENCRYPTION_KEY_OLD for the rest of the test.
+func useKeys(t *testing.T, current, old []byte) {
+	t.Helper()
+	origCurrent, origOld := encryptionKey, oldEncryptionKey
+	encryptionKey, oldEncryptionKey = current, old
+	t.Cleanup(func() { encryptionKey, oldEncryptionKey = origCurrent, origOld })
+}
+
+func TestDecryptSentinelErrors(t *testing.T) {
+	keyA := bytes.Repeat([]byte{0xa}, 32)
+	keyB := bytes.Repeat([]byte{0xb}, 32)
+	useKeys(t, keyA, nil)
+	enc, iv, err := Encrypt("obs-secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tampered := "ff" + enc[2:]
+	if enc[:2] == "ff" {
+		tampered = "00" + enc[2:]
+	}
+
+	tests := []struct {
+		name    string
+		key     []byte
+		enc, iv string
+		want    error
+	}{
+		{"ciphertext not hex", keyA, "zz" + enc, iv, ErrInvalidHex},
+		{"IV not hex", keyA, enc, "not-hex", ErrInvalidHex},
+		{"IV wrong length", keyA, enc, iv[:8], ErrInvalidHex},
+		{"wrong key", keyB, enc, iv, ErrDecryptAuth},
+		{"tampered ciphertext", keyA, tampered, iv, ErrDecryptAuth},
+		{"bad key size", []byte("short"), enc, iv, ErrKeyInit},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useKeys(t, tt.key, nil)
+			if _, err := Decrypt(tt.enc, tt.iv); !errors.Is(err, tt.want) {
+				t.Errorf("Decrypt error = %v, want %v", err, tt.want)
+			}
+		})
+	}
+
+	useKeys(t, []byte("short"), nil)
+	if _, _, err := Encrypt("obs-secret"); !errors.Is(err, ErrKeyInit) {
+		t.Errorf("Encrypt with a bad key: error = %v, want %v", err, ErrKeyInit)
+	}
+}
+
+func TestDecryptFallsBackToOldKey(t *testing.T) {
+	oldKey := bytes.Repeat([]byte{0x1}, 32)
+	newKey := bytes.Repeat([]byte{0x2}, 32)
+	useKeys(t, oldKey, nil)
+	enc, iv, _ := Encrypt("obs-secret")
+
+	// After rotation the old key still decrypts, and says so
+	useKeys(t, newKey, oldKey)
+	plaintext, stale, err := decryptAnyKey(enc, iv)
+	if err != nil || plaintext != "obs-secret" || !stale {
+		t.Errorf("decryptAnyKey = %q, %v, %v; want obs-secret, true, nil", plaintext, stale, err)
+	}
+
+	// Without the old key the value is undecryptable
+	useKeys(t, newKey, nil)
+	if _, err := Decrypt(enc, iv); !errors.Is(err, ErrDecryptAuth) {
+		t.Errorf("Decrypt without the old key: error = %v, want %v", err, ErrDecryptAuth)
+	}
+}
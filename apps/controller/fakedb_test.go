@@ -0,0 +1,162 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"io"
+	"sync"
+	"testing"
+)
+
+// fakeHandler answers one query or exec for a fake database: the columns and
+// rows to return, or an error. An exec reports len(rows) rows affected.
+// Transactions reach it as the statements BEGIN, COMMIT and ROLLBACK.
+type fakeHandler func(query string, args []driver.Value) (columns []string, rows [][]driver.Value, err error)
+
+var (
+	fakeDBMu  sync.Mutex
+	fakeDBs   = map[string]fakeHandler{}
+	fakeDBSeq int
+)
+
+func init() {
+	sql.Register("fakedb", fakeDriver{})
+}
+
+// newFakeDB returns a *sql.DB whose every statement is answered by h.
+func newFakeDB(t *testing.T, h fakeHandler) *sql.DB {
+	t.Helper()
+	fakeDBMu.Lock()
+	fakeDBSeq++
+	dsn := fmt.Sprintf("%s#%d", t.Name(), fakeDBSeq)
+	fakeDBs[dsn] = h
+	fakeDBMu.Unlock()
+
+	db, err := sql.Open("fakedb", dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		db.Close()
+		fakeDBMu.Lock()
+		delete(fakeDBs, dsn)
+		fakeDBMu.Unlock()
+	})
+	return db
+}
+
+type fakeDriver struct{}
+
+func (fakeDriver) Open(dsn string) (driver.Conn, error) {
+	fakeDBMu.Lock()
+	h, ok := fakeDBs[dsn]
+	fakeDBMu.Unlock()
+	if !ok {
+		return nil, fmt.Errorf("fakedb: unknown database %q", dsn)
+	}
+	return &fakeConn{h: h}, nil
+}
+
+type fakeConn struct{ h fakeHandler }
+
+func (c *fakeConn) Prepare(query string) (driver.Stmt, error) {
+	return &fakeStmt{h: c.h, query: query}, nil
+}
+func (c *fakeConn) Close() error { return nil }
+
+func (c *fakeConn) Begin() (driver.Tx, error) {
+	if _, _, err := c.h("BEGIN", nil); err != nil {
+		return nil, err
+	}
+	return fakeTx{h: c.h}, nil
+}
+
+type fakeTx struct{ h fakeHandler }
+
+func (tx fakeTx) Commit() error {
+	_, _, err := tx.h("COMMIT", nil)
+	return err
+}
+
+func (tx fakeTx) Rollback() error {
+	_, _, err := tx.h("ROLLBACK", nil)
+	return err
+}
+
+type fakeStmt struct {
+	h     fakeHandler
+	query string
+}
+
+func (s *fakeStmt) Close() error  { return nil }
+func (s *fakeStmt) NumInput() int { return -1 }
+
+func (s *fakeStmt) Exec(args []driver.Value) (driver.Result, error) {
+	_, rows, err := s.h(s.query, args)
+	if err != nil {
+		return nil, err
+	}
+	return driver.RowsAffected(len(rows)), nil
+}
+
+func (s *fakeStmt) Query(args []driver.Value) (driver.Rows, error) {
+	cols, rows, err := s.h(s.query, args)
+	if err != nil {
+		return nil, err
+	}
+	return &fakeRows{cols: cols, rows: rows}, nil
+}
+
+type fakeRows struct {
+	cols []string
+	rows [][]driver.Value
+	next int
+}
+
+func (r *fakeRows) Columns() []string { return r.cols }
+func (r *fakeRows) Close() error      { return nil }
+
+func (r *fakeRows) Next(dest []driver.Value) error {
+	if r.next >= len(r.rows) {
+		return io.EOF
+	}
+	copy(dest, r.rows[r.next])
+	r.next++
+	return nil
+}
+
+// newBlockingDB returns a *sql.DB whose every statement hangs until its
+// context is done, like a database that stopped answering.
+func newBlockingDB(t *testing.T) *sql.DB {
+	t.Helper()
+	db := sql.OpenDB(blockingConnector{})
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+type blockingConnector struct{}
+
+func (blockingConnector) Connect(context.Context) (driver.Conn, error) { return blockingConn{}, nil }
+func (blockingConnector) Driver() driver.Driver                        { return fakeDriver{} }
+
+type blockingConn struct{}
+
+func (blockingConn) Prepare(query string) (driver.Stmt, error) {
+	return nil, fmt.Errorf("blockingdb: prepare not supported")
+}
+func (blockingConn) Close() error { return nil }
+func (blockingConn) Begin() (driver.Tx, error) {
+	return nil, fmt.Errorf("blockingdb: transactions not supported")
+}
+
+func (blockingConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+func (blockingConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/docker/docker/client"
+)
+
+// fakeDockerAPIVersion is the API version the fake Docker client negotiates;
+// request paths reach the handler with the "/v1.43" prefix stripped.
+const fakeDockerAPIVersion = "1.43"
+
+// newFakeDocker returns a Docker client talking to an httptest server that
+// hands every request to h.
+func newFakeDocker(t *testing.T, h http.HandlerFunc) *client.Client {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.URL.Path = strings.TrimPrefix(r.URL.Path, "/v"+fakeDockerAPIVersion)
+		w.Header().Set("API-Version", fakeDockerAPIVersion)
+		w.Header().Set("Content-Type", "application/json")
+		h(w, r)
+	}))
+	t.Cleanup(srv.Close)
+	cli, err := client.NewClientWithOpts(client.WithHost("tcp://"+strings.TrimPrefix(srv.URL, "http://")), client.WithVersion(fakeDockerAPIVersion))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { cli.Close() })
+	return cli
+}
+
+// createdContainer is the part of a container create request tests look at.
+type createdContainer struct {
+	Image  string
+	Env    []string
+	Labels map[string]string
+}
+
+// env returns the value of key in the container's environment.
+func (c createdContainer) env(key string) string {
+	for _, kv := range c.Env {
+		if k, v, ok := strings.Cut(kv, "="); ok && k == key {
+			return v
+		}
+	}
+	return ""
+}
+
+// fakeContainers is a Docker daemon with no containers that records what the
+// controller creates, starts, stops and removes. Created containers are not
+// inspectable, so each test starts from "container missing".
+type fakeContainers struct {
+	mu      sync.Mutex
+	calls   []string // "METHOD /path" in order
+	created map[string]createdContainer
+}
+
+func newFakeContainers(t *testing.T) (*client.Client, *fakeContainers) {
+	f := &fakeContainers{created: make(map[string]createdContainer)}
+	cli := newFakeDocker(t, func(w http.ResponseWriter, r *http.Request) {
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		f.calls = append(f.calls, r.Method+" "+r.URL.Path)
+		switch {
+		case r.Method == "POST" && r.URL.Path == "/containers/create":
+			var body createdContainer
+			json.NewDecoder(r.Body).Decode(&body)
+			name := r.URL.Query().Get("name")
+			f.created[name] = body
+			w.Write([]byte(`{"Id":"` + name + `"}`))
+		case r.Method == "POST" && strings.HasSuffix(r.URL.Path, "/start"):
+			w.WriteHeader(http.StatusNoContent)
+		case r.Method == "GET" && r.URL.Path == "/containers/json":
+			w.Write([]byte("[]"))
+		case r.Method == "DELETE" || strings.HasSuffix(r.URL.Path, "/stop"):
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"message":"No such container"}`))
+		}
+	})
+	return cli, f
+}
+
+// called reports whether a "METHOD /path" call was made.
+func (f *fakeContainers) called(call string) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, c := range f.calls {
+		if c == call {
+			return true
+		}
+	}
+	return false
+}
+
+// container returns what was created under name.
+func (f *fakeContainers) container(name string) (createdContainer, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	c, ok := f.created[name]
+	return c, ok
+}
+
+// fakeRunningRelay returns a Docker client whose only container is channel's
+// relay, already running image with the current control secret, so
+// EnsureRelayRunning goes straight to posting its update.
+func fakeRunningRelay(t *testing.T, channel, image string) *client.Client {
+	t.Helper()
+	name := "relay-" + channel
+	return newFakeDocker(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "GET" && r.URL.Path == "/containers/"+name+"/json" {
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"Id":     name,
+				"Config": map[string]interface{}{"Image": image, "Labels": map[string]string{"relay_auth": relayAuthLabel(channel)}},
+				"State":  map[string]interface{}{"Running": true},
+			})
+			return
+		}
+		t.Errorf("unexpected Docker call %s %s", r.Method, r.URL.Path)
+	})
+}
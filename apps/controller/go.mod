@@ -7,6 +7,7 @@ toolchain go1.24.11
 require (
 	github.com/docker/docker v27.0.0+incompatible
 	github.com/lib/pq v1.10.9
+	golang.org/x/crypto v0.47.0
 )
 
 require (
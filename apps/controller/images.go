@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"strings"
+
+	"github.com/docker/docker/api/types/image"
+	"github.com/docker/docker/api/types/registry"
+	"github.com/docker/docker/client"
+)
+
+// pullMessage is the part of Docker's JSON pull progress stream we log.
+type pullMessage struct {
+	ID     string `json:"id"`
+	Status string `json:"status"`
+	Error  string `json:"error"`
+}
+
+// isMissingImage reports whether a container create failed because its image
+// isn't present locally.
+func isMissingImage(err error) bool {
+	return err != nil && (client.IsErrNotFound(err) || strings.Contains(err.Error(), "No such image"))
+}
+
+// registryAuth encodes REGISTRY_USERNAME/REGISTRY_PASSWORD for ImagePull, or
+// returns "" to pull anonymously.
+func (c *Controller) registryAuth() (string, error) {
+	if c.Config.RegistryUsername == "" {
+		return "", nil
+	}
+	return registry.EncodeAuthConfig(registry.AuthConfig{
+		Username:      c.Config.RegistryUsername,
+		Password:      c.Config.RegistryPassword,
+		ServerAddress: c.Config.RegistryServer,
+	})
+}
+
+// ensureImage pulls ref unless it is already present locally.
+func (c *Controller) ensureImage(ctx context.Context, ref string) error {
+	if _, _, err := c.Docker.ImageInspectWithRaw(ctx, ref); err == nil {
+		return nil
+	} else if !client.IsErrNotFound(err) {
+		return fmt.Errorf("inspect image %s: %v", ref, err)
+	}
+	return c.pullImage(ctx, ref)
+}
+
+// pullImage pulls ref, logging its progress. Pulls are serialized so channels
+// that share an image, and hit a missing image in the same reconcile cycle,
+// pull it only once.
+func (c *Controller) pullImage(ctx context.Context, ref string) error {
+	c.pullMu.Lock()
+	defer c.pullMu.Unlock()
+	if _, _, err := c.Docker.ImageInspectWithRaw(ctx, ref); err == nil {
+		return nil // Pulled while we waited
+	}
+
+	auth, err := c.registryAuth()
+	if err != nil {
+		return fmt.Errorf("encode registry credentials: %v", err)
+	}
+	c.Log("info", "docker", fmt.Sprintf("Pulling image %s", ref))
+	rc, err := c.Docker.ImagePull(ctx, ref, image.PullOptions{RegistryAuth: auth})
+	if err != nil {
+		c.Log("error", "docker", fmt.Sprintf("Failed to pull image %s: %v", ref, err))
+		return err
+	}
+	defer rc.Close()
+
+	// Per-layer download/extract ticks are too chatty; print each layer's
+	// state changes to stdout and keep only overall status in the log buffer
+	dec := json.NewDecoder(rc)
+	lastStatus := map[string]string{}
+	for {
+		var msg pullMessage
+		if err := dec.Decode(&msg); err == io.EOF {
+			break
+		} else if err != nil {
+			c.Log("error", "docker", fmt.Sprintf("Failed to pull image %s: %v", ref, err))
+			return err
+		}
+		if msg.Error != "" {
+			c.Log("error", "docker", fmt.Sprintf("Failed to pull image %s: %s", ref, msg.Error))
+			return fmt.Errorf("pull %s: %s", ref, msg.Error)
+		}
+		if msg.Status == "" || lastStatus[msg.ID] == msg.Status {
+			continue
+		}
+		lastStatus[msg.ID] = msg.Status
+		if msg.ID != "" {
+			log.Printf("[DOCKER] Pull %s: %s %s", ref, msg.ID, msg.Status)
+		} else {
+			c.Log("info", "docker", fmt.Sprintf("Pull %s: %s", ref, msg.Status))
+		}
+	}
+	c.Log("info", "docker", fmt.Sprintf("Pulled image %s", ref))
+	return nil
+}
+
+// ensureImages makes sure the loop, relay and FFmpeg images are present at
+// startup, so the first channel on a fresh host doesn't fail to start.
+// Failures are only logged; container creation retries the pull.
+func (c *Controller) ensureImages() {
+	for _, ref := range []string{c.Config.LoopImage, c.Config.RelayImage, c.Config.FFmpegImage} {
+		if err := c.ensureImage(context.Background(), ref); err != nil {
+			c.Log("warn", "docker", fmt.Sprintf("Image %s is not available yet: %v", ref, err))
+		}
+	}
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/docker/docker/api/types/registry"
+	"github.com/docker/docker/client"
+)
+
+// fakeRegistry is a Docker daemon that starts without the given images and
+// gains each one when it is pulled. Container creates fail with "No such
+// image" until their image is present.
+type fakeRegistry struct {
+	mu      sync.Mutex
+	present map[string]bool
+	pulls   []string // fromImage:tag in order
+	auth    []string // X-Registry-Auth of each pull
+	pullErr string   // reported in the progress stream, if set
+	started []string
+}
+
+func newFakeRegistry(t *testing.T) (*client.Client, *fakeRegistry) {
+	f := &fakeRegistry{present: make(map[string]bool)}
+	cli := newFakeDocker(t, func(w http.ResponseWriter, r *http.Request) {
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		switch {
+		case r.Method == "GET" && strings.HasPrefix(r.URL.Path, "/images/") && strings.HasSuffix(r.URL.Path, "/json"):
+			ref := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/images/"), "/json")
+			if !f.present[ref] {
+				w.WriteHeader(http.StatusNotFound)
+				w.Write([]byte(`{"message":"No such image: ` + ref + `"}`))
+				return
+			}
+			w.Write([]byte(`{"Id":"sha256:` + ref + `"}`))
+		case r.Method == "POST" && r.URL.Path == "/images/create":
+			ref := r.URL.Query().Get("fromImage") + ":" + r.URL.Query().Get("tag")
+			f.pulls = append(f.pulls, ref)
+			f.auth = append(f.auth, r.Header.Get("X-Registry-Auth"))
+			enc := json.NewEncoder(w)
+			enc.Encode(pullMessage{Status: "Pulling from " + ref})
+			enc.Encode(pullMessage{ID: "layer1", Status: "Downloading"})
+			enc.Encode(pullMessage{ID: "layer1", Status: "Downloading"})
+			if f.pullErr != "" {
+				enc.Encode(pullMessage{Error: f.pullErr})
+				return
+			}
+			enc.Encode(pullMessage{ID: "layer1", Status: "Pull complete"})
+			f.present[ref] = true
+		case r.Method == "POST" && r.URL.Path == "/containers/create":
+			var body createdContainer
+			json.NewDecoder(r.Body).Decode(&body)
+			if !f.present[body.Image] {
+				w.WriteHeader(http.StatusNotFound)
+				w.Write([]byte(`{"message":"No such image: ` + body.Image + `"}`))
+				return
+			}
+			w.Write([]byte(`{"Id":"` + r.URL.Query().Get("name") + `"}`))
+		case r.Method == "POST" && strings.HasSuffix(r.URL.Path, "/start"):
+			f.started = append(f.started, strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/containers/"), "/start"))
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"message":"No such container"}`))
+		}
+	})
+	return cli, f
+}
+
+func TestEnsureImagePullsMissing(t *testing.T) {
+	docker, reg := newFakeRegistry(t)
+	reg.present["relay-manager:stable"] = true
+	c := newTestController(&Config{RegistryUsername: "deploy", RegistryPassword: "s3cret", RegistryServer: "registry.example"}, nil)
+	c.Docker = docker
+
+	if err := c.ensureImage(context.Background(), "relay-manager:stable"); err != nil {
+		t.Fatalf("present image: %v", err)
+	}
+	if len(reg.pulls) != 0 {
+		t.Fatalf("present image was pulled: %q", reg.pulls)
+	}
+
+	if err := c.ensureImage(context.Background(), "loop-publisher:stable"); err != nil {
+		t.Fatalf("missing image: %v", err)
+	}
+	if len(reg.pulls) != 1 || reg.pulls[0] != "loop-publisher:stable" {
+		t.Fatalf("pulls = %q, want [loop-publisher:stable]", reg.pulls)
+	}
+	raw, err := base64.URLEncoding.DecodeString(reg.auth[0])
+	if err != nil {
+		t.Fatalf("registry auth %q: %v", reg.auth[0], err)
+	}
+	var auth registry.AuthConfig
+	json.Unmarshal(raw, &auth)
+	if auth.Username != "deploy" || auth.Password != "s3cret" || auth.ServerAddress != "registry.example" {
+		t.Errorf("registry auth = %+v, want the configured credentials", auth)
+	}
+
+	// Pulled once, it is not pulled again
+	if err := c.ensureImage(context.Background(), "loop-publisher:stable"); err != nil || len(reg.pulls) != 1 {
+		t.Errorf("second ensure: err = %v, pulls = %q", err, reg.pulls)
+	}
+}
+
+func TestEnsureImagePullError(t *testing.T) {
+	docker, reg := newFakeRegistry(t)
+	reg.pullErr = "manifest unknown"
+	c := newTestController(&Config{}, nil)
+	c.Docker = docker
+
+	err := c.ensureImage(context.Background(), "loop-publisher:missing")
+	if err == nil || !strings.Contains(err.Error(), "manifest unknown") {
+		t.Fatalf("err = %v, want the error from the pull stream", err)
+	}
+	if reg.auth[0] != "" {
+		t.Errorf("anonymous pull sent registry auth %q", reg.auth[0])
+	}
+}
+
+func TestCreateMissingImagePullsAndRetries(t *testing.T) {
+	docker, reg := newFakeRegistry(t)
+	c := newTestController(&Config{LoopImage: "loop-publisher:stable"}, nil)
+	c.Docker = docker
+	c.Media = &localMediaStore{dir: t.TempDir()}
+
+	c.EnsureContainerRunning(Channel{Name: "news", SourceMode: "testpattern", LoopToken: "loop-secret"}, "loop-news")
+	if len(reg.pulls) != 1 || reg.pulls[0] != "loop-publisher:stable" {
+		t.Errorf("pulls = %q, want [loop-publisher:stable]", reg.pulls)
+	}
+	if len(reg.started) != 1 || reg.started[0] != "loop-news" {
+		t.Errorf("started = %q, want the loop container started after the pull", reg.started)
+	}
+}
@@ -0,0 +1,3759 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/tls"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"io"
+	"mime/multipart"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+	"unicode/utf8"
+
+	"github.com/docker/docker/client"
+)
+
+func TestIPInAllowlist(t *testing.T) {
+	entries := []AllowlistEntry{{CIDR: "203.0.113.0/24"}, {CIDR: "198.51.100.7"}}
+	tests := []struct {
+		name    string
+		ip      string
+		entries []AllowlistEntry
+		want    bool
+	}{
+		{"inside CIDR", "203.0.113.42", entries, true},
+		{"exact IP", "198.51.100.7", entries, true},
+		{"not listed", "192.0.2.1", entries, false},
+		{"unparseable IP", "not-an-ip", entries, false},
+		{"empty allowlist allows all", "192.0.2.1", nil, true},
+	}
+	for _, tt := range tests {
+		if got := ipInAllowlist(tt.ip, tt.entries); got != tt.want {
+			t.Errorf("%s: ipInAllowlist(%q) = %v, want %v", tt.name, tt.ip, got, tt.want)
+		}
+	}
+}
+
+func TestOnPublishHandlerAllowlist(t *testing.T) {
+	obsHash, loopHash := HashToken("obs-secret"), HashToken("loop-secret")
+	outside := [][]driver.Value{{int64(1), int64(7), "198.51.100.0/24", "", time.Now()}}
+	tests := []struct {
+		name      string
+		stream    string
+		token     string
+		allowlist [][]driver.Value
+		listErr   error
+		want      int
+	}{
+		{"empty allowlist", "news-obs", "obs-secret", nil, nil, http.StatusOK},
+		{"allowed IP", "news-obs", "obs-secret", [][]driver.Value{{int64(1), int64(7), "203.0.113.0/24", "", time.Now()}}, nil, http.StatusOK},
+		{"disallowed IP", "news-obs", "obs-secret", outside, nil, http.StatusForbidden},
+		{"allowlist unreadable", "news-obs", "obs-secret", nil, errors.New("connection reset"), http.StatusInternalServerError},
+		// The loop publishes from inside the platform, not from an encoder
+		{"loop from an unlisted IP", "news", "loop-secret", outside, nil, http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := newFakeDB(t, func(query string, args []driver.Value) ([]string, [][]driver.Value, error) {
+				switch {
+				case strings.Contains(query, "FROM channels WHERE name"):
+					return []string{"id", "name", "obs_token_hash", "loop_token_hash", "obs_token", "loop_token", "obs_override_enabled"},
+						[][]driver.Value{{int64(7), "news", obsHash, loopHash, "", "", true}}, nil
+				case strings.Contains(query, "FROM channel_ip_allowlist"):
+					return []string{"id", "channel_id", "cidr", "description", "created_at"}, tt.allowlist, tt.listErr
+				}
+				return nil, nil, nil
+			})
+			c := newTestController(&Config{AllowDuplicatePubs: true}, db)
+			c.Docker = newFakeDocker(t, func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusNoContent)
+			})
+
+			body := fmt.Sprintf(`{"action":"on_publish","app":"live","stream":%q,"param":"?token=%s","ip":"203.0.113.9"}`, tt.stream, tt.token)
+			rec := httptest.NewRecorder()
+			c.OnPublishHandler(rec, httptest.NewRequest("POST", "/api/hooks/on_publish", strings.NewReader(body)))
+			if rec.Code != tt.want {
+				t.Errorf("status = %d, want %d (%s)", rec.Code, tt.want, strings.TrimSpace(rec.Body.String()))
+			}
+		})
+	}
+}
+
+func TestOnPublishHandlerRejectsDuplicate(t *testing.T) {
+	loopHash := HashToken("loop-secret")
+	tests := []struct {
+		name      string
+		cid       string
+		allowDups bool
+		want      int
+	}{
+		{"second publisher", "client-2", false, http.StatusForbidden},
+		{"same publisher reconnecting hook", "client-1", false, http.StatusOK},
+		{"backup encoders allowed", "client-2", true, http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var audited bool
+			db := newFakeDB(t, func(query string, args []driver.Value) ([]string, [][]driver.Value, error) {
+				switch {
+				case strings.Contains(query, "FROM channels WHERE name"):
+					return []string{"id", "name", "obs_token_hash", "loop_token_hash", "obs_token", "loop_token", "obs_override_enabled"},
+						[][]driver.Value{{int64(7), "news", nil, loopHash, "", "", true}}, nil
+				case strings.Contains(query, "FROM channel_ip_allowlist"):
+					return []string{"id", "channel_id", "cidr", "description", "created_at"}, nil, nil
+				case strings.Contains(query, "INSERT INTO audit_logs") && args[0] == "STREAM_PUBLISH_REJECTED":
+					audited = true
+				}
+				return nil, nil, nil
+			})
+			live := SRSStream{Name: "news", App: "live"}
+			live.Publish.Active = true
+			live.Publish.CID = "client-1"
+			// The SRS URL is unreachable, so the check must come from the cache
+			c := &Controller{
+				Config:        &Config{SRSApp: "live", SRSApiURL: "http://127.0.0.1:1", CheckInterval: time.Minute, AllowDuplicatePubs: tt.allowDups},
+				DB:            db,
+				srsSnapshot:   map[string]SRSStream{"news": live},
+				srsSnapshotAt: time.Now(),
+			}
+
+			body := fmt.Sprintf(`{"action":"on_publish","app":"live","stream":"news","param":"?token=loop-secret","ip":"203.0.113.9","client_id":%q}`, tt.cid)
+			rec := httptest.NewRecorder()
+			c.OnPublishHandler(rec, httptest.NewRequest("POST", "/api/hooks/on_publish", strings.NewReader(body)))
+			if rec.Code != tt.want {
+				t.Errorf("status = %d, want %d (%s)", rec.Code, tt.want, strings.TrimSpace(rec.Body.String()))
+			}
+			if audited != (tt.want == http.StatusForbidden) {
+				t.Errorf("rejection audited = %v, want %v", audited, tt.want == http.StatusForbidden)
+			}
+		})
+	}
+}
+
+func TestRepublishAfterUnpublish(t *testing.T) {
+	loopHash := HashToken("loop-secret")
+	db := newFakeDB(t, func(query string, args []driver.Value) ([]string, [][]driver.Value, error) {
+		switch {
+		case strings.Contains(query, "FROM channels WHERE name") && strings.Contains(query, "obs_token_hash"):
+			return []string{"id", "name", "obs_token_hash", "loop_token_hash", "obs_token", "loop_token", "obs_override_enabled"},
+				[][]driver.Value{{int64(7), "news", nil, loopHash, "", "", true}}, nil
+		case strings.Contains(query, "SELECT obs_token FROM channels"):
+			return []string{"obs_token"}, [][]driver.Value{{"obs-secret"}}, nil
+		case strings.Contains(query, "FROM channel_ip_allowlist"):
+			return []string{"id", "channel_id", "cidr", "description", "created_at"}, nil, nil
+		}
+		return nil, nil, nil
+	})
+	live := SRSStream{Name: "news", App: "live"}
+	live.Publish.Active = true
+	live.Publish.CID = "client-1"
+	// The SRS URL is unreachable and the snapshot fresh, so the duplicate
+	// check goes by the cache the unpublish hook has to correct
+	c := &Controller{
+		Config:        &Config{SRSApp: "live", SRSApiURL: "http://127.0.0.1:1", CheckInterval: time.Minute},
+		DB:            db,
+		srsSnapshot:   map[string]SRSStream{"news": live},
+		srsSnapshotAt: time.Now(),
+	}
+	hook := func(handler http.HandlerFunc, action, cid string) int {
+		body := fmt.Sprintf(`{"action":%q,"app":"live","stream":"news","param":"?token=loop-secret","ip":"203.0.113.9","client_id":%q}`, action, cid)
+		rec := httptest.NewRecorder()
+		handler(rec, httptest.NewRequest("POST", "/api/hooks/"+action, strings.NewReader(body)))
+		return rec.Code
+	}
+
+	// Another client leaving doesn't clear the live publisher
+	hook(c.OnUnpublishHandler, "on_unpublish", "client-3")
+	if code := hook(c.OnPublishHandler, "on_publish", "client-2"); code != http.StatusForbidden {
+		t.Errorf("publish while client-1 is live: status = %d, want 403", code)
+	}
+
+	hook(c.OnUnpublishHandler, "on_unpublish", "client-1")
+	if code := hook(c.OnPublishHandler, "on_publish", "client-2"); code != http.StatusOK {
+		t.Errorf("republish right after unpublish: status = %d, want 200", code)
+	}
+}
+
+func TestChannelsHandlerOrgScoping(t *testing.T) {
+	tests := []struct {
+		name, role, userOrg, query, want string
+	}{
+		{"operator sees own org", RoleOperator, "org-a", "", "org-a"},
+		{"operator can't pick another org", RoleOperator, "org-a", "?org=org-b", "org-a"},
+		{"admin picks an org", RoleAdmin, "org-a", "?org=org-b", "org-b"},
+		{"admin defaults to own org", RoleAdmin, "org-a", "", "org-a"},
+		{"admin without an org lists everything", RoleAdmin, "", "", ""},
+		{"no org sees nothing", RoleViewer, "", "?org=org-b", "<not queried>"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got interface{} = "<not queried>"
+			db := newFakeDB(t, func(query string, args []driver.Value) ([]string, [][]driver.Value, error) {
+				if strings.Contains(query, "FROM channels") {
+					got = args[0]
+				}
+				return []string{"id"}, nil, nil
+			})
+			c := &Controller{Config: &Config{}, DB: db}
+			req := httptest.NewRequest("GET", "/api/channels"+tt.query, nil)
+			req = withIdentity(req, Identity{Role: tt.role, Org: tt.userOrg})
+			rec := httptest.NewRecorder()
+			c.ChannelsHandler(rec, req)
+			if rec.Code != http.StatusOK {
+				t.Fatalf("status = %d", rec.Code)
+			}
+			if got != tt.want {
+				t.Errorf("listed org %v, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestChannelsHandlerCreateOrg(t *testing.T) {
+	tests := []struct {
+		name, role, userOrg, bodyOrg string
+		wantCode                     int
+		wantOrg                      string
+	}{
+		{"operator creates in own org", RoleOperator, "org-a", "", http.StatusOK, "org-a"},
+		{"operator can't create in another org", RoleOperator, "org-a", "org-b", http.StatusForbidden, ""},
+		{"admin creates in any org", RoleAdmin, "org-a", "org-b", http.StatusOK, "org-b"},
+		{"operator without an org can't create", RoleOperator, "", "", http.StatusForbidden, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var inserted interface{}
+			db := newFakeDB(t, func(query string, args []driver.Value) ([]string, [][]driver.Value, error) {
+				switch {
+				case strings.Contains(query, "SELECT EXISTS"):
+					return []string{"exists"}, [][]driver.Value{{false}}, nil
+				case strings.Contains(query, "FROM organizations"):
+					return []string{"id"}, [][]driver.Value{{args[0]}}, nil
+				case strings.Contains(query, "INSERT INTO channels"):
+					inserted = args[10]
+					return []string{"id"}, [][]driver.Value{{int64(1)}}, nil
+				}
+				return []string{"value"}, nil, nil
+			})
+			c := &Controller{Config: &Config{}, DB: db}
+			body := `{"name":"news","display_name":"News","organization_id":"` + tt.bodyOrg + `"}`
+			req := httptest.NewRequest("POST", "/api/channels", strings.NewReader(body))
+			req = withIdentity(req, Identity{Role: tt.role, Org: tt.userOrg})
+			rec := httptest.NewRecorder()
+			c.ChannelsHandler(rec, req)
+			if rec.Code != tt.wantCode {
+				t.Fatalf("status = %d, want %d (%s)", rec.Code, tt.wantCode, strings.TrimSpace(rec.Body.String()))
+			}
+			if tt.wantOrg != "" && inserted != tt.wantOrg {
+				t.Errorf("created in org %v, want %q", inserted, tt.wantOrg)
+			}
+		})
+	}
+}
+
+func TestChannelActionHandlerOtherOrg(t *testing.T) {
+	db := newFakeDB(t, func(query string, args []driver.Value) ([]string, [][]driver.Value, error) {
+		if strings.Contains(query, "organization_id") {
+			return []string{"organization_id"}, [][]driver.Value{{"org-b"}}, nil
+		}
+		if strings.Contains(query, "failover_timeout_seconds FROM channels") {
+			return []string{"id", "name", "failover_timeout_seconds"}, [][]driver.Value{{int64(1), "news", int64(10)}}, nil
+		}
+		t.Fatalf("unexpected query after the org check: %q", query)
+		return nil, nil, nil
+	})
+	c := &Controller{Config: &Config{}, DB: db}
+	for _, id := range []Identity{
+		{Role: RoleOperator, Org: "org-a"},
+		{Role: RoleOperator}, // in no organization, so owning no channels
+	} {
+		for _, tc := range []struct{ method, path string }{
+			{"GET", "/api/channels/1"},
+			{"PUT", "/api/channels/1"},
+			{"DELETE", "/api/channels/1"},
+			{"POST", "/api/channels/1/enable"},
+		} {
+			req := httptest.NewRequest(tc.method, tc.path, strings.NewReader(`{}`))
+			req = withIdentity(req, id)
+			rec := httptest.NewRecorder()
+			c.ChannelActionHandler(rec, req)
+			if rec.Code != http.StatusNotFound {
+				t.Errorf("org %q %s %s: status = %d, want 404", id.Org, tc.method, tc.path, rec.Code)
+			}
+		}
+
+		req := withIdentity(httptest.NewRequest("POST", "/api/takeover/news", nil), id)
+		rec := httptest.NewRecorder()
+		c.TakeoverHandler(rec, req)
+		if rec.Code != http.StatusNotFound {
+			t.Errorf("org %q takeover: status = %d, want 404", id.Org, rec.Code)
+		}
+	}
+
+	// Viewers may not take a channel over at all
+	req := withIdentity(httptest.NewRequest("POST", "/api/takeover/news", nil), Identity{Role: RoleViewer, Org: "org-b"})
+	rec := httptest.NewRecorder()
+	c.TakeoverHandler(rec, req)
+	if rec.Code != http.StatusForbidden {
+		t.Errorf("viewer takeover: status = %d, want 403", rec.Code)
+	}
+}
+
+func TestRedactSecrets(t *testing.T) {
+	tests := []struct{ in, want string }{
+		{`{"app":"live","stream":"news-obs","param":"?token=s3cret","ip":"1.2.3.4"}`, `{"app":"live","stream":"news-obs","param":"?token=****","ip":"1.2.3.4"}`},
+		{"push to rtmp://a.rtmp.youtube.com/live2/abcd-efgh failed", "push to rtmp://a.rtmp.youtube.com/live2/**** failed"},
+		{"srt://host:9000?streamid=x&key=k1", "srt://host:9000?streamid=x&key=****"},
+		{"nothing to hide", "nothing to hide"},
+	}
+	for _, tt := range tests {
+		if got := redactSecrets(tt.in); got != tt.want {
+			t.Errorf("redactSecrets(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestOnUnpublishHandlerFailsBackToLoop(t *testing.T) {
+	db := newFakeDB(t, func(query string, args []driver.Value) ([]string, [][]driver.Value, error) {
+		if strings.Contains(query, "SELECT obs_token FROM channels") {
+			return []string{"obs_token"}, [][]driver.Value{{"obs-secret"}}, nil
+		}
+		return nil, nil, nil
+	})
+	c := &Controller{
+		Config:           &Config{},
+		DB:               db,
+		takeoverCooldown: map[string]time.Time{"news": time.Now()},
+		activeSourceMap:  map[string]string{"news": "OBS"},
+		sourceSwitchedAt: map[string]time.Time{},
+		reconcileWake:    make(chan struct{}, 1),
+		wakeChannels:     map[string]bool{},
+	}
+
+	body := `{"action":"on_unpublish","stream":"news-obs","param":"?token=obs-secret"}`
+	c.OnUnpublishHandler(httptest.NewRecorder(), httptest.NewRequest("POST", "/api/hooks/on_unpublish", strings.NewReader(body)))
+
+	if got := c.activeSourceMap["news"]; got != "LOOP" {
+		t.Errorf("active source = %q, want LOOP", got)
+	}
+	if _, ok := c.takeoverCooldown["news"]; ok {
+		t.Error("takeover cooldown not cleared")
+	}
+	select {
+	case <-c.reconcileWake:
+	default:
+		t.Fatal("reconciler not woken")
+	}
+	if !c.wakeChannels["news"] {
+		t.Errorf("news not queued for reconcile: %v", c.wakeChannels)
+	}
+}
+
+func TestRequestReconcileMergesPendingRequests(t *testing.T) {
+	c := &Controller{reconcileWake: make(chan struct{}, 1), wakeChannels: map[string]bool{}}
+	c.requestReconcile("a")
+	c.requestReconcile("b") // must not block while the first wake is pending
+	if len(c.reconcileWake) != 1 || !c.wakeChannels["a"] || !c.wakeChannels["b"] {
+		t.Errorf("wake=%d channels=%v; want one wake for a and b", len(c.reconcileWake), c.wakeChannels)
+	}
+}
+
+func TestWarmupWaitsForSRS(t *testing.T) {
+	var calls atomic.Int32
+	srs := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) < 3 {
+			http.Error(w, "starting", http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte(`{}`))
+	}))
+	defer srs.Close()
+
+	c := &Controller{Config: &Config{SRSApiURL: srs.URL, SRSStartupWait: time.Minute}}
+	c.warmup()
+	if n := calls.Load(); n != 3 {
+		t.Errorf("warmup returned after %d SRS checks, want 3 (first reachable answer)", n)
+	}
+}
+
+func TestWarmupKeepsHeartbeatFresh(t *testing.T) {
+	srs := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer srs.Close()
+
+	c := &Controller{Config: &Config{SRSApiURL: srs.URL, ReconcileWarmup: 2500 * time.Millisecond}}
+	done := make(chan struct{})
+	go func() {
+		c.warmup()
+		close(done)
+	}()
+
+	time.Sleep(1500 * time.Millisecond)
+	c.hbMu.Lock()
+	beat := c.reconcileDoneAt
+	c.hbMu.Unlock()
+	if age := time.Since(beat); beat.IsZero() || age > 1200*time.Millisecond {
+		t.Errorf("heartbeat is %v old during warmup", age)
+	}
+	<-done
+}
+
+func TestRelaySourceURLExternal(t *testing.T) {
+	c := &Controller{Config: &Config{SRSApp: "live"}}
+	ch := Channel{Name: "news", SourceMode: "external", ExternalSourceURL: "https://cdn.example.com/news/index.m3u8", ActiveSource: "OBS"}
+	if got := c.relaySourceURL(ch); got != ch.ExternalSourceURL {
+		t.Errorf("relaySourceURL on external source = %q, want %q", got, ch.ExternalSourceURL)
+	}
+	ch.ActiveSource = "LOOP"
+	if got, want := c.relaySourceURL(ch), "rtmp://srs:1935/live/news"; got != want {
+		t.Errorf("relaySourceURL on loop = %q, want %q", got, want)
+	}
+}
+
+func TestProbeExternalSourceHTTP(t *testing.T) {
+	for status, wantErr := range map[int]bool{
+		http.StatusOK:               false,
+		http.StatusMethodNotAllowed: false, // no HEAD support, but the server is up
+		http.StatusNotFound:         true,
+		http.StatusBadGateway:       true,
+	} {
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(status)
+		}))
+		err := probeExternalSource(srv.URL + "/live.m3u8")
+		srv.Close()
+		if (err != nil) != wantErr {
+			t.Errorf("status %d: err = %v, want error %v", status, err, wantErr)
+		}
+	}
+}
+
+func TestExternalSourceUpProbesInBackground(t *testing.T) {
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer srv.Close()
+
+	c := &Controller{
+		Config:         &Config{CheckInterval: time.Minute},
+		externalProbes: map[string]*externalProbe{},
+		reconcileWake:  make(chan struct{}, 1),
+		wakeChannels:   map[string]bool{},
+	}
+	ch := Channel{Name: "news", SourceMode: "external", ExternalSourceURL: srv.URL}
+
+	start := time.Now()
+	if c.externalSourceUp(ch) {
+		t.Error("unprobed source reported up")
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("externalSourceUp blocked for %v", elapsed)
+	}
+	close(release)
+
+	select {
+	case <-c.reconcileWake:
+	case <-time.After(5 * time.Second):
+		t.Fatal("reconciler not woken after the source came up")
+	}
+	if !c.externalSourceUp(ch) {
+		t.Error("source still down after a successful probe")
+	}
+}
+
+func TestHealthHandlerFailureModes(t *testing.T) {
+	health := func(c *Controller) (int, map[string]DependencyStatus) {
+		rec := httptest.NewRecorder()
+		c.HealthHandler(rec, httptest.NewRequest("GET", "/health", nil))
+		var body struct {
+			Checks map[string]DependencyStatus `json:"checks"`
+		}
+		json.Unmarshal(rec.Body.Bytes(), &body)
+		return rec.Code, body.Checks
+	}
+
+	c := &Controller{Config: &Config{CheckInterval: 5 * time.Second}, startedAt: time.Now(), reconcileDoneAt: time.Now()}
+	if code, _ := health(c); code != http.StatusOK {
+		t.Errorf("healthy controller: status = %d, want 200", code)
+	}
+
+	c.reconcileDoneAt = time.Now().Add(-time.Hour)
+	if code, checks := health(c); code != http.StatusServiceUnavailable || checks["reconciler"].OK {
+		t.Errorf("stalled reconciler: status = %d, reconciler = %+v", code, checks["reconciler"])
+	}
+	c.reconcileDoneAt = time.Now()
+
+	// A wedged mutex fails liveness, and repeated checks share one probe
+	c.mu.Lock()
+	var wg sync.WaitGroup
+	for i := 0; i < 2; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if code, checks := health(c); code != http.StatusServiceUnavailable || checks["state_lock"].OK {
+				t.Errorf("wedged mutex: status = %d, state_lock = %+v", code, checks["state_lock"])
+			}
+		}()
+	}
+	wg.Wait()
+	c.hbMu.Lock()
+	probe := c.lockProbe
+	c.hbMu.Unlock()
+	if probe == nil {
+		t.Fatal("no state-lock probe pending while mu is held")
+	}
+	c.mu.Unlock()
+	<-probe
+	if code, _ := health(c); code != http.StatusOK {
+		t.Errorf("after mu is released: status = %d, want 200", code)
+	}
+}
+
+func TestReadyHandlerFailureModes(t *testing.T) {
+	up := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("API-Version", "1.43")
+		w.Write([]byte("OK"))
+	}))
+	defer up.Close()
+	down := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "down", http.StatusInternalServerError)
+	}))
+	defer down.Close()
+
+	dockerAt := func(u string) *client.Client {
+		cli, err := client.NewClientWithOpts(client.WithHost("tcp://"+strings.TrimPrefix(u, "http://")), client.WithVersion("1.43"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return cli
+	}
+	goodDB := newFakeDB(t, func(string, []driver.Value) ([]string, [][]driver.Value, error) { return nil, nil, nil })
+	badDB, _ := sql.Open("fakedb", "no-such-database")
+	defer badDB.Close()
+
+	tests := []struct {
+		name   string
+		db     *sql.DB
+		docker string
+		srs    string
+		failed string
+	}{
+		{"all reachable", goodDB, up.URL, up.URL, ""},
+		{"database down", badDB, up.URL, up.URL, "database"},
+		{"docker down", goodDB, down.URL, up.URL, "docker"},
+		{"srs down", goodDB, up.URL, down.URL, "srs"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &Controller{Config: &Config{SRSApiURL: tt.srs}, DB: tt.db, Docker: dockerAt(tt.docker)}
+			rec := httptest.NewRecorder()
+			c.ReadyHandler(rec, httptest.NewRequest("GET", "/ready", nil))
+			var body struct {
+				Checks map[string]DependencyStatus `json:"checks"`
+			}
+			json.Unmarshal(rec.Body.Bytes(), &body)
+
+			want := http.StatusOK
+			if tt.failed != "" {
+				want = http.StatusServiceUnavailable
+			}
+			if rec.Code != want {
+				t.Errorf("status = %d, want %d (%s)", rec.Code, want, rec.Body.String())
+			}
+			for name, st := range body.Checks {
+				if st.OK == (name == tt.failed) {
+					t.Errorf("check %s ok = %v", name, st.OK)
+				}
+			}
+		})
+	}
+}
+
+// newTestController returns a Controller with the in-memory state NewController
+// sets up, without connecting to anything.
+func newTestController(cfg *Config, db *sql.DB) *Controller {
+	c := &Controller{
+		Config:             cfg,
+		DB:                 db,
+		HealthHistory:      make(map[string][]bool),
+		takeoverCooldown:   make(map[string]time.Time),
+		activeSourceMap:    make(map[string]string),
+		manualLoopOverride: make(map[string]bool),
+		sourceSwitchedAt:   make(map[string]time.Time),
+		obsArrived:         make(map[string]bool),
+		reconcileCycles:    make(map[string]int),
+		reconcileWake:      make(chan struct{}, 1),
+		wakeChannels:       make(map[string]bool),
+		externalProbes:     make(map[string]*externalProbe),
+		mediaSyncs:         make(map[string]*mediaSync),
+		abrLowTier:         make(map[string]bool),
+		lastSeenLive:       make(map[string]time.Time),
+		liveBaselines:      make(map[string]liveBaseline),
+		loopCrashes:        make(map[string]*LoopCrash),
+		optimizing:         make(map[string]bool),
+		startingSince:      make(map[string]time.Time),
+		optimizeSlots:      make(chan struct{}, max(cfg.OptimizeConcurrency, 1)),
+		ingestHints:        make(map[string]string),
+		decryptFailures:    make(map[string]string),
+		startedAt:          time.Now(),
+	}
+	if cfg.MaxUploads > 0 {
+		c.uploadSlots = make(chan struct{}, cfg.MaxUploads)
+	}
+	return c
+}
+
+// channelColumns are the columns queryChannels selects, with the values a
+// plain enabled file-loop channel has.
+var channelColumns = []struct {
+	name string
+	def  driver.Value
+}{
+	{"id", int64(1)}, {"name", "news"}, {"display_name", "News"},
+	{"obs_token", "obs-secret"}, {"loop_token", "loop-secret"}, {"loop_source_file", "intro.mp4"},
+	{"source_mode", "file"}, {"playlist_files", "{}"},
+	{"loop_enabled", true}, {"enabled", true}, {"current_active_source", "LOOP"},
+	{"obs_override_enabled", true}, {"auto_restart_loop", true}, {"failover_timeout_seconds", int64(5)},
+	{"obs_token_encrypted", nil}, {"obs_token_iv", nil}, {"loop_token_encrypted", nil}, {"loop_token_iv", nil},
+	{"keyframe_interval", int64(2)}, {"video_bitrate", int64(0)}, {"audio_bitrate", int64(128)},
+	{"output_resolution", ""}, {"output_fps", int64(30)},
+	{"encoder_preset", "ultrafast"}, {"encoder_tune", "zerolatency"},
+	{"obs_rw_timeout_ms", int64(5000)}, {"reconcile_every", int64(1)},
+	{"relay_probesize_kb", int64(32000)}, {"relay_analyzeduration_ms", int64(100)},
+	{"adaptive_bitrate", false}, {"abr_low_bitrate", int64(1000)}, {"abr_high_bitrate", int64(0)},
+	{"abr_viewer_threshold", int64(1)}, {"abr_ladder_enabled", false},
+	{"relay_image", ""}, {"loop_image", ""}, {"ingest_protocol", "rtmp"}, {"recording_enabled", false},
+	{"external_source_url", ""}, {"primary_source", "loop"},
+	{"metadata", []byte("{}")}, {"paused", false}, {"organization_id", ""},
+}
+
+// channelRows answers a queryChannels query with one row per override set;
+// columns not in a set keep their channelColumns default.
+func channelRows(overrides ...map[string]driver.Value) ([]string, [][]driver.Value) {
+	cols := make([]string, len(channelColumns))
+	for i, col := range channelColumns {
+		cols[i] = col.name
+	}
+	var rows [][]driver.Value
+	for _, o := range overrides {
+		row := make([]driver.Value, len(channelColumns))
+		for i, col := range channelColumns {
+			row[i] = col.def
+			if v, ok := o[col.name]; ok {
+				row[i] = v
+			}
+		}
+		rows = append(rows, row)
+	}
+	return cols, rows
+}
+
+// channelOwner answers requireChannelInOrg's ownership lookup as if org owned
+// every channel; ok is false for any other query.
+func channelOwner(query, org string) (cols []string, rows [][]driver.Value, ok bool) {
+	if !strings.Contains(query, "COALESCE(organization_id::text, '') FROM channels") {
+		return nil, nil, false
+	}
+	return []string{"organization_id"}, [][]driver.Value{{org}}, true
+}
+
+// destinationRows answers a GetDestinations query with one row per
+// destination.
+func destinationRows(dests ...Destination) ([]string, [][]driver.Value) {
+	cols := []string{"id", "channel_id", "name", "rtmp_url", "stream_key", "enabled", "paused", "status",
+		"auto_disable", "auto_disabled_reason",
+		"transcode_enabled", "transcode_resolution", "transcode_video_bitrate", "transcode_audio_bitrate"}
+	var rows [][]driver.Value
+	for _, d := range dests {
+		rows = append(rows, []driver.Value{int64(d.ID), int64(d.ChannelID), d.Name, d.RTMPURL, d.StreamKey, d.Enabled, d.Paused, d.Status,
+			d.AutoDisable, d.AutoDisabledReason,
+			d.TranscodeEnabled, d.TranscodeResolution, int64(d.TranscodeVideoBitrate), int64(d.TranscodeAudioBitrate)})
+	}
+	return cols, rows
+}
+
+// routeRelaysTo sends the controller's requests for any relay-<channel>:8080
+// host to srv for the rest of the test.
+func routeRelaysTo(t *testing.T, srv *httptest.Server) {
+	t.Helper()
+	orig := http.DefaultTransport
+	dialer := &net.Dialer{Timeout: time.Second}
+	http.DefaultTransport = &http.Transport{
+		DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			if strings.HasPrefix(addr, "relay-") {
+				addr = srv.Listener.Addr().String()
+			}
+			return dialer.DialContext(ctx, network, addr)
+		},
+	}
+	t.Cleanup(func() { http.DefaultTransport = orig })
+}
+
+// fakeSRS serves streamsJSON as SRS's /api/v1/streams answer.
+func fakeSRS(t *testing.T, streamsJSON string) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"code":0,"server":"test","streams":` + streamsJSON + `}`))
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestWebRTCIngestSatisfiesLiveness(t *testing.T) {
+	srs := fakeSRS(t, `[
+		{"name":"news","app":"live","publish":{"active":true},"kbps":{"recv_30s":2500}},
+		{"name":"news-obs","app":"rtc","publish":{"active":true},"kbps":{"recv_30s":3000}}
+	]`)
+	c := &Controller{Config: &Config{SRSApiURL: srs.URL, SRSApp: "live"}}
+	streams, err := c.FetchSRSStreams()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := streams["news-obs"]; ok {
+		t.Error("stream from another app keyed by bare name")
+	}
+	s, ok := c.webrtcIngestStream(streams, "news")
+	if !ok || s.App != "rtc" || !s.Publish.Active {
+		t.Errorf("webrtcIngestStream = %+v, %v; want the active rtc/news-obs publish", s, ok)
+	}
+	if _, ok := c.webrtcIngestStream(streams, "sports"); ok {
+		t.Error("matched a WebRTC ingest for a channel without one")
+	}
+}
+
+func TestAppStreamTotalsCountsOnlySRSApp(t *testing.T) {
+	srs := fakeSRS(t, `[
+		{"name":"news","app":"live","kbps":{"recv_30s":2500}},
+		{"name":"sports","app":"live","kbps":{"recv_30s":1500}},
+		{"name":"news","app":"playback","kbps":{"recv_30s":2500}},
+		{"name":"news-obs","app":"rtc","kbps":{"recv_30s":3000}}
+	]`)
+	c := &Controller{Config: &Config{SRSApiURL: srs.URL, SRSApp: "live"}}
+	streams, err := c.FetchSRSStreams()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count, kbps := c.appStreamTotals(streams); count != 2 || kbps != 4000 {
+		t.Errorf("appStreamTotals = %d streams, %d kbps; want 2, 4000", count, kbps)
+	}
+}
+
+func TestTruncateLogMessage(t *testing.T) {
+	long := strings.Repeat("x", 5000)
+	for _, limit := range []int{4096, 100, 30, 27} {
+		got := truncateLogMessage(long, limit)
+		if len(got) > limit {
+			t.Errorf("limit %d: got %d bytes", limit, len(got))
+		}
+		if !strings.HasSuffix(got, fmt.Sprintf("… [truncated %d bytes]", 5000-strings.Count(got, "x"))) {
+			t.Errorf("limit %d: missing or wrong truncation note in %q", limit, got[max(0, len(got)-40):])
+		}
+	}
+
+	// Too small for the note: a bare cut
+	if got := truncateLogMessage(long, 10); got != strings.Repeat("x", 10) {
+		t.Errorf("limit 10: got %q", got)
+	}
+	// Never splits a multi-byte rune
+	if got := truncateLogMessage(strings.Repeat("é", 100), 60); len(got) > 60 || !utf8.ValidString(got) {
+		t.Errorf("multi-byte: got %d bytes, valid UTF-8 %v", len(got), utf8.ValidString(got))
+	}
+	if got := truncateLogMessage("short", 4096); got != "short" {
+		t.Errorf("short message changed to %q", got)
+	}
+	if got := truncateLogMessage(long, 0); got != long {
+		t.Error("limit 0 truncated")
+	}
+}
+
+func TestDestinationEnableLookup(t *testing.T) {
+	tests := []struct {
+		name    string
+		rows    [][]driver.Value
+		err     error
+		enabled int
+		want    int
+	}{
+		{"missing destination", nil, nil, 0, http.StatusNotFound},
+		{"lookup fails", nil, errors.New("connection reset"), 0, http.StatusInternalServerError},
+		{"under the limit", [][]driver.Value{{int64(7), false}}, nil, 1, http.StatusOK},
+		{"at the limit", [][]driver.Value{{int64(7), false}}, nil, 2, http.StatusConflict},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var updated bool
+			db := newFakeDB(t, func(query string, args []driver.Value) ([]string, [][]driver.Value, error) {
+				switch {
+				case strings.Contains(query, "SELECT channel_id, enabled FROM destinations"):
+					return []string{"channel_id", "enabled"}, tt.rows, tt.err
+				case strings.Contains(query, "SELECT COUNT(*) FROM destinations"):
+					return []string{"count"}, [][]driver.Value{{int64(tt.enabled)}}, nil
+				case strings.HasPrefix(query, "UPDATE destinations SET enabled = true"):
+					updated = true
+				}
+				return nil, nil, nil
+			})
+			c := &Controller{Config: &Config{MaxDestinations: 2}, DB: db}
+			rec := httptest.NewRecorder()
+			c.DestinationActionHandler(rec, httptest.NewRequest("POST", "/api/destinations/3/enable", nil))
+			if rec.Code != tt.want {
+				t.Errorf("status = %d, want %d (%s)", rec.Code, tt.want, strings.TrimSpace(rec.Body.String()))
+			}
+			if updated != (tt.want == http.StatusOK) {
+				t.Errorf("destination enabled = %v, want %v", updated, tt.want == http.StatusOK)
+			}
+		})
+	}
+}
+
+func TestSystemSummaryHandlerCounts(t *testing.T) {
+	queries := 0
+	db := newFakeDB(t, func(query string, args []driver.Value) ([]string, [][]driver.Value, error) {
+		switch {
+		case strings.Contains(query, "FROM channels c"):
+			queries++
+			return []string{"name", "enabled", "total", "connected"}, [][]driver.Value{
+				{"news", true, int64(2), int64(1)},
+				{"sports", true, int64(1), int64(1)},
+				{"weather", true, int64(0), int64(0)},
+				{"archive", false, int64(3), int64(0)},
+			}, nil
+		case strings.Contains(query, "FROM destinations"):
+			t.Errorf("destinations queried separately: %q", query)
+		case strings.Contains(query, "FROM audit_logs"):
+			return []string{"count"}, [][]driver.Value{{int64(0)}}, nil
+		}
+		return nil, nil, nil
+	})
+	news := SRSStream{Name: "news", Clients: 5}
+	news.Publish.Active = true
+	news.Kbps.Recv = 4000
+	sports := SRSStream{Name: "sports", Clients: 2}
+	sports.Publish.Active = true
+	sports.Kbps.Recv = 2500
+	c := &Controller{
+		Config:          &Config{CheckInterval: time.Minute, DownGrace: time.Minute},
+		DB:              db,
+		srsSnapshot:     map[string]SRSStream{"news": news, "sports": sports},
+		srsSnapshotAt:   time.Now(),
+		activeSourceMap: map[string]string{"news": "OBS", "sports": "LOOP"},
+		lastSeenLive:    map[string]time.Time{},
+	}
+
+	rec := httptest.NewRecorder()
+	c.SystemSummaryHandler(rec, httptest.NewRequest("GET", "/api/system/summary", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d (%s)", rec.Code, rec.Body.String())
+	}
+	var sum SystemSummary
+	if err := json.NewDecoder(rec.Body).Decode(&sum); err != nil {
+		t.Fatal(err)
+	}
+	if queries != 1 {
+		t.Errorf("channels queried %d times, want 1", queries)
+	}
+	if sum.TotalChannels != 4 || sum.LiveChannels != 1 || sum.LoopChannels != 1 {
+		t.Errorf("channels total/live/loop = %d/%d/%d, want 4/1/1", sum.TotalChannels, sum.LiveChannels, sum.LoopChannels)
+	}
+	if sum.TotalBitrateKbps != 6500 || sum.TotalViewers != 3 {
+		t.Errorf("bitrate = %d, viewers = %d; want 6500, 3", sum.TotalBitrateKbps, sum.TotalViewers)
+	}
+	if sum.DestinationsTotal != 6 || sum.DestinationsConnected != 2 {
+		t.Errorf("destinations = %d/%d connected, want 2/6", sum.DestinationsConnected, sum.DestinationsTotal)
+	}
+	if len(sum.ErrorChannels) != 1 || sum.ErrorChannels[0] != "weather" {
+		t.Errorf("error channels = %v, want [weather]", sum.ErrorChannels)
+	}
+}
+
+func TestValidateStreamKey(t *testing.T) {
+	c := &Controller{Config: &Config{}}
+	tests := []struct {
+		name, key, want string
+		wantErr         bool
+	}{
+		{"clean key", "abcd-efgh-ijkl-mnop", "abcd-efgh-ijkl-mnop", false},
+		{"stray whitespace trimmed", "  abcd-efgh-ijkl \n", "abcd-efgh-ijkl", false},
+		{"empty", "   ", "", false},
+		{"pasted URL", "rtmp://a.rtmp.youtube.com/live2/abcd-efgh", "", true},
+		{"path", "live2/abcd-efgh", "", true},
+		{"encoded slash", "live2%2Fabcd", "", true},
+		{"inner space", "abcd efgh", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := c.validateStreamKey(tt.key)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("validateStreamKey(%q) error = %v, wantErr %v", tt.key, err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("validateStreamKey(%q) = %q, want %q", tt.key, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDestinationUpdateBlankStreamKey(t *testing.T) {
+	for _, tt := range []struct {
+		name, body, want string
+	}{
+		{"whitespace-only key kept", `{"name":"Twitch","stream_key":"  \t "}`, "UPDATE destinations SET name = $1 WHERE id = $2 [Twitch 3]"},
+		{"new key trimmed", `{"stream_key":" live_abc "}`, "UPDATE destinations SET stream_key = $1 WHERE id = $2 [live_abc 3]"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			var got string
+			db := newFakeDB(t, func(query string, args []driver.Value) ([]string, [][]driver.Value, error) {
+				if strings.HasPrefix(query, "UPDATE destinations") {
+					got = fmt.Sprint(query, " ", args)
+				}
+				return nil, nil, nil
+			})
+			c := &Controller{Config: &Config{}, DB: db}
+			rec := httptest.NewRecorder()
+			c.DestinationActionHandler(rec, httptest.NewRequest("PUT", "/api/destinations/3", strings.NewReader(tt.body)))
+			if rec.Code != http.StatusOK {
+				t.Fatalf("status = %d (%s)", rec.Code, strings.TrimSpace(rec.Body.String()))
+			}
+			if got != tt.want {
+				t.Errorf("update = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseHookSecrets(t *testing.T) {
+	for _, tt := range []struct {
+		raw  string
+		want []HookSecret
+	}{
+		{"", nil},
+		{"s3cret", []HookSecret{{Secret: "s3cret"}}},
+		{"new=a:b=c, old=d", []HookSecret{{Label: "new", Secret: "a:b=c"}, {Label: "old", Secret: "d"}}},
+		{"legacy:abc", []HookSecret{{Secret: "legacy:abc"}}},
+		// Malformed entries are dropped, the rest still apply
+		{"=abc, new=, bad label=x, ok=y", []HookSecret{{Label: "ok", Secret: "y"}}},
+	} {
+		if got := parseHookSecrets(tt.raw); fmt.Sprint(got) != fmt.Sprint(tt.want) {
+			t.Errorf("parseHookSecrets(%q) = %+v, want %+v", tt.raw, got, tt.want)
+		}
+	}
+}
+
+func TestVerifyHookSecretRotation(t *testing.T) {
+	secrets := parseHookSecrets("new=n3w:secret, old=0ld-secret")
+	if len(secrets) != 2 || secrets[0].Label != "new" || secrets[0].Secret != "n3w:secret" || secrets[1].Secret != "0ld-secret" {
+		t.Fatalf("parseHookSecrets = %+v", secrets)
+	}
+	c := &Controller{Config: &Config{HookSecrets: secrets}}
+
+	tests := []struct {
+		name   string
+		query  string
+		header string
+		want   bool
+	}{
+		{"new secret in query", "?secret=n3w:secret", "", true},
+		{"old secret in header", "", "0ld-secret", true},
+		{"unknown secret", "?secret=guess", "", false},
+		{"no secret", "", "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("POST", "/api/hooks/on_publish"+tt.query, nil)
+			if tt.header != "" {
+				r.Header.Set("X-Hook-Secret", tt.header)
+			}
+			if got := c.verifyHookSecret(r); got != tt.want {
+				t.Errorf("verifyHookSecret = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestOpenAPIHandlerServesSpec(t *testing.T) {
+	c := &Controller{Config: &Config{}}
+	rec := httptest.NewRecorder()
+	c.OpenAPIHandler(rec, httptest.NewRequest("GET", "/api/openapi.json", nil))
+
+	var spec struct {
+		OpenAPI string                     `json:"openapi"`
+		Paths   map[string]json.RawMessage `json:"paths"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &spec); err != nil {
+		t.Fatalf("spec is not valid JSON: %v", err)
+	}
+	if !strings.HasPrefix(spec.OpenAPI, "3.") {
+		t.Errorf("openapi = %q, want 3.x", spec.OpenAPI)
+	}
+	for _, path := range []string{
+		"/health", "/api/channels", "/api/channels/{id}", "/api/channels/{id}/{action}",
+		"/api/destinations", "/api/media", "/api/media/upload", "/api/system/status", "/api/auth/login",
+	} {
+		if _, ok := spec.Paths[path]; !ok {
+			t.Errorf("spec is missing %s", path)
+		}
+	}
+}
+
+// TestOpenAPICoversRoutes checks every route SetupRoutes registers against the
+// served spec: an exact route must be a spec path, and a subtree route like
+// "/api/channels/" must have at least one spec path under it.
+func TestOpenAPICoversRoutes(t *testing.T) {
+	file, err := parser.ParseFile(token.NewFileSet(), "main.go", nil, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var routes []string
+	for _, decl := range file.Decls {
+		fn, ok := decl.(*ast.FuncDecl)
+		if !ok || fn.Name.Name != "SetupRoutes" {
+			continue
+		}
+		ast.Inspect(fn.Body, func(n ast.Node) bool {
+			call, ok := n.(*ast.CallExpr)
+			if !ok || len(call.Args) == 0 {
+				return true
+			}
+			if sel, ok := call.Fun.(*ast.SelectorExpr); !ok || (sel.Sel.Name != "HandleFunc" && sel.Sel.Name != "Handle") {
+				return true
+			}
+			if lit, ok := call.Args[0].(*ast.BasicLit); ok && lit.Kind == token.STRING {
+				route, _ := strconv.Unquote(lit.Value)
+				routes = append(routes, route)
+			}
+			return true
+		})
+	}
+	if len(routes) == 0 {
+		t.Fatal("found no routes in SetupRoutes")
+	}
+
+	c := &Controller{Config: &Config{}}
+	rec := httptest.NewRecorder()
+	c.OpenAPIHandler(rec, httptest.NewRequest("GET", "/api/openapi.json", nil))
+	var spec struct {
+		Paths map[string]json.RawMessage `json:"paths"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &spec); err != nil {
+		t.Fatalf("spec is not valid JSON: %v", err)
+	}
+	for _, route := range routes {
+		if !strings.HasSuffix(route, "/") {
+			if _, ok := spec.Paths[route]; !ok {
+				t.Errorf("route %s is not in openapi.json", route)
+			}
+			continue
+		}
+		covered := false
+		for path := range spec.Paths {
+			if strings.HasPrefix(path, route) {
+				covered = true
+				break
+			}
+		}
+		if !covered {
+			t.Errorf("no openapi.json path under route %s", route)
+		}
+	}
+}
+
+func TestCleanupStaleTempFiles(t *testing.T) {
+	dir := t.TempDir()
+	old := time.Now().Add(-2 * time.Hour)
+	for name, mtime := range map[string]time.Time{
+		"stale.optimized.temp.mp4": old,
+		"fresh.optimized.temp.mp4": time.Now(),
+		"owned.optimized.temp.mp4": old, // An optimizer is still writing it
+		"intro.mp4":                old,
+		"intro.template.mp4":       old, // Merely contains ".temp"
+	} {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte("x"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		os.Chtimes(path, mtime, mtime)
+	}
+	docker := newFakeDocker(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/containers/json" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte(`[{"Id":"opt1","Labels":{"role":"media-optimizer","media_temp":"owned.optimized.temp.mp4"}}]`))
+	})
+	c := &Controller{Config: &Config{MediaPath: dir, TempFileMaxAge: 30 * time.Minute}, Docker: docker}
+
+	c.cleanupStaleTempFiles()
+
+	for name, want := range map[string]bool{
+		"stale.optimized.temp.mp4": false,
+		"fresh.optimized.temp.mp4": true,
+		"owned.optimized.temp.mp4": true,
+		"intro.mp4":                true,
+		"intro.template.mp4":       true,
+	} {
+		_, err := os.Stat(filepath.Join(dir, name))
+		if exists := err == nil; exists != want {
+			t.Errorf("%s exists = %v, want %v", name, exists, want)
+		}
+	}
+}
+
+func TestChannelTokensVisibleByRole(t *testing.T) {
+	db := newFakeDB(t, func(query string, args []driver.Value) ([]string, [][]driver.Value, error) {
+		if cols, rows, ok := channelOwner(query, "org-a"); ok {
+			return cols, rows, nil
+		}
+		if strings.Contains(query, "FROM channels") {
+			cols, rows := channelRows(map[string]driver.Value{"id": int64(7)})
+			return cols, rows, nil
+		}
+		return nil, nil, nil
+	})
+	c := newTestController(&Config{}, db)
+
+	for _, tt := range []struct {
+		role       string
+		wantTokens bool
+	}{
+		{RoleViewer, false},
+		{RoleOperator, true},
+		{RoleAdmin, true},
+	} {
+		for _, path := range []string{"/api/channels", "/api/channels/7"} {
+			t.Run(tt.role+" "+path, func(t *testing.T) {
+				req := httptest.NewRequest("GET", path, nil)
+				req = withIdentity(req, Identity{Role: tt.role, Org: "org-a"})
+				rec := httptest.NewRecorder()
+				if path == "/api/channels" {
+					c.ChannelsHandler(rec, req)
+				} else {
+					c.ChannelActionHandler(rec, req)
+				}
+				body := rec.Body.String()
+				if rec.Code != http.StatusOK {
+					t.Fatalf("status = %d (%s)", rec.Code, body)
+				}
+				if got := strings.Contains(body, "obs-secret") && strings.Contains(body, "loop-secret"); got != tt.wantTokens {
+					t.Errorf("tokens in response = %v, want %v: %s", got, tt.wantTokens, body)
+				}
+			})
+		}
+	}
+
+	// The dedicated endpoint requires OPERATOR
+	for _, tt := range []struct {
+		role string
+		want int
+	}{{RoleViewer, http.StatusForbidden}, {RoleOperator, http.StatusOK}} {
+		req := httptest.NewRequest("GET", "/api/channels/7/tokens", nil)
+		req = withIdentity(req, Identity{Role: tt.role, Org: "org-a"})
+		rec := httptest.NewRecorder()
+		c.ChannelActionHandler(rec, req)
+		if rec.Code != tt.want {
+			t.Errorf("%s GET tokens: status = %d, want %d", tt.role, rec.Code, tt.want)
+		}
+	}
+}
+
+func TestEventsHandlerMergesTimeline(t *testing.T) {
+	base := time.Now().Add(-time.Hour).Truncate(time.Second)
+	db := newFakeDB(t, func(query string, args []driver.Value) ([]string, [][]driver.Value, error) {
+		if !strings.Contains(query, "FROM audit_logs") {
+			return nil, nil, nil
+		}
+		return []string{"action", "resource_id", "user_email", "ip", "details", "created_at"}, [][]driver.Value{
+			{"STREAM_PUBLISH", "news-obs", "", "203.0.113.9", `{"source": "OBS"}`, base},
+			{"CHANNEL_UPDATED", "news", "ops@example.com", "", "", base.Add(2 * time.Minute)},
+		}, nil
+	})
+	c := &Controller{Config: &Config{}, DB: db, SwitchEvents: []TimelineEvent{
+		{Timestamp: base.Add(time.Minute), Type: "FAILOVER", Channel: "news", Actor: "reconciler", Detail: "OBS lost"},
+		{Timestamp: base.Add(time.Minute), Type: "FAILOVER", Channel: "sports", Actor: "reconciler"},
+	}}
+
+	rec := httptest.NewRecorder()
+	c.EventsHandler(rec, httptest.NewRequest("GET", "/api/events?channel=news", nil))
+	var body struct {
+		Events []TimelineEvent `json:"events"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+		t.Fatalf("decode: %v (%s)", err, rec.Body.String())
+	}
+	want := []struct{ typ, actor string }{
+		{"STREAM_PUBLISH", "203.0.113.9"},
+		{"FAILOVER", "reconciler"},
+		{"CHANNEL_UPDATED", "ops@example.com"},
+	}
+	if len(body.Events) != len(want) {
+		t.Fatalf("got %d events, want %d: %+v", len(body.Events), len(want), body.Events)
+	}
+	for i, w := range want {
+		ev := body.Events[i]
+		if ev.Type != w.typ || ev.Actor != w.actor || ev.Channel != "news" {
+			t.Errorf("event %d = %+v, want type %s by %s on news", i, ev, w.typ, w.actor)
+		}
+	}
+
+	// Pages continue where the previous one stopped
+	rec = httptest.NewRecorder()
+	c.EventsHandler(rec, httptest.NewRequest("GET", "/api/events?channel=news&limit=1&offset=1", nil))
+	body.Events = nil
+	json.Unmarshal(rec.Body.Bytes(), &body)
+	if len(body.Events) != 1 || body.Events[0].Type != "FAILOVER" {
+		t.Errorf("second page = %+v, want the FAILOVER event", body.Events)
+	}
+}
+
+func TestDrainThenTakeoverWaitsForStableOBS(t *testing.T) {
+	var stable atomic.Bool
+	srs := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		kbps := 40 // Connected but not yet sending a real stream
+		if stable.Load() {
+			kbps = 3000
+		}
+		fmt.Fprintf(w, `{"code":0,"streams":[{"name":"news-obs","app":"live","publish":{"active":true},"kbps":{"recv_30s":%d}}]}`, kbps)
+	}))
+	defer srs.Close()
+
+	var removed atomic.Int32
+	docker := newFakeDocker(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "DELETE" && r.URL.Path == "/containers/loop-news" {
+			removed.Add(1)
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+	db := newFakeDB(t, func(string, []driver.Value) ([]string, [][]driver.Value, error) { return nil, nil, nil })
+	c := newTestController(&Config{SRSApiURL: srs.URL, SRSApp: "live", TakeoverDrain: 10 * time.Second, StabilityWindow: 2}, db)
+	c.Docker = docker
+
+	done := make(chan struct{})
+	go func() {
+		c.drainThenTakeover("news", "news-obs")
+		close(done)
+	}()
+
+	time.Sleep(2500 * time.Millisecond)
+	c.mu.RLock()
+	_, tookOver := c.takeoverCooldown["news"]
+	c.mu.RUnlock()
+	if tookOver || removed.Load() != 0 {
+		t.Fatal("loop stopped while OBS was still unstable")
+	}
+
+	stable.Store(true)
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("no takeover after OBS became stable")
+	}
+	c.mu.RLock()
+	_, tookOver = c.takeoverCooldown["news"]
+	c.mu.RUnlock()
+	if !tookOver {
+		t.Error("takeover not recorded once OBS was stable")
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	for removed.Load() == 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if removed.Load() == 0 {
+		t.Error("loop container was not removed after OBS stabilized")
+	}
+}
+
+func TestSyncDestinationHealthAutoDisables(t *testing.T) {
+	relay := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		longAgo := time.Now().Add(-2 * time.Hour).Format(time.RFC3339)
+		recent := time.Now().Add(-5 * time.Minute).Format(time.RFC3339)
+		fmt.Fprintf(w, `{"destinations":[
+			{"url":"rtmp://a.example/live/dead","running":false,"failures":40,"failing_since":%q},
+			{"url":"rtmp://b.example/live/dead","running":false,"failures":40,"failing_since":%q},
+			{"url":"rtmp://c.example/live/flaky","running":false,"failures":2,"failing_since":%q},
+			{"url":"rtmp://d.example/live/ok","running":true,"failures":0}
+		]}`, longAgo, longAgo, recent)
+	}))
+	defer relay.Close()
+	routeRelaysTo(t, relay)
+
+	var mu sync.Mutex
+	disabled := map[int64]string{}
+	audited := 0
+	db := newFakeDB(t, func(query string, args []driver.Value) ([]string, [][]driver.Value, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		switch {
+		case strings.Contains(query, "auto_disabled_reason = $1"):
+			disabled[args[1].(int64)] = args[0].(string)
+		case strings.Contains(query, "INSERT INTO audit_logs") && args[0] == "DESTINATION_AUTO_DISABLED":
+			audited++
+		}
+		return nil, nil, nil
+	})
+	c := newTestController(&Config{DestAutoDisable: 30 * time.Minute}, db)
+
+	dests := []Destination{
+		{ID: 1, Name: "opted in, long dead", RTMPURL: "rtmp://a.example/live", StreamKey: "dead", Enabled: true, AutoDisable: true},
+		{ID: 2, Name: "not opted in", RTMPURL: "rtmp://b.example/live", StreamKey: "dead", Enabled: true},
+		{ID: 3, Name: "opted in, recent", RTMPURL: "rtmp://c.example/live", StreamKey: "flaky", Enabled: true, AutoDisable: true},
+		{ID: 4, Name: "healthy", RTMPURL: "rtmp://d.example/live", StreamKey: "ok", Enabled: true, AutoDisable: true},
+	}
+	c.syncDestinationHealth(Channel{Name: "news"}, dests)
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(disabled) != 1 || disabled[1] == "" {
+		t.Errorf("auto-disabled %v, want only destination 1", disabled)
+	}
+	if audited != 1 {
+		t.Errorf("%d auto-disable audit entries, want 1", audited)
+	}
+
+	// With the policy applied globally the unopted destination goes too
+	disabled = map[int64]string{}
+	mu.Unlock()
+	c.Config.DestAutoDisableAll = true
+	c.syncDestinationHealth(Channel{Name: "news"}, dests)
+	mu.Lock()
+	if _, ok := disabled[2]; !ok || len(disabled) != 2 {
+		t.Errorf("with DEST_AUTO_DISABLE_ALL auto-disabled %v, want 1 and 2", disabled)
+	}
+}
+
+func TestDeleteChannelRollsBack(t *testing.T) {
+	var stmts []string
+	db := newFakeDB(t, func(query string, args []driver.Value) ([]string, [][]driver.Value, error) {
+		query = strings.TrimSpace(query)
+		switch {
+		case strings.HasPrefix(query, "SELECT name FROM channels"):
+			return []string{"name"}, [][]driver.Value{{"news"}}, nil
+		case strings.HasPrefix(query, "SELECT"):
+			return nil, nil, nil
+		}
+		stmts = append(stmts, query)
+		if strings.HasPrefix(query, "DELETE FROM channels") {
+			return nil, nil, errors.New("connection reset")
+		}
+		return nil, nil, nil
+	})
+	c := newTestController(&Config{}, db)
+	c.Docker = newFakeDocker(t, func(w http.ResponseWriter, r *http.Request) {
+		t.Errorf("containers touched before the DB deletes succeeded: %s %s", r.Method, r.URL.Path)
+	})
+
+	req := httptest.NewRequest("DELETE", "/api/channels/7", nil)
+	req = withIdentity(req, Identity{Role: RoleAdmin})
+	rec := httptest.NewRecorder()
+	c.ChannelActionHandler(rec, req)
+	if rec.Code != http.StatusInternalServerError {
+		t.Errorf("status = %d, want 500", rec.Code)
+	}
+	want := []string{
+		"BEGIN",
+		"DELETE FROM destinations WHERE channel_id = $1",
+		"DELETE FROM channels WHERE id = $1",
+		"ROLLBACK",
+	}
+	if strings.Join(stmts, "; ") != strings.Join(want, "; ") {
+		t.Errorf("statements = %q, want %q", stmts, want)
+	}
+}
+
+func TestLoopContainerTestPatternEnv(t *testing.T) {
+	docker, containers := newFakeContainers(t)
+	c := newTestController(&Config{}, nil)
+	c.Docker = docker
+	c.Media = &localMediaStore{dir: t.TempDir()}
+
+	c.EnsureContainerRunning(Channel{Name: "news", SourceMode: "testpattern", LoopToken: "loop-secret"}, "loop-news")
+	ct, ok := containers.container("loop-news")
+	if !ok {
+		t.Fatal("loop container was not created")
+	}
+	if mode := ct.env("SOURCE_MODE"); mode != "testpattern" {
+		t.Errorf("SOURCE_MODE = %q, want testpattern", mode)
+	}
+	if !containers.called("POST /containers/loop-news/start") {
+		t.Error("loop container was not started")
+	}
+
+	c.EnsureContainerRunning(Channel{Name: "sports", SourceMode: "file", LoopSourceFile: "intro.mp4", LoopToken: "loop-secret"}, "loop-sports")
+	ct, _ = containers.container("loop-sports")
+	if mode := ct.env("SOURCE_MODE"); mode != "file" {
+		t.Errorf("file channel SOURCE_MODE = %q, want file", mode)
+	}
+}
+
+func TestMediaItemHandlerRange(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "intro.mp4"), []byte("0123456789abcdef"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	c := &Controller{Config: &Config{MediaPath: dir}, Media: &localMediaStore{dir: dir}}
+
+	req := httptest.NewRequest("GET", "/api/media/intro.mp4", nil)
+	req.Header.Set("Range", "bytes=4-9")
+	rec := httptest.NewRecorder()
+	c.MediaItemHandler(rec, req)
+	if rec.Code != http.StatusPartialContent {
+		t.Fatalf("status = %d, want 206", rec.Code)
+	}
+	if got := rec.Body.String(); got != "456789" {
+		t.Errorf("body = %q, want %q", got, "456789")
+	}
+	if cr := rec.Header().Get("Content-Range"); cr != "bytes 4-9/16" {
+		t.Errorf("Content-Range = %q", cr)
+	}
+	if ct := rec.Header().Get("Content-Type"); ct != "video/mp4" {
+		t.Errorf("Content-Type = %q, want video/mp4", ct)
+	}
+	if ar := rec.Header().Get("Accept-Ranges"); ar != "bytes" {
+		t.Errorf("Accept-Ranges = %q, want bytes", ar)
+	}
+
+	rec = httptest.NewRecorder()
+	c.MediaItemHandler(rec, httptest.NewRequest("HEAD", "/api/media/intro.mp4", nil))
+	if rec.Code != http.StatusOK || rec.Header().Get("Content-Length") != "16" || rec.Body.Len() != 0 {
+		t.Errorf("HEAD: status %d, Content-Length %q, %d body bytes", rec.Code, rec.Header().Get("Content-Length"), rec.Body.Len())
+	}
+
+	rec = httptest.NewRecorder()
+	c.MediaItemHandler(rec, httptest.NewRequest("GET", "/api/media/..%2Fetc%2Fpasswd", nil))
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("path traversal: status = %d, want 400", rec.Code)
+	}
+}
+
+func TestMissedSampleReportsReconnecting(t *testing.T) {
+	var live atomic.Bool
+	srs := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		streams := "[]"
+		if live.Load() {
+			streams = `[{"name":"news","app":"live","live_ms":60000,"publish":{"active":true},"kbps":{"recv_30s":2500}}]`
+		}
+		w.Write([]byte(`{"code":0,"streams":` + streams + `}`))
+	}))
+	defer srs.Close()
+	db := newFakeDB(t, func(query string, args []driver.Value) ([]string, [][]driver.Value, error) {
+		if strings.Contains(query, "FROM channels") {
+			cols, rows := channelRows(map[string]driver.Value{"enabled": false})
+			return cols, rows, nil
+		}
+		return nil, nil, nil
+	})
+	c := newTestController(&Config{SRSApiURL: srs.URL, SRSApp: "live", DownGrace: time.Minute}, db)
+	status := func() string {
+		t.Helper()
+		channels, err := c.GetChannels(context.Background())
+		if err != nil || len(channels) != 1 {
+			t.Fatalf("GetChannels = %v, %v", channels, err)
+		}
+		return channels[0].Status
+	}
+
+	live.Store(true)
+	if s := status(); s != "LIVE" {
+		t.Fatalf("status = %s, want LIVE", s)
+	}
+	live.Store(false)
+	if s := status(); s != "RECONNECTING" {
+		t.Errorf("after one missed sample status = %s, want RECONNECTING", s)
+	}
+
+	// Past the grace period the channel is really down
+	c.mu.Lock()
+	c.lastSeenLive["news"] = time.Now().Add(-2 * time.Minute)
+	c.mu.Unlock()
+	if s := status(); s != "DOWN" {
+		t.Errorf("after the grace period status = %s, want DOWN", s)
+	}
+}
+
+func TestExportImportRoundTrip(t *testing.T) {
+	var channelArgs []driver.Value
+	var destArgs [][]driver.Value
+	db := newFakeDB(t, func(query string, args []driver.Value) ([]string, [][]driver.Value, error) {
+		if cols, rows, ok := channelOwner(query, "org-a"); ok {
+			return cols, rows, nil
+		}
+		query = strings.TrimSpace(query)
+		switch {
+		case strings.HasPrefix(query, "SELECT EXISTS"):
+			return []string{"exists"}, [][]driver.Value{{false}}, nil
+		case strings.HasPrefix(query, "SELECT id FROM organizations"):
+			return []string{"id"}, [][]driver.Value{{"org-1"}}, nil
+		case strings.Contains(query, "FROM destinations"):
+			cols, rows := destinationRows(Destination{ID: 3, ChannelID: 1, Name: "YouTube", RTMPURL: "rtmp://a.rtmp.youtube.com/live2",
+				StreamKey: "yt-key", Enabled: true, Status: "CONNECTED", AutoDisable: true,
+				TranscodeEnabled: true, TranscodeResolution: "1280x720", TranscodeVideoBitrate: 2500, TranscodeAudioBitrate: 128})
+			return cols, rows, nil
+		case strings.HasPrefix(query, "INSERT INTO channels"):
+			channelArgs = args
+			return []string{"id"}, [][]driver.Value{{int64(9)}}, nil
+		case strings.HasPrefix(query, "INSERT INTO destinations"):
+			destArgs = append(destArgs, args)
+			return nil, nil, nil
+		case strings.Contains(query, "FROM channels"):
+			cols, rows := channelRows(map[string]driver.Value{"video_bitrate": int64(4500), "output_fps": int64(60)})
+			return cols, rows, nil
+		}
+		return nil, nil, nil
+	})
+	c := newTestController(&Config{MaxVideoBitrate: 20000, MaxAudioBitrate: 320}, db)
+
+	req := httptest.NewRequest("GET", "/api/channels/1/export?include_secrets=true", nil)
+	req = withIdentity(req, Identity{Role: RoleOperator, Org: "org-a"})
+	rec := httptest.NewRecorder()
+	c.ChannelActionHandler(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("export: status = %d (%s)", rec.Code, rec.Body.String())
+	}
+
+	req = httptest.NewRequest("POST", "/api/channels/import?name=news-copy", rec.Body)
+	req = withIdentity(req, Identity{Role: RoleOperator, Org: "org-a"})
+	rec = httptest.NewRecorder()
+	c.ChannelActionHandler(rec, req)
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("import: status = %d (%s)", rec.Code, rec.Body.String())
+	}
+
+	if len(channelArgs) != 40 {
+		t.Fatalf("channel insert args = %d, want 40", len(channelArgs))
+	}
+	if channelArgs[0] != "news-copy" || channelArgs[1] != "News" {
+		t.Errorf("name, display_name = %v, %v; want news-copy, News", channelArgs[0], channelArgs[1])
+	}
+	if channelArgs[3] == "obs-secret" || channelArgs[4] == "loop-secret" {
+		t.Errorf("import reused the exported ingest tokens: %v, %v", channelArgs[3], channelArgs[4])
+	}
+	if channelArgs[5] != "intro.mp4" || channelArgs[18] != int64(4500) || channelArgs[21] != int64(60) {
+		t.Errorf("loop_source_file, video_bitrate, output_fps = %v, %v, %v; want intro.mp4, 4500, 60",
+			channelArgs[5], channelArgs[18], channelArgs[21])
+	}
+	if channelArgs[10] != "org-1" {
+		t.Errorf("organization_id = %v, want org-1", channelArgs[10])
+	}
+
+	if len(destArgs) != 1 {
+		t.Fatalf("destination inserts = %d, want 1", len(destArgs))
+	}
+	want := []driver.Value{int64(9), "YouTube", "rtmp://a.rtmp.youtube.com/live2", "yt-key", true, false, true,
+		true, "1280x720", int64(2500), int64(128)}
+	if fmt.Sprint(destArgs[0]) != fmt.Sprint(want) {
+		t.Errorf("destination insert args = %v, want %v", destArgs[0], want)
+	}
+}
+
+func TestDueForReconcileSkipsLowPriority(t *testing.T) {
+	c := newTestController(&Config{}, nil)
+	critical := Channel{Name: "news", ReconcileEvery: 1}
+	low := Channel{Name: "archive", ReconcileEvery: 3}
+
+	var gotLow []bool
+	for cycle := 0; cycle < 7; cycle++ {
+		if !c.dueForReconcile(critical) {
+			t.Errorf("cycle %d: reconcile_every=1 channel skipped", cycle)
+		}
+		gotLow = append(gotLow, c.dueForReconcile(low))
+	}
+	want := []bool{true, false, false, true, false, false, true}
+	if fmt.Sprint(gotLow) != fmt.Sprint(want) {
+		t.Errorf("reconcile_every=3 due per cycle = %v, want %v", gotLow, want)
+	}
+}
+
+func TestResetDestinationStatusFromRelay(t *testing.T) {
+	relay := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"destinations":[
+			{"url":"rtmp://a.example/live/up","running":true,"failures":0},
+			{"url":"rtmp://b.example/live/failing","running":true,"failures":3,"failing_since":"2026-01-01T00:00:00Z"},
+			{"url":"rtmp://c.example/live/same","running":true,"failures":0}
+		]}`)
+	}))
+	defer relay.Close()
+	routeRelaysTo(t, relay)
+
+	var mu sync.Mutex
+	updated := map[int64]string{}
+	db := newFakeDB(t, func(query string, args []driver.Value) ([]string, [][]driver.Value, error) {
+		if cols, rows, ok := channelOwner(query, "org-a"); ok {
+			return cols, rows, nil
+		}
+		switch {
+		case strings.Contains(query, "FROM destinations"):
+			cols, rows := destinationRows(
+				// Stale in both directions, plus one already right
+				Destination{ID: 1, ChannelID: 1, Name: "up", RTMPURL: "rtmp://a.example/live", StreamKey: "up", Enabled: true, Status: "DISCONNECTED"},
+				Destination{ID: 2, ChannelID: 1, Name: "failing", RTMPURL: "rtmp://b.example/live", StreamKey: "failing", Enabled: true, Status: "CONNECTED"},
+				Destination{ID: 3, ChannelID: 1, Name: "same", RTMPURL: "rtmp://c.example/live", StreamKey: "same", Enabled: true, Status: "CONNECTED"},
+				Destination{ID: 4, ChannelID: 1, Name: "gone", RTMPURL: "rtmp://d.example/live", StreamKey: "gone", Enabled: true, Status: "CONNECTED"},
+			)
+			return cols, rows, nil
+		case strings.Contains(query, "FROM channels"):
+			cols, rows := channelRows(nil)
+			return cols, rows, nil
+		case strings.HasPrefix(query, "UPDATE destinations SET status"):
+			mu.Lock()
+			updated[args[1].(int64)] = args[0].(string)
+			mu.Unlock()
+		}
+		return nil, nil, nil
+	})
+	c := newTestController(&Config{}, db)
+
+	req := httptest.NewRequest("POST", "/api/destinations/reset-status?channel_id=1", nil)
+	req = withIdentity(req, Identity{Role: RoleOperator, Org: "org-a"})
+	rec := httptest.NewRecorder()
+	c.DestinationActionHandler(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d (%s)", rec.Code, rec.Body.String())
+	}
+
+	want := map[int64]string{1: "CONNECTED", 2: "DISCONNECTED", 4: "DISCONNECTED"}
+	mu.Lock()
+	defer mu.Unlock()
+	if fmt.Sprint(updated) != fmt.Sprint(want) {
+		t.Errorf("status updates = %v, want %v", updated, want)
+	}
+	var resp struct {
+		Checked int `json:"checked"`
+		Changed []struct {
+			DestinationID int    `json:"destination_id"`
+			From          string `json:"from"`
+			To            string `json:"to"`
+		} `json:"changed"`
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp.Checked != 4 || len(resp.Changed) != 3 {
+		t.Errorf("checked %d, changed %d; want 4 and 3: %+v", resp.Checked, len(resp.Changed), resp)
+	}
+}
+
+func TestUploadHandlerRejectsOverLimit(t *testing.T) {
+	dir := t.TempDir()
+	c := newTestController(&Config{MaxUploadBytes: 1024, MediaPath: dir, MediaExtensions: []string{".mp4"}}, nil)
+	c.Media = &localMediaStore{dir: dir}
+
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	part, _ := mw.CreateFormFile("file", "big.mp4")
+	part.Write(bytes.Repeat([]byte("x"), 4096))
+	mw.Close()
+
+	for _, tt := range []struct {
+		name          string
+		contentLength int64
+	}{
+		{"declared length", int64(body.Len())},
+		// Without a Content-Length the limit is only hit while reading
+		{"chunked", -1},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("POST", "/api/upload", bytes.NewReader(body.Bytes()))
+			req.Header.Set("Content-Type", mw.FormDataContentType())
+			req.ContentLength = tt.contentLength
+			rec := httptest.NewRecorder()
+			c.UploadHandler(rec, req)
+			if rec.Code != http.StatusRequestEntityTooLarge {
+				t.Errorf("status = %d, want 413 (%s)", rec.Code, rec.Body.String())
+			}
+			if _, err := os.Stat(filepath.Join(dir, "big.mp4")); err == nil {
+				t.Error("over-limit upload was written to the media directory")
+			}
+		})
+	}
+}
+
+func TestUploadSlotsRejectExtraUploads(t *testing.T) {
+	dir := t.TempDir()
+	c := newTestController(&Config{MaxUploadBytes: 1 << 20, MaxUploads: 2, MediaPath: dir, MediaExtensions: []string{".mp4"}}, nil)
+	c.Media = &localMediaStore{dir: dir}
+
+	// upload sends name; its body stalls after the first bytes until release
+	// is closed, so the upload keeps its slot
+	release := make(chan struct{})
+	upload := func(name string, stall bool) *httptest.ResponseRecorder {
+		pr, pw := io.Pipe()
+		mw := multipart.NewWriter(pw)
+		go func() {
+			part, err := mw.CreateFormFile("file", name)
+			if err != nil {
+				return // Refused and closed before reading anything
+			}
+			part.Write([]byte("vid"))
+			if stall {
+				<-release
+			}
+			part.Write([]byte("eo"))
+			mw.Close()
+			pw.Close()
+		}()
+		req := httptest.NewRequest("POST", "/api/upload", pr)
+		req.Header.Set("Content-Type", mw.FormDataContentType())
+		rec := httptest.NewRecorder()
+		c.UploadHandler(rec, req)
+		pr.Close() // Unblocks the writer if the upload was refused unread
+		return rec
+	}
+
+	var wg sync.WaitGroup
+	codes := make([]int, 2)
+	for i := range codes {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			codes[i] = upload(fmt.Sprintf("slow%d.mp4", i), true).Code
+		}()
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for len(c.uploadSlots) < 2 {
+		if time.Now().After(deadline) {
+			close(release)
+			t.Fatalf("uploads in flight = %d, want 2", len(c.uploadSlots))
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	rec := upload("extra.mp4", false)
+	if rec.Code != http.StatusServiceUnavailable || rec.Header().Get("Retry-After") != "30" {
+		t.Errorf("third upload: status %d, Retry-After %q; want 503 and 30", rec.Code, rec.Header().Get("Retry-After"))
+	}
+	if _, err := os.Stat(filepath.Join(dir, "extra.mp4")); err == nil {
+		t.Error("rejected upload was written to the media directory")
+	}
+
+	rec = httptest.NewRecorder()
+	c.MediaLimitsHandler(rec, httptest.NewRequest("GET", "/api/media/limits", nil))
+	var limits struct {
+		Max        int `json:"max_concurrent_uploads"`
+		InProgress int `json:"uploads_in_progress"`
+	}
+	json.NewDecoder(rec.Body).Decode(&limits)
+	if limits.Max != 2 || limits.InProgress != 2 {
+		t.Errorf("limits = %+v, want 2 of 2 slots in use", limits)
+	}
+
+	close(release)
+	wg.Wait()
+	if codes[0] != http.StatusOK || codes[1] != http.StatusOK {
+		t.Errorf("uploads holding the slots finished with %v, want both 200", codes)
+	}
+	if code := upload("after.mp4", false).Code; code != http.StatusOK {
+		t.Errorf("upload once the slots freed up: status %d, want 200", code)
+	}
+}
+
+func TestWaitForDockerFailsAfterRetries(t *testing.T) {
+	var pings atomic.Int32
+	cli := newFakeDocker(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/_ping" {
+			pings.Add(1)
+		}
+		http.Error(w, `{"message":"daemon unavailable"}`, http.StatusInternalServerError)
+	})
+
+	err := waitForDocker(cli, 3, time.Millisecond)
+	if err == nil {
+		t.Fatal("waitForDocker succeeded against a failing daemon")
+	}
+	if !strings.Contains(err.Error(), "docker API unavailable at "+cli.DaemonHost()) {
+		t.Errorf("error = %q, want it to name the daemon host", err)
+	}
+	if n := pings.Load(); n != 3 {
+		t.Errorf("%d pings, want 3", n)
+	}
+
+	// A healthy daemon answers the first ping
+	ok := newFakeDocker(t, func(w http.ResponseWriter, r *http.Request) {})
+	if err := waitForDocker(ok, 3, time.Millisecond); err != nil {
+		t.Errorf("waitForDocker against a healthy daemon: %v", err)
+	}
+}
+
+func TestAdaptiveBitrateFollowsViewers(t *testing.T) {
+	var mu sync.Mutex
+	var sent []int
+	relay := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/update" {
+			var payload struct {
+				VideoBitrate int `json:"video_bitrate"`
+			}
+			json.NewDecoder(r.Body).Decode(&payload)
+			mu.Lock()
+			sent = append(sent, payload.VideoBitrate)
+			mu.Unlock()
+			return
+		}
+		fmt.Fprint(w, `{"destinations":[]}`)
+	}))
+	defer relay.Close()
+	routeRelaysTo(t, relay)
+
+	db := newFakeDB(t, func(query string, args []driver.Value) ([]string, [][]driver.Value, error) {
+		return nil, nil, nil
+	})
+	c := newTestController(&Config{StabilityWindow: 3, RelayImage: "relay-manager:latest", RelayUpdateAttempts: 1}, db)
+	c.Docker = fakeRunningRelay(t, "news", "relay-manager:latest")
+
+	ch := Channel{Name: "news", AdaptiveBitrate: true, ABRLowBitrate: 1000, ABRHighBitrate: 4500, ABRViewerThreshold: 2,
+		Destinations: []Destination{{ID: 1, Name: "yt", RTMPURL: "rtmp://a.example/live", StreamKey: "k", Enabled: true}}}
+	// Viewers leave, and after the stability window the low tier kicks in;
+	// when they come back the high tier returns the same way
+	viewers := []int{5, 5, 5, 0, 0, 0, 3, 3, 3}
+	for _, n := range viewers {
+		cycle := ch
+		cycle.VideoBitrate = c.adaptiveVideoBitrate(ch, n)
+		c.ReconcileDestinations(cycle, true)
+	}
+
+	want := []int{4500, 4500, 4500, 4500, 4500, 1000, 1000, 1000, 4500}
+	mu.Lock()
+	defer mu.Unlock()
+	if fmt.Sprint(sent) != fmt.Sprint(want) {
+		t.Errorf("video_bitrate per update = %v, want %v", sent, want)
+	}
+}
+
+func TestSRSURLsUseConfiguredApp(t *testing.T) {
+	srs := fakeSRS(t, `[
+		{"name":"news","app":"ingest","clients":1,"publish":{"active":true}},
+		{"name":"news","app":"live","clients":3,"publish":{"active":true}}
+	]`)
+	c := &Controller{Config: &Config{SRSApiURL: srs.URL, SRSApp: "ingest", PublicRTMPHost: "stream.example.com", PublicRTMPPort: "1935"}}
+
+	for _, tt := range []struct {
+		name, got, want string
+	}{
+		{"loop source", c.relaySourceURL(Channel{Name: "news"}), "rtmp://srs:1935/ingest/news"},
+		{"OBS source", c.relaySourceURL(Channel{Name: "news", ActiveSource: "OBS"}), "rtmp://srs:1935/ingest/news-obs"},
+		{"ingest server", c.ingestServerURL(), "rtmp://stream.example.com:1935/ingest"},
+	} {
+		if tt.got != tt.want {
+			t.Errorf("%s = %q, want %q", tt.name, tt.got, tt.want)
+		}
+	}
+
+	streams, err := c.FetchSRSStreams()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The configured app owns the bare name; the default app is now foreign
+	if s, ok := streams["news"]; !ok || s.App != "ingest" {
+		t.Errorf(`streams["news"] = %+v, %v; want the ingest stream`, s, ok)
+	}
+	if _, ok := streams["live/news"]; !ok {
+		t.Errorf("live app stream not keyed live/news: %v", streams)
+	}
+}
+
+func TestPreviewURLsUsePublicHost(t *testing.T) {
+	srs := fakeSRS(t, `[{"name":"news","app":"live","clients":2,"publish":{"active":true}}]`)
+	db := newFakeDB(t, func(query string, args []driver.Value) ([]string, [][]driver.Value, error) {
+		if cols, rows, ok := channelOwner(query, "org-a"); ok {
+			return cols, rows, nil
+		}
+		if strings.Contains(query, "SELECT id, name, display_name, enabled, loop_enabled") {
+			return []string{"id", "name", "display_name", "enabled", "loop_enabled"},
+				[][]driver.Value{{int64(1), "news", "News", true, true}}, nil
+		}
+		return nil, nil, nil
+	})
+	c := newTestController(&Config{SRSApiURL: srs.URL, SRSApp: "live", SRSPublicHost: "play.example.com",
+		SRSPublicHTTPPort: "8088", SRSPublicAPIPort: "1985"}, db)
+
+	get := func() map[string]PlaybackURLs {
+		t.Helper()
+		req := httptest.NewRequest("GET", "http://controller.internal:8080/api/channels/1/preview-url", nil)
+		req = withIdentity(req, Identity{Role: RoleViewer, Org: "org-a"})
+		rec := httptest.NewRecorder()
+		c.ChannelActionHandler(rec, req)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("status = %d (%s)", rec.Code, rec.Body.String())
+		}
+		var resp map[string]json.RawMessage
+		json.NewDecoder(rec.Body).Decode(&resp)
+		urls := map[string]PlaybackURLs{}
+		for _, source := range []string{"loop", "obs"} {
+			var u PlaybackURLs
+			json.Unmarshal(resp[source], &u)
+			urls[source] = u
+		}
+		return urls
+	}
+
+	urls := get()
+	want := PlaybackURLs{
+		Stream: "news",
+		Live:   true,
+		HLS:    "http://play.example.com:8088/live/news.m3u8",
+		FLV:    "http://play.example.com:8088/live/news.flv",
+		WebRTC: "webrtc://play.example.com:1985/live/news",
+		WHEP:   "http://play.example.com:1985/rtc/v1/whep/?app=live&stream=news",
+	}
+	if urls["loop"] != want {
+		t.Errorf("loop = %+v, want %+v", urls["loop"], want)
+	}
+	if obs := urls["obs"]; obs.Live || obs.HLS != "http://play.example.com:8088/live/news-obs.m3u8" {
+		t.Errorf("obs = %+v, want offline news-obs on the public host", obs)
+	}
+
+	// Without a public host the URLs follow the host the request came in on
+	c.Config.SRSPublicHost = ""
+	if hls := get()["loop"].HLS; hls != "http://controller.internal:8088/live/news.m3u8" {
+		t.Errorf("HLS without SRS_PUBLIC_HOST = %q", hls)
+	}
+}
+
+func TestNewChannelInheritsDefaults(t *testing.T) {
+	for _, tt := range []struct {
+		name   string
+		stored string
+		want   map[int]driver.Value // INSERT INTO channels argument index -> value
+	}{
+		{
+			name:   "configured",
+			stored: `{"loop_enabled":true,"failover_timeout_seconds":30,"video_bitrate":6000,"output_fps":60,"encoder_preset":"veryfast","source_mode":"testpattern"}`,
+			// Fields left out of the stored JSON keep their built-in values
+			want: map[int]driver.Value{6: true, 7: true, 9: int64(30), 17: int64(2), 18: int64(6000), 19: int64(128),
+				21: int64(60), 22: "veryfast", 25: "testpattern", 26: int64(1)},
+		},
+		{
+			name:   "invalid falls back to built-in",
+			stored: `{"output_fps":7}`,
+			want: map[int]driver.Value{6: false, 9: int64(defaultFailoverTimeoutSeconds), 18: int64(0),
+				21: int64(defaultOutputFPS), 22: defaultEncoderPreset, 25: "file"},
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			var inserted []driver.Value
+			db := newFakeDB(t, func(query string, args []driver.Value) ([]string, [][]driver.Value, error) {
+				switch {
+				case strings.Contains(query, "SELECT EXISTS"):
+					return []string{"exists"}, [][]driver.Value{{false}}, nil
+				case strings.Contains(query, "FROM organizations"):
+					return []string{"id"}, [][]driver.Value{{"org-a"}}, nil
+				case strings.Contains(query, "FROM system_config"):
+					return []string{"value"}, [][]driver.Value{{[]byte(tt.stored)}}, nil
+				case strings.Contains(query, "INSERT INTO channels"):
+					inserted = args
+					return []string{"id"}, [][]driver.Value{{int64(1)}}, nil
+				}
+				return nil, nil, nil
+			})
+			c := newTestController(&Config{}, db)
+			req := httptest.NewRequest("POST", "/api/channels", strings.NewReader(`{"name":"news","display_name":"News"}`))
+			req = withIdentity(req, Identity{Role: RoleOperator, Org: "org-a"})
+			rec := httptest.NewRecorder()
+			c.ChannelsHandler(rec, req)
+			if rec.Code != http.StatusOK {
+				t.Fatalf("status = %d (%s)", rec.Code, rec.Body.String())
+			}
+			for i, want := range tt.want {
+				if inserted[i] != want {
+					t.Errorf("insert arg %d = %v, want %v", i, inserted[i], want)
+				}
+			}
+		})
+	}
+}
+
+func TestFormatDuration(t *testing.T) {
+	tests := []struct {
+		ms   int64
+		want string
+	}{
+		{-5, "0s"},
+		{0, "0s"},
+		{999, "0s"},
+		{42_000, "42s"},
+		{59_999, "59s"},
+		{60_000, "1m"},
+		{3_599_000, "59m 59s"},
+		{3_600_000, "1h"},
+		{86_399_000, "23h 59m 59s"},
+		{86_400_000, "1d"},
+		{86_400_000 + 59_000, "1d"},
+		{(2*86_400 + 6*3_600 + 15*60 + 30) * 1000, "2d 6h 15m"},
+	}
+	for _, tt := range tests {
+		if got := formatDuration(tt.ms); got != tt.want {
+			t.Errorf("formatDuration(%d) = %q, want %q", tt.ms, got, tt.want)
+		}
+	}
+}
+
+func TestMatchesOptimizeTarget(t *testing.T) {
+	// probe is ffprobe -show_streams -show_frames output for a 1080p file
+	probe := func(codec, bitRate, keyframeGap string) string {
+		return `{
+			"streams": [
+				{"index":0,"codec_type":"video","codec_name":"` + codec + `","profile":"High","height":1080,
+				 "pix_fmt":"yuv420p","bit_rate":"` + bitRate + `"},
+				{"index":1,"codec_type":"audio","codec_name":"aac","bit_rate":"128000"}
+			],
+			"frames": [
+				{"stream_index":0,"pts_time":"0.000000"},
+				{"stream_index":0,"pts_time":"` + keyframeGap + `"},
+				{"stream_index":1,"pts_time":"0.500000"}
+			]
+		}`
+	}
+	tests := []struct {
+		name      string
+		json      string
+		tolerance float64
+		wantRemux bool
+	}{
+		{"already at target", probe("h264", "4100000", "2.002000"), 10, true},
+		{"HEVC", probe("hevc", "4000000", "2.000000"), 10, false},
+		{"bitrate too high", probe("h264", "8000000", "2.000000"), 10, false},
+		{"sparse keyframes", probe("h264", "4000000", "10.000000"), 10, false},
+		{"bitrate outside a tight tolerance", probe("h264", "4100000", "2.000000"), 1, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var p mediaProbe
+			if err := json.Unmarshal([]byte(tt.json), &p); err != nil {
+				t.Fatal(err)
+			}
+			remux, reason := matchesOptimizeTarget(p, tt.tolerance)
+			if remux != tt.wantRemux {
+				t.Errorf("remux = %v (%s), want %v", remux, reason, tt.wantRemux)
+			}
+			if !remux && reason == "" {
+				t.Error("transcode decision without a reason")
+			}
+		})
+	}
+}
+
+// argAfter returns the value following flag in args, or "".
+func argAfter(args []string, flag string) string {
+	for i := 0; i+1 < len(args); i++ {
+		if args[i] == flag {
+			return args[i+1]
+		}
+	}
+	return ""
+}
+
+func TestMediaWebhookAfterOptimization(t *testing.T) {
+	events := make(chan MediaWebhookEvent, 1)
+	hook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var e MediaWebhookEvent
+		if err := json.NewDecoder(r.Body).Decode(&e); err != nil {
+			t.Errorf("webhook body: %v", err)
+		}
+		events <- e
+	}))
+	defer hook.Close()
+
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "intro.mp4"), make([]byte, 1000), 0644)
+	c := newTestController(&Config{MediaWebhookURL: hook.URL, MediaWebhookRetries: 1}, nil)
+	// The optimizer container "encodes" by writing its temp output
+	var cmd []string
+	c.Docker = newFakeDocker(t, func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/containers/create":
+			var body struct{ Cmd []string }
+			json.NewDecoder(r.Body).Decode(&body)
+			cmd = body.Cmd
+			fmt.Fprint(w, `{"Id":"opt"}`)
+		case r.URL.Path == "/containers/opt/start":
+			os.WriteFile(filepath.Join(dir, "intro.optimized.temp.mp4"), make([]byte, 600), 0644)
+			w.WriteHeader(http.StatusNoContent)
+		case r.URL.Path == "/containers/opt/wait":
+			fmt.Fprint(w, `{"StatusCode":0}`)
+		case r.URL.Path == "/containers/opt/json":
+			fmt.Fprint(w, `{"Id":"opt","State":{"ExitCode":0}}`)
+		case r.URL.Path == "/containers/opt/logs":
+		case r.Method == "DELETE":
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			t.Errorf("unexpected Docker call %s %s", r.Method, r.URL.Path)
+		}
+	})
+
+	c.optimizeMediaFile(dir, "intro.mp4", filepath.Join(dir, ".intro.mp4.optimized"))
+
+	// The frame rate is the relay's to set per channel, so it is left alone
+	if argAfter(cmd, "-r") != "" || argAfter(cmd, "-g") != "" {
+		t.Errorf("optimizer re-times the file: %q", cmd)
+	}
+	if argAfter(cmd, "-force_key_frames") != "expr:gte(t,n_forced*2)" {
+		t.Errorf("optimizer keyframes = %q, want every 2s", argAfter(cmd, "-force_key_frames"))
+	}
+
+	select {
+	case e := <-events:
+		if e.Filename != "intro.mp4" || e.Status != "optimized" || e.OriginalSize != 1000 || e.FinalSize != 600 {
+			t.Errorf("webhook event = %+v, want intro.mp4 optimized 1000 -> 600 bytes", e)
+		}
+		if e.DurationSeconds <= 0 {
+			t.Errorf("duration_seconds = %v, want > 0", e.DurationSeconds)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("webhook not delivered")
+	}
+}
+
+func TestPostRelayUpdateRetriesFlakyRelay(t *testing.T) {
+	var attempts atomic.Int32
+	var down atomic.Bool
+	var landed atomic.Value
+	relay := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Still starting up on the first try
+		if attempts.Add(1) == 1 || down.Load() {
+			http.Error(w, "not ready", http.StatusServiceUnavailable)
+			return
+		}
+		var body struct {
+			VideoBitrate int `json:"video_bitrate"`
+		}
+		json.NewDecoder(r.Body).Decode(&body)
+		landed.Store(body.VideoBitrate)
+	}))
+	defer relay.Close()
+	routeRelaysTo(t, relay)
+
+	c := newTestController(&Config{RelayUpdateAttempts: 3, RelayUpdateBackoff: time.Millisecond}, nil)
+	if !c.postRelayUpdate("news", []byte(`{"video_bitrate":4500}`)) {
+		t.Fatal("postRelayUpdate gave up on a relay that recovered")
+	}
+	if n := attempts.Load(); n != 2 {
+		t.Errorf("%d attempts, want 2", n)
+	}
+	if got := landed.Load(); got != 4500 {
+		t.Errorf("relay received video_bitrate %v, want 4500", got)
+	}
+
+	// A relay that never recovers is given up on after the configured attempts
+	down.Store(true)
+	c.Config.RelayUpdateAttempts = 2
+	if c.postRelayUpdate("news", []byte(`{}`)) {
+		t.Error("postRelayUpdate succeeded against a failing relay")
+	}
+}
+
+func TestCreateChannelNameCollidesWithToken(t *testing.T) {
+	inserted := false
+	db := newFakeDB(t, func(query string, args []driver.Value) ([]string, [][]driver.Value, error) {
+		if cols, rows, ok := channelOwner(query, "org-a"); ok {
+			return cols, rows, nil
+		}
+		switch {
+		case strings.Contains(query, "SELECT EXISTS"):
+			if !strings.Contains(query, "obs_token = $1") || !strings.Contains(query, "loop_token = $1") {
+				t.Errorf("collision check doesn't cover tokens: %s", query)
+			}
+			// An existing channel's OBS token
+			return []string{"exists"}, [][]driver.Value{{args[0] == "a1b2c3d4"}}, nil
+		case strings.Contains(query, "FROM organizations"):
+			return []string{"id"}, [][]driver.Value{{"org-a"}}, nil
+		case strings.Contains(query, "INSERT INTO channels"):
+			inserted = true
+			return []string{"id"}, [][]driver.Value{{int64(2)}}, nil
+		}
+		return nil, nil, nil
+	})
+	c := newTestController(&Config{}, db)
+
+	req := httptest.NewRequest("POST", "/api/channels", strings.NewReader(`{"name":"a1b2c3d4","display_name":"Squatter"}`))
+	req = withIdentity(req, Identity{Role: RoleOperator, Org: "org-a"})
+	rec := httptest.NewRecorder()
+	c.ChannelsHandler(rec, req)
+	if rec.Code != http.StatusConflict {
+		t.Errorf("status = %d, want 409 (%s)", rec.Code, rec.Body.String())
+	}
+	if inserted {
+		t.Error("channel inserted despite the collision")
+	}
+}
+
+func TestPreflightMissingLoopSource(t *testing.T) {
+	srs := fakeSRS(t, `[]`)
+	db := newFakeDB(t, func(query string, args []driver.Value) ([]string, [][]driver.Value, error) {
+		return nil, nil, nil
+	})
+	dir := t.TempDir()
+	c := newTestController(&Config{SRSApiURL: srs.URL, MediaPath: dir}, db)
+	c.Media = &localMediaStore{dir: dir}
+	c.Docker = newFakeDocker(t, func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/info":
+			fmt.Fprint(w, `{"NCPU":8,"MemTotal":68719476736}`)
+		case "/containers/json":
+			fmt.Fprint(w, `[]`)
+		default:
+			t.Errorf("unexpected Docker call %s %s", r.Method, r.URL.Path)
+		}
+	})
+
+	ch := Channel{ID: 1, Name: "news", Enabled: true, OBSToken: "obs-secret", LoopToken: "loop-secret",
+		LoopEnabled: true, SourceMode: "file", LoopSourceFile: "intro.mp4"}
+	check := func(report PreflightReport, name string) PreflightCheck {
+		t.Helper()
+		for _, chk := range report.Checks {
+			if chk.Name == name {
+				return chk
+			}
+		}
+		t.Fatalf("no %s check in %+v", name, report.Checks)
+		return PreflightCheck{}
+	}
+
+	report := c.channelPreflight(context.Background(), ch)
+	if chk := check(report, "loop_source"); chk.Status != "fail" || !strings.Contains(chk.Message, "intro.mp4") {
+		t.Errorf("loop_source = %+v, want a fail naming intro.mp4", chk)
+	}
+	if report.Verdict != "fail" {
+		t.Errorf("verdict = %q, want fail", report.Verdict)
+	}
+	if chk := check(report, "srs"); chk.Status != "pass" {
+		t.Errorf("srs = %+v, want pass", chk)
+	}
+
+	// With the file in place the check passes
+	os.WriteFile(filepath.Join(dir, "intro.mp4"), []byte("x"), 0644)
+	if chk := check(c.channelPreflight(context.Background(), ch), "loop_source"); chk.Status != "pass" {
+		t.Errorf("loop_source with the file present = %+v, want pass", chk)
+	}
+}
+
+func TestFetchSRSStreamsNonzeroCode(t *testing.T) {
+	// SRS answers HTTP 200 with an error code and no streams
+	srs := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"code":1001,"server":"test","streams":[]}`)
+	}))
+	defer srs.Close()
+
+	var mu sync.Mutex
+	var writes []string
+	db := newFakeDB(t, func(query string, args []driver.Value) ([]string, [][]driver.Value, error) {
+		if strings.Contains(query, "FROM channels") && strings.HasPrefix(strings.TrimSpace(query), "SELECT") {
+			cols, rows := channelRows(nil)
+			return cols, rows, nil
+		}
+		if !strings.HasPrefix(strings.TrimSpace(query), "SELECT") {
+			mu.Lock()
+			writes = append(writes, query)
+			mu.Unlock()
+		}
+		return nil, nil, nil
+	})
+	c := newTestController(&Config{SRSApiURL: srs.URL, SRSApp: "live", StabilityWindow: 1}, db)
+	c.Docker = newFakeDocker(t, func(w http.ResponseWriter, r *http.Request) {
+		t.Errorf("reconcile acted on a failed SRS query: %s %s", r.Method, r.URL.Path)
+	})
+
+	if _, err := c.FetchSRSStreams(); err == nil || !strings.Contains(err.Error(), "1001") {
+		t.Errorf("FetchSRSStreams error = %v, want one naming code 1001", err)
+	}
+
+	// The reconciler skips the cycle rather than treating the channel as offline
+	c.Reconcile()
+	mu.Lock()
+	defer mu.Unlock()
+	if len(writes) != 0 {
+		t.Errorf("reconcile wrote %q after a failed SRS query", writes)
+	}
+}
+
+func TestSystemContainersOnlyManaged(t *testing.T) {
+	var removed []string
+	c := newTestController(&Config{}, newFakeDB(t, func(string, []driver.Value) ([]string, [][]driver.Value, error) {
+		return nil, nil, nil
+	}))
+	c.Docker = newFakeDocker(t, func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == "GET" && r.URL.Path == "/containers/json":
+			if !strings.Contains(r.URL.Query().Get("filters"), "managed_by=livestream-controller") {
+				t.Errorf("container list not filtered by label: %s", r.URL.RawQuery)
+			}
+			// The daemon ignores the filter here, so the handler must not trust it
+			fmt.Fprint(w, `[
+				{"Id":"aaaaaaaaaaaaaaaa","Names":["/relay-news"],"Image":"relay-manager","State":"running","Status":"Up 5 minutes",
+				 "Labels":{"managed_by":"livestream-controller","role":"relay","channel":"news"}},
+				{"Id":"bbbbbbbbbbbbbbbb","Names":["/postgres"],"Image":"postgres:16","State":"running","Status":"Up 2 days",
+				 "Labels":{"com.docker.compose.service":"postgres"}},
+				{"Id":"cccccccccccccccc","Names":["/loop-news"],"Image":"loop","State":"exited","Status":"Exited (1)",
+				 "Labels":{"managed_by":"livestream-controller","role":"loop","channel":"news"}}
+			]`)
+		case r.Method == "GET" && r.URL.Path == "/containers/aaaaaaaaaaaaaaaa/json":
+			fmt.Fprintf(w, `{"Id":"aaaaaaaaaaaaaaaa","State":{"StartedAt":%q}}`, time.Now().Add(-5*time.Minute).Format(time.RFC3339Nano))
+		case r.Method == "GET" && r.URL.Path == "/containers/postgres/json":
+			fmt.Fprint(w, `{"Id":"bbbbbbbbbbbbbbbb","Name":"/postgres","Config":{"Image":"postgres:16","Labels":{}}}`)
+		case r.Method == "DELETE":
+			removed = append(removed, r.URL.Path)
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			t.Errorf("unexpected Docker call %s %s", r.Method, r.URL.Path)
+		}
+	})
+
+	rec := httptest.NewRecorder()
+	c.SystemContainersHandler(rec, httptest.NewRequest("GET", "/api/system/containers", nil))
+	var got []ManagedContainer
+	if err := json.NewDecoder(rec.Body).Decode(&got); err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 2 || got[0].Name != "loop-news" || got[1].Name != "relay-news" {
+		t.Fatalf("listed %+v, want loop-news and relay-news only", got)
+	}
+	if got[1].Channel != "news" || got[1].Role != "relay" || got[1].Uptime != "5m" {
+		t.Errorf("relay-news = %+v, want channel news, role relay, uptime 5m", got[1])
+	}
+
+	// Removing an unmanaged container is refused without touching it
+	req := httptest.NewRequest("DELETE", "/api/system/containers/postgres", nil)
+	req = withIdentity(req, Identity{Role: RoleAdmin})
+	rec = httptest.NewRecorder()
+	c.SystemContainersHandler(rec, req)
+	if rec.Code != http.StatusNotFound || len(removed) != 0 {
+		t.Errorf("DELETE postgres: status %d, removed %v; want 404 and nothing removed", rec.Code, removed)
+	}
+}
+
+func TestCreateDestinationOverLimit(t *testing.T) {
+	for _, tt := range []struct {
+		enabled  int64
+		wantCode int
+	}{
+		{2, http.StatusOK},
+		{3, http.StatusConflict},
+	} {
+		inserted := false
+		db := newFakeDB(t, func(query string, args []driver.Value) ([]string, [][]driver.Value, error) {
+			switch {
+			case strings.Contains(query, "SELECT COUNT(*) FROM destinations"):
+				return []string{"count"}, [][]driver.Value{{tt.enabled}}, nil
+			case strings.Contains(query, "INSERT INTO destinations"):
+				inserted = true
+				return []string{"id"}, [][]driver.Value{{int64(4)}}, nil
+			}
+			return nil, nil, nil
+		})
+		c := newTestController(&Config{MaxDestinations: 3}, db)
+		body := `{"channel_id":1,"name":"Twitch","rtmp_url":"rtmp://live.twitch.tv/app","stream_key":"abc"}`
+		rec := httptest.NewRecorder()
+		c.DestinationsHandler(rec, httptest.NewRequest("POST", "/api/destinations", strings.NewReader(body)))
+		if rec.Code != tt.wantCode {
+			t.Errorf("with %d enabled: status = %d, want %d (%s)", tt.enabled, rec.Code, tt.wantCode, rec.Body.String())
+		}
+		if wantInsert := tt.wantCode == http.StatusOK; inserted != wantInsert {
+			t.Errorf("with %d enabled: inserted = %v, want %v", tt.enabled, inserted, wantInsert)
+		}
+	}
+}
+
+func TestOnPublishTokenFallbackSetsIngestHint(t *testing.T) {
+	obsHash := HashToken("obs-secret")
+	db := newFakeDB(t, func(query string, args []driver.Value) ([]string, [][]driver.Value, error) {
+		cols := []string{"id", "name", "obs_token_hash", "loop_token_hash", "obs_token", "loop_token", "obs_override_enabled"}
+		row := []driver.Value{int64(7), "news", obsHash, nil, "", "", false}
+		switch {
+		case strings.Contains(query, "FROM channels WHERE name") && args[0] == "news":
+			return cols, [][]driver.Value{row}, nil
+		case strings.Contains(query, "FROM channels WHERE obs_token") && args[0] == "obs-secret":
+			return cols, [][]driver.Value{row}, nil
+		case strings.Contains(query, "FROM channels WHERE") && !strings.Contains(query, "FROM channels WHERE id"):
+			return cols, nil, nil
+		case strings.Contains(query, "FROM channel_ip_allowlist"):
+			return []string{"id", "channel_id", "cidr", "description", "created_at"}, nil, nil
+		}
+		return nil, nil, nil
+	})
+	c := newTestController(&Config{SRSApp: "live", SRSApiURL: "http://127.0.0.1:1", CheckInterval: time.Minute}, db)
+	c.srsSnapshot = map[string]SRSStream{}
+	c.srsSnapshotAt = time.Now()
+
+	publish := func(stream string) {
+		t.Helper()
+		body := fmt.Sprintf(`{"action":"on_publish","app":"live","stream":%q,"param":"?token=obs-secret","ip":"203.0.113.9","client_id":"c1"}`, stream)
+		rec := httptest.NewRecorder()
+		c.OnPublishHandler(rec, httptest.NewRequest("POST", "/api/hooks/on_publish", strings.NewReader(body)))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("publish %s: status = %d (%s)", stream, rec.Code, rec.Body.String())
+		}
+	}
+	hint := func() string {
+		c.mu.RLock()
+		defer c.mu.RUnlock()
+		return c.ingestHints["news"]
+	}
+
+	// OBS put its token in the stream key field
+	publish("obs-secret")
+	if h := hint(); !strings.Contains(h, "news-obs?token=") {
+		t.Errorf("hint after token-as-stream publish = %q, want guidance towards news-obs", h)
+	}
+
+	// Once OBS is set up correctly the hint goes away
+	publish("news-obs")
+	if h := hint(); h != "" {
+		t.Errorf("hint after a correct publish = %q, want none", h)
+	}
+}
+
+func TestMediaMountWarnings(t *testing.T) {
+	tests := []struct {
+		name                  string
+		hostPath, mountSource string
+		inContainer           bool
+		readErr               error
+		want                  []string // substrings, one per expected warning
+	}{
+		{"matching mount", "/srv/media", "/srv/media", true, nil, nil},
+		{"trailing slash still matches", "/srv/media/", "/srv/media", true, nil, nil},
+		{"outside Docker", "/srv/media", "", false, nil, nil},
+		{"mismatched mount", "/srv/media", "/data/uploads", true, nil, []string{"mounted from /data/uploads but MEDIA_HOST_PATH is /srv/media"}},
+		{"not mounted", "/srv/media", "", true, nil, []string{"not mounted from the host"}},
+		{"unset host path", "", "/srv/media", true, nil, []string{"MEDIA_HOST_PATH is not set"}},
+		{"relative host path", "./media", "", false, nil, []string{"is relative"}},
+		{"unreadable", "/srv/media", "/srv/media", true, errors.New("permission denied"), []string{"cannot read /app/media"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := mediaMountWarnings("/app/media", tt.hostPath, tt.mountSource, tt.inContainer, tt.readErr)
+			if len(got) != len(tt.want) {
+				t.Fatalf("warnings = %q, want %d", got, len(tt.want))
+			}
+			for i, want := range tt.want {
+				if !strings.Contains(got[i], want) {
+					t.Errorf("warning %d = %q, want it to mention %q", i, got[i], want)
+				}
+			}
+		})
+	}
+}
+
+func TestEmergencyStopAttemptsEveryStep(t *testing.T) {
+	var mu sync.Mutex
+	var calls []string
+	call := func(s string) {
+		mu.Lock()
+		calls = append(calls, s)
+		mu.Unlock()
+	}
+
+	srs := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "DELETE" {
+			call("srs " + r.URL.Path)
+			fmt.Fprint(w, `{"code":0}`)
+			return
+		}
+		fmt.Fprint(w, `{"code":0,"streams":[{"name":"news-obs","app":"live","publish":{"active":true,"cid":"obs1"}}]}`)
+	}))
+	defer srs.Close()
+	db := newFakeDB(t, func(query string, args []driver.Value) ([]string, [][]driver.Value, error) {
+		if strings.HasPrefix(strings.TrimSpace(query), "UPDATE channels SET enabled = false") {
+			call("db disable")
+			return nil, nil, errors.New("connection reset")
+		}
+		return nil, nil, nil
+	})
+	c := newTestController(&Config{SRSApiURL: srs.URL, SRSApp: "live"}, db)
+	c.Docker = newFakeDocker(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "DELETE" {
+			t.Errorf("unexpected Docker call %s %s", r.Method, r.URL.Path)
+			return
+		}
+		call("docker " + r.URL.Path)
+		if r.URL.Path == "/containers/loop-news" {
+			http.Error(w, `{"message":"removal in progress"}`, http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	rec := httptest.NewRecorder()
+	c.emergencyStop(rec, httptest.NewRequest("POST", "/api/channels/7/emergency-stop", nil), Channel{ID: 7, Name: "news"})
+
+	mu.Lock()
+	got := strings.Join(calls, ", ")
+	mu.Unlock()
+	for _, want := range []string{"db disable", "docker /containers/loop-news", "docker /containers/relay-news", "srs /api/v1/clients/obs1"} {
+		if !strings.Contains(got, want) {
+			t.Errorf("%q not attempted; calls: %s", want, got)
+		}
+	}
+
+	var resp struct {
+		Status string            `json:"status"`
+		Steps  map[string]string `json:"steps"`
+		Kicked int               `json:"kicked_publishers"`
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp.Status != "partial" || resp.Kicked != 1 {
+		t.Errorf("status %q, kicked %d; want partial and 1", resp.Status, resp.Kicked)
+	}
+	if resp.Steps["disable"] == "ok" || resp.Steps["loop"] == "ok" || resp.Steps["relay"] != "ok" || resp.Steps["publishers"] != "ok" {
+		t.Errorf("steps = %v, want disable and loop failed, relay and publishers ok", resp.Steps)
+	}
+}
+
+func TestReencryptTokensAfterKeyRotation(t *testing.T) {
+	oldKey := bytes.Repeat([]byte{0x1}, 32)
+	newKey := bytes.Repeat([]byte{0x2}, 32)
+	useKeys(t, oldKey, nil)
+	staleEnc, staleIV, _ := Encrypt("obs-secret")
+	useKeys(t, bytes.Repeat([]byte{0x3}, 32), nil)
+	lostEnc, lostIV, _ := Encrypt("lost-secret")
+	useKeys(t, newKey, nil)
+	currentEnc, currentIV, _ := Encrypt("loop-secret")
+	useKeys(t, newKey, oldKey)
+
+	type update struct {
+		query     string
+		enc, iv   string
+		channelID int64
+	}
+	var updates []update
+	var audited string
+	db := newFakeDB(t, func(query string, args []driver.Value) ([]string, [][]driver.Value, error) {
+		query = strings.TrimSpace(query)
+		switch {
+		case strings.HasPrefix(query, "SELECT id, name"):
+			return []string{"id", "name", "obs_enc", "obs_iv", "loop_enc", "loop_iv"}, [][]driver.Value{
+				{int64(1), "news", staleEnc, staleIV, currentEnc, currentIV},
+				{int64(2), "sports", lostEnc, lostIV, "", ""},
+			}, nil
+		case strings.HasPrefix(query, "UPDATE channels"):
+			updates = append(updates, update{query, args[0].(string), args[1].(string), args[2].(int64)})
+		case strings.HasPrefix(query, "INSERT INTO audit_logs"):
+			audited = args[3].(string)
+		}
+		return nil, nil, nil
+	})
+	c := newTestController(&Config{}, db)
+
+	req := httptest.NewRequest("POST", "/api/system/reencrypt-tokens", nil)
+	req = withIdentity(req, Identity{Role: RoleAdmin})
+	rec := httptest.NewRecorder()
+	c.ReencryptTokensHandler(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d (%s)", rec.Code, rec.Body.String())
+	}
+
+	// Only the token still under the old key is rewritten, now readable with
+	// the new key alone
+	if len(updates) != 1 || updates[0].channelID != 1 || !strings.Contains(updates[0].query, "obs_token_encrypted") {
+		t.Fatalf("updates = %+v, want channel 1's obs_token only", updates)
+	}
+	useKeys(t, newKey, nil)
+	if plaintext, err := Decrypt(updates[0].enc, updates[0].iv); err != nil || plaintext != "obs-secret" {
+		t.Errorf("re-encrypted token decrypts to %q, %v; want obs-secret", plaintext, err)
+	}
+
+	var resp struct {
+		Migrated int      `json:"migrated"`
+		Current  int      `json:"current"`
+		Failed   []string `json:"failed"`
+	}
+	json.NewDecoder(rec.Body).Decode(&resp)
+	if resp.Migrated != 1 || resp.Current != 1 || len(resp.Failed) != 1 || !strings.HasPrefix(resp.Failed[0], "sports/obs_token") {
+		t.Errorf("response = %+v, want 1 migrated, 1 current, sports/obs_token failed", resp)
+	}
+	if audited != `{"current":1,"failed":1,"migrated":1}` {
+		t.Errorf("audit details = %s", audited)
+	}
+}
+
+// inspectLatency stands in for one ContainerInspect round trip.
+const inspectLatency = 5 * time.Millisecond
+
+func TestForEachLimitBoundsConcurrency(t *testing.T) {
+	const channels = 40
+	run := func(limit int) (time.Duration, int32) {
+		var inFlight, peak atomic.Int32
+		seen := make([]atomic.Bool, channels)
+		start := time.Now()
+		forEachLimit(channels, limit, func(i int) {
+			n := inFlight.Add(1)
+			for {
+				p := peak.Load()
+				if n <= p || peak.CompareAndSwap(p, n) {
+					break
+				}
+			}
+			time.Sleep(inspectLatency)
+			seen[i].Store(true)
+			inFlight.Add(-1)
+		})
+		for i := range seen {
+			if !seen[i].Load() {
+				t.Errorf("limit %d: channel %d not reconciled", limit, i)
+			}
+		}
+		return time.Since(start), peak.Load()
+	}
+
+	serial, peak := run(1)
+	if peak != 1 {
+		t.Errorf("limit 1 ran %d at once", peak)
+	}
+	parallel, peak := run(8)
+	if peak > 8 {
+		t.Errorf("limit 8 ran %d at once", peak)
+	}
+	if parallel > serial/2 {
+		t.Errorf("40 channels took %v with limit 8 vs %v serially, want at least twice as fast", parallel, serial)
+	}
+}
+
+func BenchmarkReconcileConcurrency(b *testing.B) {
+	for _, limit := range []int{1, 4, 16} {
+		b.Run(fmt.Sprintf("limit=%d", limit), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				forEachLimit(50, limit, func(int) { time.Sleep(inspectLatency) })
+			}
+		})
+	}
+}
+
+func TestPausedDestinationLeftOutOfRelay(t *testing.T) {
+	var mu sync.Mutex
+	var pushed []string
+	relay := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/update" {
+			var payload struct {
+				Destinations []string `json:"destinations"`
+			}
+			json.NewDecoder(r.Body).Decode(&payload)
+			mu.Lock()
+			pushed = payload.Destinations
+			mu.Unlock()
+			return
+		}
+		fmt.Fprint(w, `{"destinations":[]}`)
+	}))
+	defer relay.Close()
+	routeRelaysTo(t, relay)
+
+	paused := map[int64]bool{}
+	db := newFakeDB(t, func(query string, args []driver.Value) ([]string, [][]driver.Value, error) {
+		if strings.HasPrefix(query, "UPDATE destinations SET paused") {
+			mu.Lock()
+			paused[args[1].(int64)] = args[0].(bool)
+			mu.Unlock()
+			return nil, [][]driver.Value{{}}, nil
+		}
+		return nil, nil, nil
+	})
+	c := newTestController(&Config{RelayImage: "relay-manager:latest", RelayUpdateAttempts: 1}, db)
+	c.Docker = fakeRunningRelay(t, "news", "relay-manager:latest")
+
+	action := func(path string) {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		c.DestinationActionHandler(rec, httptest.NewRequest("POST", path, nil))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("POST %s: status = %d (%s)", path, rec.Code, rec.Body.String())
+		}
+	}
+	reconcile := func() []string {
+		t.Helper()
+		mu.Lock()
+		ch := Channel{Name: "news", Destinations: []Destination{
+			{ID: 1, Name: "yt", RTMPURL: "rtmp://a.example/live", StreamKey: "one", Enabled: true, Paused: paused[1]},
+			{ID: 2, Name: "twitch", RTMPURL: "rtmp://b.example/app", StreamKey: "two", Enabled: true, Paused: paused[2]},
+		}}
+		pushed = nil
+		mu.Unlock()
+		c.ReconcileDestinations(ch, true)
+		mu.Lock()
+		defer mu.Unlock()
+		return pushed
+	}
+
+	action("/api/destinations/1/pause")
+	if got := reconcile(); fmt.Sprint(got) != "[rtmp://b.example/app/two]" {
+		t.Errorf("relay destinations while paused = %v, want only twitch", got)
+	}
+	action("/api/destinations/1/resume")
+	if got := reconcile(); fmt.Sprint(got) != "[rtmp://a.example/live/one rtmp://b.example/app/two]" {
+		t.Errorf("relay destinations after resume = %v, want both in order", got)
+	}
+}
+
+func TestClampFailoverTimeout(t *testing.T) {
+	tests := []struct{ in, want int }{
+		{-5, defaultFailoverTimeoutSeconds},
+		{0, defaultFailoverTimeoutSeconds},
+		{1, 2},
+		{2, 2},
+		{45, 45},
+		{300, 300},
+		{301, 300},
+		{10000, 300},
+	}
+	for _, tt := range tests {
+		if got := clampFailoverTimeout(tt.in); got != tt.want {
+			t.Errorf("clampFailoverTimeout(%d) = %d, want %d", tt.in, got, tt.want)
+		}
+	}
+
+	// A channel saved before validation existed reports the same default window
+	c := newTestController(&Config{}, newFakeDB(t, func(query string, args []driver.Value) ([]string, [][]driver.Value, error) {
+		if cols, rows, ok := channelOwner(query, "org-a"); ok {
+			return cols, rows, nil
+		}
+		if strings.Contains(query, "failover_timeout_seconds FROM channels") {
+			return []string{"id", "name", "failover_timeout_seconds"}, [][]driver.Value{{int64(1), "news", int64(0)}}, nil
+		}
+		return nil, nil, nil
+	}))
+	c.Docker = newFakeDocker(t, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	})
+	req := httptest.NewRequest("POST", "/api/takeover/news", nil)
+	req = withIdentity(req, Identity{Role: RoleOperator, Org: "org-a"})
+	rec := httptest.NewRecorder()
+	c.TakeoverHandler(rec, req)
+	var resp struct{ Message string }
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+	if want := fmt.Sprintf("(%ds window)", defaultFailoverTimeoutSeconds); !strings.Contains(resp.Message, want) {
+		t.Errorf("takeover message %q does not mention %s", resp.Message, want)
+	}
+}
+
+func TestObserveLiveMsSurvivesSRSRestart(t *testing.T) {
+	c := newTestController(&Config{}, nil)
+	start := time.Now()
+
+	if got := c.observeLiveMs("news", 60_000, start); got != time.Minute {
+		t.Fatalf("first sample uptime = %v, want 1m0s", got)
+	}
+	// SRS restarts 30s later and reports the stream as freshly live
+	if got := c.observeLiveMs("news", 500, start.Add(30*time.Second)); got != 90*time.Second {
+		t.Errorf("uptime after live_ms reset = %v, want 1m30s", got)
+	}
+	if got := c.observeLiveMs("news", 10_500, start.Add(40*time.Second)); got != 100*time.Second {
+		t.Errorf("uptime after reset keeps counting = %v, want 1m40s", got)
+	}
+
+	// A negative live_ms or a clock that stepped back never yields negative uptime
+	if got := c.observeLiveMs("sports", -5, start); got != 0 {
+		t.Errorf("negative live_ms uptime = %v, want 0", got)
+	}
+	if got := c.observeLiveMs("sports", 0, start.Add(-time.Minute)); got < 0 {
+		t.Errorf("uptime after clock step back = %v, want >= 0", got)
+	}
+}
+
+func TestChannelImageOverride(t *testing.T) {
+	cfg := &Config{LoopImage: "loop-publisher:stable", RelayImage: "relay-manager:stable", RelayUpdateAttempts: 1}
+	docker, containers := newFakeContainers(t)
+	c := newTestController(cfg, newFakeDB(t, func(string, []driver.Value) ([]string, [][]driver.Value, error) {
+		return nil, nil, nil
+	}))
+	c.Docker = docker
+	c.Media = &localMediaStore{dir: t.TempDir()}
+
+	c.EnsureContainerRunning(Channel{Name: "news", SourceMode: "testpattern", LoopToken: "loop-secret", LoopImage: "loop-publisher:canary"}, "loop-news")
+	c.EnsureContainerRunning(Channel{Name: "sports", SourceMode: "testpattern", LoopToken: "loop-secret"}, "loop-sports")
+	if ct, _ := containers.container("loop-news"); ct.Image != "loop-publisher:canary" {
+		t.Errorf("overridden loop image = %q, want loop-publisher:canary", ct.Image)
+	}
+	if ct, _ := containers.container("loop-sports"); ct.Image != "loop-publisher:stable" {
+		t.Errorf("default loop image = %q, want loop-publisher:stable", ct.Image)
+	}
+
+	// A relay already on its channel's override is current, not an image
+	// mismatch to recreate; the fake fails the test on any remove or create
+	relay := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer relay.Close()
+	routeRelaysTo(t, relay)
+	c.Docker = fakeRunningRelay(t, "news", "relay-manager:canary")
+	ch := Channel{Name: "news", RelayImage: "relay-manager:canary",
+		Destinations: []Destination{{ID: 1, Name: "yt", RTMPURL: "rtmp://a.example/live", StreamKey: "k", Enabled: true}}}
+	c.EnsureRelayRunning(ch, ch.Destinations, "relay-news")
+}
+
+func TestLogsDumpAndClear(t *testing.T) {
+	var audited []driver.Value
+	c := newTestController(&Config{}, newFakeDB(t, func(query string, args []driver.Value) ([]string, [][]driver.Value, error) {
+		if cols, rows, ok := channelOwner(query, "org-a"); ok {
+			return cols, rows, nil
+		}
+		if strings.Contains(query, "INSERT INTO audit_logs") {
+			audited = args
+		}
+		return nil, nil, nil
+	}))
+	for i := 0; i < 150; i++ {
+		c.Log("info", "test", fmt.Sprintf("entry %d", i))
+	}
+
+	dump := func() []LogEntry {
+		rec := httptest.NewRecorder()
+		c.LogsDumpHandler(rec, httptest.NewRequest("GET", "/api/logs/dump", nil))
+		var resp struct{ Logs []LogEntry }
+		if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+			t.Fatal(err)
+		}
+		return resp.Logs
+	}
+	// The dump is the whole buffer, not the default page of 100
+	if logs := dump(); len(logs) != 150 || logs[0].Message != "entry 0" || logs[149].Message != "entry 149" {
+		t.Fatalf("dump returned %d entries, want all 150 oldest first", len(logs))
+	}
+
+	clearAs := func(role string) int {
+		req := httptest.NewRequest("DELETE", "/api/logs", nil)
+		req = withIdentity(req, Identity{Role: role, Org: "org-a"})
+		rec := httptest.NewRecorder()
+		c.LogsHandler(rec, req)
+		return rec.Code
+	}
+	if code := clearAs(RoleOperator); code != http.StatusForbidden || len(dump()) != 150 {
+		t.Errorf("operator clear: status %d, want 403 and the buffer kept", code)
+	}
+	if code := clearAs(RoleAdmin); code != http.StatusOK {
+		t.Fatalf("admin clear: status %d, want 200", code)
+	}
+	// Only the note about the clear itself remains
+	if logs := dump(); len(logs) != 1 || !strings.Contains(logs[0].Message, "150 entries") {
+		t.Errorf("after clear the buffer holds %+v, want just the clear note", logs)
+	}
+	if len(audited) == 0 || audited[0] != "LOGS_CLEARED" {
+		t.Errorf("clear audited as %v, want LOGS_CLEARED", audited)
+	}
+}
+
+func TestLoopCrashLoopStopsRecreating(t *testing.T) {
+	var mu sync.Mutex
+	var creates, inspects int
+	started := time.Now().Add(-time.Minute)
+	c := newTestController(&Config{LoopImage: "loop-publisher:latest", LoopCrashWindow: 10 * time.Second, LoopCrashThreshold: 3},
+		newFakeDB(t, func(string, []driver.Value) ([]string, [][]driver.Value, error) { return nil, nil, nil }))
+	c.Media = &localMediaStore{dir: t.TempDir()}
+	c.Docker = newFakeDocker(t, func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		switch {
+		case r.Method == "GET" && r.URL.Path == "/containers/loop-news/json":
+			inspects++
+			// Every run dies a second after starting
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"Id":     "loop-news",
+				"Config": map[string]interface{}{"Image": "loop-publisher:latest"},
+				"State": map[string]interface{}{"Running": false, "ExitCode": 1,
+					"StartedAt": started.Format(time.RFC3339Nano), "FinishedAt": started.Add(time.Second).Format(time.RFC3339Nano)},
+			})
+		case r.Method == "GET" && r.URL.Path == "/containers/loop-news/logs":
+			// Docker multiplexes the log stream in 8-byte-header frames
+			line := "intro.mp4: Invalid data found when processing input\n"
+			w.Write(append([]byte{2, 0, 0, 0, 0, 0, 0, byte(len(line))}, line...))
+		case r.Method == "POST" && r.URL.Path == "/containers/create":
+			creates++
+			fmt.Fprint(w, `{"Id":"loop-news"}`)
+		default:
+			w.WriteHeader(http.StatusNoContent)
+		}
+	})
+
+	counts := func() (int, int) {
+		mu.Lock()
+		defer mu.Unlock()
+		return creates, inspects
+	}
+
+	ch := Channel{Name: "news", SourceMode: "testpattern", LoopToken: "loop-secret"}
+	for i := 0; i < 5; i++ {
+		c.EnsureContainerRunning(ch, "loop-news")
+	}
+	// Two fast exits are retried; the third trips CRASH_LOOP and after that
+	// the container isn't even inspected
+	if n, m := counts(); n != 2 || m != 3 {
+		t.Errorf("%d creates, %d inspects; want 2 and 3", n, m)
+	}
+	lc := c.loopCrashInfo("news")
+	if lc == nil || !lc.Tripped || lc.FastExits != 3 || lc.ExitCode != 1 {
+		t.Fatalf("crash record = %+v, want tripped after 3 fast exits with code 1", lc)
+	}
+	if len(lc.LastLogs) != 1 || !strings.Contains(lc.LastLogs[0], "Invalid data") {
+		t.Errorf("last logs = %q, want the container's error line", lc.LastLogs)
+	}
+
+	// Restarting the channel clears the record and allows another attempt
+	c.clearLoopCrash("news")
+	c.EnsureContainerRunning(ch, "loop-news")
+	if n, _ := counts(); n != 3 {
+		t.Errorf("%d creates after clearing the crash loop, want 3", n)
+	}
+}
+
+func TestIngestURLsUsePublicRTMPHost(t *testing.T) {
+	db := newFakeDB(t, func(query string, args []driver.Value) ([]string, [][]driver.Value, error) {
+		if cols, rows, ok := channelOwner(query, "org-a"); ok {
+			return cols, rows, nil
+		}
+		switch {
+		case strings.Contains(query, "SELECT id, name, display_name, enabled, loop_enabled"):
+			return []string{"id", "name", "display_name", "enabled", "loop_enabled"},
+				[][]driver.Value{{int64(1), "news", "News", true, true}}, nil
+		case strings.Contains(query, "obs_token_encrypted, obs_token_iv"):
+			return []string{"name", "obs_token", "loop_token", "obs_token_encrypted", "obs_token_iv", "loop_token_encrypted", "loop_token_iv"},
+				[][]driver.Value{{"news", "obs-secret", "loop-secret", nil, nil, nil, nil}}, nil
+		case strings.Contains(query, "failover_timeout_seconds FROM channels"):
+			return []string{"id", "name", "failover_timeout_seconds"}, [][]driver.Value{{int64(1), "news", int64(10)}}, nil
+		}
+		return nil, nil, nil
+	})
+	c := newTestController(&Config{PublicRTMPHost: "ingest.example.com", PublicRTMPPort: "1935", SRSApp: "live"}, db)
+	c.Docker = newFakeDocker(t, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	req := httptest.NewRequest("GET", "/api/channels/1/ingest", nil)
+	req = withIdentity(req, Identity{Role: RoleOperator, Org: "org-a"})
+	rec := httptest.NewRecorder()
+	c.ChannelActionHandler(rec, req)
+	var info IngestInfo
+	if err := json.NewDecoder(rec.Body).Decode(&info); err != nil {
+		t.Fatalf("status %d: %v", rec.Code, err)
+	}
+	if info.Server != "rtmp://ingest.example.com:1935/live" || info.StreamKey != "news-obs?token=obs-secret" {
+		t.Errorf("server %q, stream key %q; want the public host and the OBS stream key", info.Server, info.StreamKey)
+	}
+	if info.URL != "rtmp://ingest.example.com:1935/live/news-obs?token=obs-secret" {
+		t.Errorf("url = %q", info.URL)
+	}
+
+	req = httptest.NewRequest("POST", "/api/takeover/news", nil)
+	req = withIdentity(req, Identity{Role: RoleOperator, Org: "org-a"})
+	rec = httptest.NewRecorder()
+	c.TakeoverHandler(rec, req)
+	var takeover struct {
+		RTMPURL string `json:"rtmp_url"`
+	}
+	json.NewDecoder(rec.Body).Decode(&takeover)
+	if takeover.RTMPURL != "rtmp://ingest.example.com:1935/live/news" {
+		t.Errorf("takeover rtmp_url = %q, want the public host", takeover.RTMPURL)
+	}
+}
+
+func TestIngestInfoDecryptsTokensForOperators(t *testing.T) {
+	useKeys(t, bytes.Repeat([]byte{0x3}, 32), nil)
+	obsEnc, obsIV, _ := Encrypt("obs-secret")
+	loopEnc, loopIV, _ := Encrypt("loop-secret")
+	db := newFakeDB(t, func(query string, args []driver.Value) ([]string, [][]driver.Value, error) {
+		if cols, rows, ok := channelOwner(query, "org-a"); ok {
+			return cols, rows, nil
+		}
+		switch {
+		case strings.Contains(query, "SELECT id, name, display_name, enabled, loop_enabled"):
+			return []string{"id", "name", "display_name", "enabled", "loop_enabled"},
+				[][]driver.Value{{int64(1), "news", "News", true, true}}, nil
+		case strings.Contains(query, "obs_token_encrypted, obs_token_iv"):
+			// Migrated channels keep only the encrypted tokens
+			return []string{"name", "obs_token", "loop_token", "obs_token_encrypted", "obs_token_iv", "loop_token_encrypted", "loop_token_iv"},
+				[][]driver.Value{{"news", "", "", obsEnc, obsIV, loopEnc, loopIV}}, nil
+		}
+		return nil, nil, nil
+	})
+	c := newTestController(&Config{PublicRTMPHost: "ingest.example.com", PublicRTMPPort: "1935", SRSApp: "live"}, db)
+
+	get := func(role string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/api/channels/1/ingest", nil)
+		req = withIdentity(req, Identity{Role: role, Org: "org-a"})
+		rec := httptest.NewRecorder()
+		c.ChannelActionHandler(rec, req)
+		return rec
+	}
+	if rec := get(RoleViewer); rec.Code != http.StatusForbidden || strings.Contains(rec.Body.String(), "secret") {
+		t.Errorf("viewer: status %d, body %q; want 403 without tokens", rec.Code, rec.Body.String())
+	}
+
+	var info IngestInfo
+	if err := json.NewDecoder(get(RoleOperator).Body).Decode(&info); err != nil {
+		t.Fatal(err)
+	}
+	want := IngestInfo{
+		Channel:       "news",
+		Server:        "rtmp://ingest.example.com:1935/live",
+		StreamKey:     "news-obs?token=obs-secret",
+		URL:           "rtmp://ingest.example.com:1935/live/news-obs?token=obs-secret",
+		OBSToken:      "obs-secret",
+		LoopToken:     "loop-secret",
+		LoopStreamKey: "news?token=loop-secret",
+	}
+	if info != want {
+		t.Errorf("ingest info = %+v\nwant %+v", info, want)
+	}
+}
+
+func TestBeginOptimizeOnePerFile(t *testing.T) {
+	c := newTestController(&Config{OptimizeConcurrency: 2}, nil)
+
+	// Overlapping scan ticks all find intro.mp4 unmarked; only one claims it
+	var wg sync.WaitGroup
+	var claimed atomic.Int32
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if c.beginOptimize("intro.mp4") {
+				claimed.Add(1)
+			}
+		}()
+	}
+	wg.Wait()
+	if n := claimed.Load(); n != 1 {
+		t.Fatalf("intro.mp4 claimed %d times, want 1", n)
+	}
+
+	// Other files share the remaining slots, and wait once they run out
+	if !c.beginOptimize("outro.mp4") {
+		t.Error("outro.mp4 not claimed with a slot free")
+	}
+	if c.beginOptimize("promo.mp4") {
+		t.Error("promo.mp4 claimed beyond OPTIMIZE_CONCURRENCY")
+	}
+
+	// Once it finishes the file can be optimized again, e.g. after a re-upload
+	c.endOptimize("intro.mp4")
+	if !c.beginOptimize("intro.mp4") {
+		t.Error("intro.mp4 not claimable after its optimization ended")
+	}
+}
+
+func TestOBSPublishWithOverrideOffKeepsLoop(t *testing.T) {
+	for _, override := range []bool{true, false} {
+		t.Run(fmt.Sprintf("override %v", override), func(t *testing.T) {
+			var switched atomic.Bool
+			db := newFakeDB(t, func(query string, args []driver.Value) ([]string, [][]driver.Value, error) {
+				switch {
+				case strings.Contains(query, "FROM channels WHERE name"):
+					return []string{"id", "name", "obs_token_hash", "loop_token_hash", "obs_token", "loop_token", "obs_override_enabled"},
+						[][]driver.Value{{int64(7), "news", HashToken("obs-secret"), HashToken("loop-secret"), "", "", override}}, nil
+				case strings.Contains(query, "FROM channel_ip_allowlist"):
+					return []string{"id", "channel_id", "cidr", "description", "created_at"}, nil, nil
+				case strings.Contains(query, "current_active_source = 'OBS'"):
+					switched.Store(true)
+				}
+				return nil, nil, nil
+			})
+			c := newTestController(&Config{SRSApp: "live", SRSApiURL: "http://127.0.0.1:1", CheckInterval: time.Minute}, db)
+			c.srsSnapshot = map[string]SRSStream{}
+			c.srsSnapshotAt = time.Now()
+			stopped := make(chan struct{}, 1)
+			c.Docker = newFakeDocker(t, func(w http.ResponseWriter, r *http.Request) {
+				if r.Method == "DELETE" && r.URL.Path == "/containers/loop-news" {
+					stopped <- struct{}{}
+				}
+				w.WriteHeader(http.StatusNoContent)
+			})
+
+			body := `{"action":"on_publish","app":"live","stream":"news-obs","param":"?token=obs-secret","ip":"203.0.113.9","client_id":"c1"}`
+			rec := httptest.NewRecorder()
+			c.OnPublishHandler(rec, httptest.NewRequest("POST", "/api/hooks/on_publish", strings.NewReader(body)))
+			if rec.Code != http.StatusOK {
+				t.Fatalf("publish: status = %d (%s)", rec.Code, rec.Body.String())
+			}
+
+			var loopStopped bool
+			select {
+			case <-stopped:
+				loopStopped = true
+			case <-time.After(200 * time.Millisecond):
+			}
+			c.mu.RLock()
+			_, cooldown := c.takeoverCooldown["news"]
+			c.mu.RUnlock()
+			if loopStopped != override || cooldown != override || switched.Load() != override {
+				t.Errorf("loop stopped %v, cooldown set %v, source switched %v; want all %v",
+					loopStopped, cooldown, switched.Load(), override)
+			}
+		})
+	}
+}
+
+func TestDBQueriesHitDeadline(t *testing.T) {
+	c := newTestController(&Config{DBQueryTimeout: 50 * time.Millisecond}, newBlockingDB(t))
+
+	start := time.Now()
+	if _, err := c.GetChannels(context.Background()); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("GetChannels error = %v, want a deadline error", err)
+	}
+	if took := time.Since(start); took > time.Second {
+		t.Errorf("GetChannels took %v against a hung database", took)
+	}
+
+	// SRS waits on the publish hook, so it must answer rather than hang
+	body := `{"action":"on_publish","app":"live","stream":"news-obs","param":"?token=obs-secret"}`
+	rec := httptest.NewRecorder()
+	start = time.Now()
+	c.OnPublishHandler(rec, httptest.NewRequest("POST", "/api/hooks/on_publish", strings.NewReader(body)))
+	if rec.Code != http.StatusInternalServerError {
+		t.Errorf("publish hook status = %d, want 500", rec.Code)
+	}
+	if took := time.Since(start); took > time.Second {
+		t.Errorf("publish hook took %v against a hung database", took)
+	}
+}
+
+func TestMediaExtensionsAppliedEverywhere(t *testing.T) {
+	dir := t.TempDir()
+	cfg := &Config{MaxUploadBytes: 1 << 20, MediaPath: dir, MediaExtensions: parseMediaExtensions("mp4, WEBM")}
+	c := newTestController(cfg, nil)
+	c.Media = &localMediaStore{dir: dir}
+
+	upload := func(name string) int {
+		var body bytes.Buffer
+		mw := multipart.NewWriter(&body)
+		part, _ := mw.CreateFormFile("file", name)
+		part.Write([]byte("video"))
+		mw.Close()
+		req := httptest.NewRequest("POST", "/api/upload", &body)
+		req.Header.Set("Content-Type", mw.FormDataContentType())
+		rec := httptest.NewRecorder()
+		c.UploadHandler(rec, req)
+		return rec.Code
+	}
+	for name, want := range map[string]int{"intro.mp4": http.StatusOK, "promo.webm": http.StatusOK, "clip.ts": http.StatusBadRequest} {
+		if code := upload(name); code != want {
+			t.Errorf("upload %s: status %d, want %d", name, code, want)
+		}
+	}
+	// Files that arrived some other way are filtered the same
+	os.WriteFile(filepath.Join(dir, "stray.ts"), []byte("video"), 0644)
+
+	rec := httptest.NewRecorder()
+	c.MediaHandler(rec, httptest.NewRequest("GET", "/api/media", nil))
+	var listed []string
+	json.NewDecoder(rec.Body).Decode(&listed)
+	sort.Strings(listed)
+	if fmt.Sprint(listed) != "[intro.mp4 promo.webm]" {
+		t.Errorf("media list = %v, want intro.mp4 and promo.webm", listed)
+	}
+
+	rec = httptest.NewRecorder()
+	c.MediaStatusHandler(rec, httptest.NewRequest("GET", "/api/media/status", nil))
+	var status []struct{ Filename string }
+	json.NewDecoder(rec.Body).Decode(&status)
+	if len(status) != 2 {
+		t.Errorf("media status lists %+v, want intro.mp4 and promo.webm", status)
+	}
+	if c.isAllowedMedia("stray.ts") || !c.isAllowedMedia("PROMO.WEBM") {
+		t.Error("optimizer filter disagrees with the configured extensions")
+	}
+
+	rec = httptest.NewRecorder()
+	c.MediaLimitsHandler(rec, httptest.NewRequest("GET", "/api/media/limits", nil))
+	var limits struct {
+		AllowedExtensions []string `json:"allowed_extensions"`
+	}
+	json.NewDecoder(rec.Body).Decode(&limits)
+	if fmt.Sprint(limits.AllowedExtensions) != "[.mp4 .webm]" {
+		t.Errorf("allowed_extensions = %v, want [.mp4 .webm]", limits.AllowedExtensions)
+	}
+}
+
+func TestFreshLoopReportsStarting(t *testing.T) {
+	var live atomic.Bool
+	srs := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		streams := "[]"
+		if live.Load() {
+			streams = `[{"name":"news","app":"live","live_ms":1000,"publish":{"active":true},"kbps":{"recv_30s":2500}}]`
+		}
+		w.Write([]byte(`{"code":0,"streams":` + streams + `}`))
+	}))
+	defer srs.Close()
+	db := newFakeDB(t, func(query string, args []driver.Value) ([]string, [][]driver.Value, error) {
+		if strings.Contains(query, "FROM channels") {
+			cols, rows := channelRows(map[string]driver.Value{})
+			return cols, rows, nil
+		}
+		return nil, nil, nil
+	})
+	c := newTestController(&Config{SRSApiURL: srs.URL, SRSApp: "live", StartingGrace: time.Minute}, db)
+	c.Docker, _ = newFakeContainers(t)
+	c.Media = &localMediaStore{dir: t.TempDir()}
+	status := func() string {
+		t.Helper()
+		channels, err := c.GetChannels(context.Background())
+		if err != nil || len(channels) != 1 {
+			t.Fatalf("GetChannels = %v, %v", channels, err)
+		}
+		return channels[0].Status
+	}
+
+	before := status()
+	c.EnsureContainerRunning(Channel{Name: "news", SourceMode: "testpattern", LoopToken: "loop-secret"}, "loop-news")
+	if s := status(); s != "STARTING" {
+		t.Fatalf("before the stream reaches SRS status = %s, want STARTING", s)
+	}
+	live.Store(true)
+	if s := status(); s != "LIVE" {
+		t.Fatalf("once in SRS status = %s, want LIVE", s)
+	}
+
+	// A loop that never reaches SRS stops being STARTING after the grace window
+	c.EnsureContainerRunning(Channel{Name: "news", SourceMode: "testpattern", LoopToken: "loop-secret"}, "loop-news")
+	live.Store(false)
+	c.mu.Lock()
+	delete(c.lastSeenLive, "news")
+	c.startingSince["news"] = time.Now().Add(-2 * time.Minute)
+	c.mu.Unlock()
+	if s := status(); s != before {
+		t.Errorf("after the grace window status = %s, want %s as before the start", s, before)
+	}
+}
+
+func TestUndecryptableLoopTokenIsConfigError(t *testing.T) {
+	keyA := bytes.Repeat([]byte{0xa}, 32)
+	keyB := bytes.Repeat([]byte{0xb}, 32)
+	useKeys(t, keyB, nil)
+	staleEnc, staleIV, err := Encrypt("loop-secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	useKeys(t, keyA, nil)
+	goodEnc, goodIV, err := Encrypt("loop-secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	db := newFakeDB(t, func(query string, args []driver.Value) ([]string, [][]driver.Value, error) {
+		if strings.Contains(query, "FROM channels") {
+			cols, rows := channelRows(
+				map[string]driver.Value{"name": "news", "loop_token": "", "loop_token_encrypted": staleEnc, "loop_token_iv": staleIV},
+				map[string]driver.Value{"id": int64(2), "name": "sports", "loop_token": "", "loop_token_encrypted": goodEnc, "loop_token_iv": goodIV},
+			)
+			return cols, rows, nil
+		}
+		return nil, nil, nil
+	})
+	c := newTestController(&Config{SRSApiURL: fakeSRS(t, "[]").URL, SRSApp: "live"}, db)
+	docker, containers := newFakeContainers(t)
+	c.Docker = docker
+	c.Media = &localMediaStore{dir: t.TempDir()}
+
+	channels, err := c.GetChannels(context.Background())
+	if err != nil || len(channels) != 2 {
+		t.Fatalf("GetChannels = %v, %v", channels, err)
+	}
+	news, sports := channels[0], channels[1]
+	if news.Status != "CONFIG_ERROR" || !strings.Contains(news.ConfigError, "ENCRYPTION_KEY") {
+		t.Errorf("news status = %s, config_error = %q; want CONFIG_ERROR naming ENCRYPTION_KEY", news.Status, news.ConfigError)
+	}
+	if sports.ConfigError != "" || sports.Status == "CONFIG_ERROR" || sports.LoopToken != "loop-secret" {
+		t.Errorf("sports = status %s, config_error %q, token %q; want a decrypted token and no error", sports.Status, sports.ConfigError, sports.LoopToken)
+	}
+
+	c.EnsureContainerRunning(news, "loop-news")
+	if _, ok := containers.container("loop-news"); ok {
+		t.Error("loop started for a channel whose token can't be decrypted")
+	}
+	c.EnsureContainerRunning(sports, "loop-sports")
+	if _, ok := containers.container("loop-sports"); !ok {
+		t.Error("loop not started for a channel with a usable token")
+	}
+}
+
+func TestHTTPServerTimesOutSlowHeaders(t *testing.T) {
+	cfg := &Config{HTTPHeaderTimeout: 100 * time.Millisecond, HTTPReadTimeout: time.Second,
+		HTTPWriteTimeout: 100 * time.Millisecond, HTTPIdleTimeout: time.Second, HTTPMaxHeaderBytes: 1 << 20}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/slow", func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(200 * time.Millisecond)
+		w.Write([]byte("late"))
+	})
+	mux.HandleFunc("/download", func(w http.ResponseWriter, r *http.Request) {
+		clearDeadlines(w)
+		time.Sleep(200 * time.Millisecond)
+		w.Write([]byte("done"))
+	})
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := newHTTPServer(cfg, ln.Addr().String(), mux)
+	go srv.Serve(ln)
+	t.Cleanup(func() { srv.Close() })
+
+	// A client that never finishes its headers is disconnected
+	conn, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.Write([]byte("GET /slow HTTP/1.1\r\nHost: test\r\n"))
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	start := time.Now()
+	if _, err := conn.Read(make([]byte, 1)); err == nil {
+		t.Fatal("server answered a request whose headers never finished")
+	} else if ne, ok := err.(net.Error); ok && ne.Timeout() {
+		t.Fatal("connection with unfinished headers was still open after 2s")
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("slow header sender dropped after %v, want about the 100ms header timeout", elapsed)
+	}
+
+	// A handler that outlives the write timeout loses its response; one that
+	// clears its deadlines, like media downloads, does not
+	base := "http://" + ln.Addr().String()
+	if resp, err := http.Get(base + "/slow"); err == nil {
+		resp.Body.Close()
+		t.Error("response written after the write timeout reached the client")
+	}
+	resp, err := http.Get(base + "/download")
+	if err != nil {
+		t.Fatalf("download with cleared deadlines: %v", err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if string(body) != "done" {
+		t.Errorf("download body = %q, want done", body)
+	}
+}
+
+func TestDisableKicksLiveOBS(t *testing.T) {
+	for _, policy := range []bool{true, false} {
+		t.Run(fmt.Sprintf("policy %v", policy), func(t *testing.T) {
+			var kicked atomic.Value
+			kicked.Store("")
+			srs := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.Method == "DELETE" {
+					kicked.Store(strings.TrimPrefix(r.URL.Path, "/api/v1/clients/"))
+					fmt.Fprint(w, `{"code":0}`)
+					return
+				}
+				fmt.Fprint(w, `{"code":0,"streams":[
+					{"name":"news","app":"live","publish":{"active":true,"cid":"loop1"}},
+					{"name":"news-obs","app":"live","publish":{"active":true,"cid":"obs1"}}]}`)
+			}))
+			defer srs.Close()
+			var audited atomic.Bool
+			db := newFakeDB(t, func(query string, args []driver.Value) ([]string, [][]driver.Value, error) {
+				switch {
+				case strings.Contains(query, "SELECT id, name, display_name, enabled, loop_enabled"):
+					return []string{"id", "name", "display_name", "enabled", "loop_enabled"},
+						[][]driver.Value{{int64(1), "news", "News", true, true}}, nil
+				case strings.Contains(query, "COALESCE(obs_token, '')"):
+					return []string{"obs_token"}, [][]driver.Value{{"obs-secret"}}, nil
+				case strings.Contains(query, "INSERT INTO audit_logs") && args[0] == "OBS_KICKED":
+					audited.Store(true)
+				}
+				return nil, nil, nil
+			})
+			c := newTestController(&Config{SRSApiURL: srs.URL, SRSApp: "live", KickOBSOnDisable: policy}, db)
+			c.Docker = newFakeDocker(t, func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusNoContent)
+			})
+			c.activeSourceMap["news"] = "OBS"
+
+			req := httptest.NewRequest("POST", "/api/channels/1/disable", nil)
+			req = withIdentity(req, Identity{Role: RoleAdmin})
+			rec := httptest.NewRecorder()
+			c.ChannelActionHandler(rec, req)
+			if rec.Code != http.StatusOK {
+				t.Fatalf("disable: status %d (%s)", rec.Code, rec.Body.String())
+			}
+
+			// Only the OBS publisher is kicked, never the loop
+			want := ""
+			if policy {
+				want = "obs1"
+			}
+			if got := kicked.Load().(string); got != want {
+				t.Errorf("kicked client %q, want %q", got, want)
+			}
+			c.mu.RLock()
+			_, tracked := c.activeSourceMap["news"]
+			c.mu.RUnlock()
+			if audited.Load() != policy || tracked == policy {
+				t.Errorf("kick audited %v, active source still tracked %v; want %v and %v", audited.Load(), tracked, policy, !policy)
+			}
+		})
+	}
+}
+
+func TestComposeEmailHeaders(t *testing.T) {
+	date := time.Date(2026, 3, 1, 9, 30, 0, 0, time.UTC)
+	msg := string(composeEmail("alerts@example.com", []string{"ops@example.com", "oncall@example.com"},
+		"Channel news is DOWN", "Line one\nLine two\r\n", date))
+
+	head, body, ok := strings.Cut(msg, "\r\n\r\n")
+	if !ok {
+		t.Fatalf("no blank line between headers and body in %q", msg)
+	}
+	want := []string{
+		"From: alerts@example.com",
+		"To: ops@example.com, oncall@example.com",
+		"Subject: Channel news is DOWN",
+		"Date: Sun, 01 Mar 2026 09:30:00 +0000",
+		"MIME-Version: 1.0",
+		"Content-Type: text/plain; charset=UTF-8",
+	}
+	if got := strings.Split(head, "\r\n"); fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("headers =\n%q\nwant\n%q", got, want)
+	}
+	if body != "Line one\r\nLine two\r\n" {
+		t.Errorf("body = %q, want CRLF line endings", body)
+	}
+
+	// Non-ASCII subjects are encoded, and CR/LF can't smuggle in headers
+	msg = string(composeEmail("alerts@example.com", []string{"ops@example.com\r\nBcc: evil@example.com"},
+		"Café\r\nBcc: evil@example.com", "", date))
+	head, _, _ = strings.Cut(msg, "\r\n\r\n")
+	for _, line := range strings.Split(head, "\r\n") {
+		if strings.HasPrefix(line, "Bcc:") {
+			t.Errorf("injected header line %q", line)
+		}
+	}
+	if !strings.Contains(head, "Subject: =?utf-8?q?Caf=C3=A9Bcc:_evil@example.com?=") {
+		t.Errorf("subject not Q-encoded on one line: %q", head)
+	}
+}
+
+// smtpSession is what the mock SMTP server saw of one delivery.
+type smtpSession struct {
+	authOverTLS bool // AUTH arrived on an encrypted connection
+	authed      bool
+	data        string
+}
+
+// mockSMTP serves one SMTP session on a local port, offering STARTTLS or, with
+// implicit, speaking TLS from the start. It uses httptest's self-signed
+// certificate, so clients need SMTP_TLS_SKIP_VERIFY.
+func mockSMTP(t *testing.T, implicit bool) (port string, sessions <-chan smtpSession) {
+	t.Helper()
+	certSrv := httptest.NewTLSServer(http.NotFoundHandler())
+	tlsConfig := &tls.Config{Certificates: certSrv.TLS.Certificates}
+	certSrv.Close()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	out := make(chan smtpSession, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		encrypted := implicit
+		if implicit {
+			conn = tls.Server(conn, tlsConfig)
+		}
+		r := bufio.NewReader(conn)
+		var s smtpSession
+		fmt.Fprint(conn, "220 mock ESMTP\r\n")
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				out <- s
+				return
+			}
+			cmd, _, _ := strings.Cut(strings.TrimSpace(line), " ")
+			switch strings.ToUpper(cmd) {
+			case "EHLO":
+				if !encrypted {
+					fmt.Fprint(conn, "250-mock\r\n250-STARTTLS\r\n")
+				} else {
+					fmt.Fprint(conn, "250-mock\r\n")
+				}
+				fmt.Fprint(conn, "250 AUTH PLAIN\r\n")
+			case "STARTTLS":
+				fmt.Fprint(conn, "220 go ahead\r\n")
+				conn = tls.Server(conn, tlsConfig)
+				r = bufio.NewReader(conn)
+				encrypted = true
+			case "AUTH":
+				s.authed, s.authOverTLS = true, encrypted
+				fmt.Fprint(conn, "235 ok\r\n")
+			case "DATA":
+				fmt.Fprint(conn, "354 send it\r\n")
+				var data strings.Builder
+				for {
+					l, err := r.ReadString('\n')
+					if err != nil || l == ".\r\n" {
+						break
+					}
+					data.WriteString(l)
+				}
+				s.data = data.String()
+				fmt.Fprint(conn, "250 queued\r\n")
+			case "QUIT":
+				fmt.Fprint(conn, "221 bye\r\n")
+				out <- s
+				return
+			default:
+				fmt.Fprint(conn, "250 ok\r\n")
+			}
+		}
+	}()
+	_, port, _ = net.SplitHostPort(ln.Addr().String())
+	return port, out
+}
+
+func TestSendEmailTLSModes(t *testing.T) {
+	for _, mode := range []string{"starttls", "tls"} {
+		t.Run(mode, func(t *testing.T) {
+			port, sessions := mockSMTP(t, mode == "tls")
+			t.Setenv("SMTP_HOST", "127.0.0.1")
+			t.Setenv("SMTP_PORT", port)
+			t.Setenv("SMTP_USER", "mailer@example.com")
+			t.Setenv("SMTP_PASS", "smtp-secret")
+			t.Setenv("SMTP_FROM", "")
+			t.Setenv("SMTP_TLS_MODE", mode)
+			t.Setenv("SMTP_TLS_SKIP_VERIFY", "true")
+
+			if err := sendEmail([]string{"ops@example.com"}, "SMTP test", "It works"); err != nil {
+				t.Fatalf("sendEmail: %v", err)
+			}
+			s := <-sessions
+			if !s.authed || !s.authOverTLS {
+				t.Errorf("authenticated %v, over TLS %v; credentials must only go over TLS", s.authed, s.authOverTLS)
+			}
+			if !strings.Contains(s.data, "From: mailer@example.com\r\n") || !strings.Contains(s.data, "It works") {
+				t.Errorf("delivered message = %q", s.data)
+			}
+		})
+	}
+
+	// The self-signed certificate is rejected unless verification is skipped
+	port, _ := mockSMTP(t, true)
+	t.Setenv("SMTP_PORT", port)
+	t.Setenv("SMTP_TLS_MODE", "tls")
+	t.Setenv("SMTP_TLS_SKIP_VERIFY", "false")
+	if err := sendEmail([]string{"ops@example.com"}, "SMTP test", "It works"); err == nil {
+		t.Error("sendEmail trusted an unverified certificate")
+	}
+}
+
+func TestOrphanStreamsOnlyUnowned(t *testing.T) {
+	c := newTestController(&Config{SRSApp: "live"}, nil)
+	var list []SRSStream
+	if err := json.Unmarshal([]byte(`[
+		{"name":"news","app":"live"},
+		{"name":"news-obs","app":"live"},
+		{"name":"obs-secret","app":"live"},
+		{"name":"news_relay_clean","app":"live"},
+		{"name":"news_abr_720p","app":"live"},
+		{"name":"news","app":"rtc"},
+		{"name":"sports","app":"live","clients":3,"live_ms":5000,"kbps":{"recv_30s":6000},"publish":{"active":true,"cid":"rogue1"}},
+		{"name":"news_abr_720p","app":"other"}
+	]`), &list); err != nil {
+		t.Fatal(err)
+	}
+	streams := map[string]SRSStream{}
+	for _, s := range list {
+		streams[c.srsStreamKey(s.App, s.Name)] = s
+	}
+	channels := []Channel{{Name: "news", OBSToken: "obs-secret"}}
+
+	got := c.orphanStreams(streams, channels)
+	want := []OrphanStream{
+		{Key: "other/news_abr_720p", App: "other", Stream: "news_abr_720p"},
+		{Key: "sports", App: "live", Stream: "sports", Clients: 3, Kbps: 6000, LiveMs: 5000, ClientID: "rogue1"},
+	}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("orphans =\n%+v\nwant\n%+v", got, want)
+	}
+
+	// Once sports is configured it is no longer an orphan
+	channels = append(channels, Channel{Name: "sports"})
+	if got := c.orphanStreams(streams, channels); len(got) != 1 || got[0].Key != "other/news_abr_720p" {
+		t.Errorf("orphans with sports configured = %+v", got)
+	}
+}
+
+func TestOBSFlapsHeldByDwell(t *testing.T) {
+	var mu sync.Mutex
+	var switches []string
+	db := newFakeDB(t, func(query string, args []driver.Value) ([]string, [][]driver.Value, error) {
+		switch {
+		case strings.Contains(query, "SELECT obs_token FROM channels"):
+			return []string{"obs_token"}, [][]driver.Value{{"obs-secret"}}, nil
+		case strings.Contains(query, "FROM channels WHERE name"):
+			return []string{"id", "name", "obs_token_hash", "loop_token_hash", "obs_token", "loop_token", "obs_override_enabled"},
+				[][]driver.Value{{int64(7), "news", HashToken("obs-secret"), HashToken("loop-secret"), "", "", true}}, nil
+		case strings.Contains(query, "FROM channel_ip_allowlist"):
+			return []string{"id", "channel_id", "cidr", "description", "created_at"}, nil, nil
+		case strings.Contains(query, "current_active_source = 'OBS'"):
+			mu.Lock()
+			switches = append(switches, "OBS")
+			mu.Unlock()
+		case strings.Contains(query, "current_active_source = 'LOOP'"):
+			mu.Lock()
+			switches = append(switches, "LOOP")
+			mu.Unlock()
+		}
+		return nil, nil, nil
+	})
+	c := newTestController(&Config{SRSApp: "live", SRSApiURL: "http://127.0.0.1:1", CheckInterval: time.Minute, SourceDwell: time.Minute}, db)
+	c.srsSnapshot = map[string]SRSStream{}
+	c.srsSnapshotAt = time.Now()
+	c.Docker = newFakeDocker(t, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	hook := func(h http.HandlerFunc, action string) {
+		t.Helper()
+		body := fmt.Sprintf(`{"action":%q,"app":"live","stream":"news-obs","param":"?token=obs-secret","ip":"203.0.113.9","client_id":"c1"}`, action)
+		rec := httptest.NewRecorder()
+		h(rec, httptest.NewRequest("POST", "/api/hooks/"+action, strings.NewReader(body)))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("%s: status %d (%s)", action, rec.Code, rec.Body.String())
+		}
+	}
+	// Pretend the last switch happened longer ago than the dwell time
+	dwellPassed := func() {
+		c.mu.Lock()
+		c.sourceSwitchedAt["news"] = time.Now().Add(-2 * time.Minute)
+		c.mu.Unlock()
+	}
+	got := func() string {
+		mu.Lock()
+		defer mu.Unlock()
+		return strings.Join(switches, " ")
+	}
+
+	hook(c.OnPublishHandler, "on_publish")
+	dwellPassed()
+	hook(c.OnUnpublishHandler, "on_unpublish")
+	if s := got(); s != "OBS LOOP" {
+		t.Fatalf("switches after a settled connect and drop = %q, want OBS LOOP", s)
+	}
+
+	// OBS reconnects right after the fallback, twice: the loop holds
+	hook(c.OnPublishHandler, "on_publish")
+	hook(c.OnPublishHandler, "on_publish")
+	if s := got(); s != "OBS LOOP" {
+		t.Errorf("switches while flapping within the dwell time = %q, want no more", s)
+	}
+
+	// Once the dwell time is up the next connect switches again
+	dwellPassed()
+	hook(c.OnPublishHandler, "on_publish")
+	if s := got(); s != "OBS LOOP OBS" {
+		t.Errorf("switches after the dwell time = %q, want OBS LOOP OBS", s)
+	}
+}
+
+func TestOBSPrimaryWaitsOnFirstReconcile(t *testing.T) {
+	db := newFakeDB(t, func(query string, args []driver.Value) ([]string, [][]driver.Value, error) {
+		if strings.Contains(query, "FROM channels") && strings.Contains(query, "primary_source") {
+			cols, rows := channelRows(map[string]driver.Value{"primary_source": "obs"})
+			return cols, rows, nil
+		}
+		return nil, nil, nil
+	})
+	c := newTestController(&Config{SRSApiURL: fakeSRS(t, "[]").URL, SRSApp: "live", CheckInterval: time.Minute}, db)
+	docker, containers := newFakeContainers(t)
+	c.Docker = docker
+	c.Media = &localMediaStore{dir: t.TempDir()}
+
+	news := Channel{Name: "news", Enabled: true, LoopEnabled: true, SourceMode: "testpattern", LoopToken: "loop-secret", PrimarySource: "obs"}
+	sports := Channel{Name: "sports", Enabled: true, LoopEnabled: true, SourceMode: "testpattern", LoopToken: "loop-secret", PrimarySource: "loop"}
+	c.ReconcileChannel(news, map[string]SRSStream{})
+	c.ReconcileChannel(sports, map[string]SRSStream{})
+	if _, ok := containers.container("loop-news"); ok {
+		t.Error("OBS-primary channel started its loop before OBS published")
+	}
+	if _, ok := containers.container("loop-sports"); !ok {
+		t.Error("loop-primary channel did not start its loop")
+	}
+	channels, err := c.GetChannels(context.Background())
+	if err != nil || len(channels) != 1 {
+		t.Fatalf("GetChannels = %v, %v", channels, err)
+	}
+	if channels[0].Status != "WAITING_FOR_OBS" {
+		t.Errorf("status = %s, want WAITING_FOR_OBS", channels[0].Status)
+	}
+
+	// Once OBS has published, the loop runs as its fallback
+	var obs SRSStream
+	obs.Name, obs.App = "news-obs", "live"
+	obs.Publish.Active = true
+	obs.Kbps.Recv = 2500
+	c.ReconcileChannel(news, map[string]SRSStream{"news-obs": obs})
+	if _, ok := containers.container("loop-news"); !ok {
+		t.Error("loop not started after OBS published")
+	}
+	if c.waitingForOBS(news) {
+		t.Error("still waiting for OBS after it published")
+	}
+}
+
+func TestValidateBitratesBoundaries(t *testing.T) {
+	c := newTestController(&Config{MaxVideoBitrate: 8000, MaxAudioBitrate: 256}, nil)
+	tests := []struct {
+		video, audio int
+		wantErr      string
+	}{
+		{0, 0, ""}, // Defaults
+		{300, 32, ""},
+		{8000, 256, ""},
+		{4500, 128, ""},
+		{299, 128, "video_bitrate"},
+		{8001, 128, "video_bitrate"},
+		{-1, 128, "video_bitrate"},
+		{4500, 31, "audio_bitrate"},
+		{4500, 257, "audio_bitrate"},
+		{4500, -128, "audio_bitrate"},
+	}
+	for _, tt := range tests {
+		err := c.validateBitrates(tt.video, tt.audio)
+		if tt.wantErr == "" && err != nil {
+			t.Errorf("%d/%d kbps: unexpected error %v", tt.video, tt.audio, err)
+		}
+		if tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+			t.Errorf("%d/%d kbps: error %v, want one about %s", tt.video, tt.audio, err, tt.wantErr)
+		}
+	}
+
+	// The channel update rejects it with a 400 before touching the database
+	var updated atomic.Bool
+	c.DB = newFakeDB(t, func(query string, args []driver.Value) ([]string, [][]driver.Value, error) {
+		switch {
+		case strings.Contains(query, "SELECT id, name, display_name, enabled, loop_enabled"):
+			return []string{"id", "name", "display_name", "enabled", "loop_enabled"},
+				[][]driver.Value{{int64(1), "news", "News", true, true}}, nil
+		case strings.Contains(query, "UPDATE channels"):
+			updated.Store(true)
+		}
+		return nil, nil, nil
+	})
+	req := httptest.NewRequest("PUT", "/api/channels/1", strings.NewReader(`{"display_name":"News","source_mode":"file","loop_source_file":"intro.mp4","video_bitrate":50000}`))
+	req = withIdentity(req, Identity{Role: RoleAdmin})
+	rec := httptest.NewRecorder()
+	c.ChannelActionHandler(rec, req)
+	if rec.Code != http.StatusBadRequest || !strings.Contains(rec.Body.String(), "video_bitrate must be between 300 and 8000") {
+		t.Errorf("PUT 50000k: status %d (%s), want 400 naming the range", rec.Code, strings.TrimSpace(rec.Body.String()))
+	}
+	if updated.Load() {
+		t.Error("rejected bitrate was written to the channel")
+	}
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"time"
+)
+
+// checkMediaAccess verifies that dir is a directory the controller can list
+// and write to: loops read from it, and uploads, S3 syncs and the optimizer
+// write to it.
+func checkMediaAccess(dir string) error {
+	info, err := os.Stat(dir)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("%s is not a directory", dir)
+	}
+	if _, err := os.ReadDir(dir); err != nil {
+		return fmt.Errorf("not readable: %v", err)
+	}
+	probe, err := os.CreateTemp(dir, ".access-check-*")
+	if err != nil {
+		return fmt.Errorf("not writable: %v", err)
+	}
+	probe.Close()
+	os.Remove(probe.Name())
+	return nil
+}
+
+// checkMediaStorage re-checks MEDIA_PATH and records the result, logging only
+// when access is lost or comes back.
+func (c *Controller) checkMediaStorage() {
+	err := checkMediaAccess(c.Config.MediaPath)
+
+	c.mu.Lock()
+	prev := c.mediaErr
+	c.mediaErr = err
+	c.mu.Unlock()
+
+	switch {
+	case err != nil && prev == nil:
+		action := "loop starts are held until it recovers"
+		if !c.Config.MediaHoldLoops {
+			action = "MEDIA_UNAVAILABLE_HOLDS_LOOPS is off, loops are still started"
+		}
+		c.Log("error", "media", fmt.Sprintf("Media storage %s is unavailable (%v); %s", c.Config.MediaPath, err, action))
+	case err == nil && prev != nil:
+		c.Log("info", "media", fmt.Sprintf("Media storage %s is available again", c.Config.MediaPath))
+	}
+}
+
+// StartMediaStorageMonitor checks MEDIA_PATH at startup and then every
+// MEDIA_CHECK_INTERVAL_SECONDS.
+func (c *Controller) StartMediaStorageMonitor() {
+	log.Printf("Starting Media Storage Monitor (every %s)...", c.Config.MediaCheckInterval)
+	c.checkMediaStorage()
+	ticker := time.NewTicker(c.Config.MediaCheckInterval)
+	go func() {
+		for range ticker.C {
+			c.checkMediaStorage()
+		}
+	}()
+}
+
+// mediaStorageErr is the last MEDIA_PATH access failure, or nil.
+func (c *Controller) mediaStorageErr() error {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.mediaErr
+}
+
+// mediaHeld reports whether a channel's loop must not be started because it
+// plays files from media storage that is currently inaccessible. A loop left
+// to start would only exit and count towards CRASH_LOOP.
+func (c *Controller) mediaHeld(ch Channel) bool {
+	if !c.Config.MediaHoldLoops || ch.SourceMode == "testpattern" {
+		return false
+	}
+	return c.mediaStorageErr() != nil
+}
+
+// mediaStorageHealth reports on MEDIA_PATH for ServicesHealthHandler.
+func (c *Controller) mediaStorageHealth() ServiceHealth {
+	health := ServiceHealth{
+		Name:      "Media Storage",
+		Status:    "healthy",
+		Uptime:    "-",
+		LastCheck: time.Now().Format("15:04:05"),
+	}
+	start := time.Now()
+	err := checkMediaAccess(c.Config.MediaPath)
+	health.Latency = time.Since(start).Milliseconds()
+	if err != nil {
+		health.Status = "down"
+		health.Details = fmt.Sprintf("%s: %v", c.Config.MediaPath, err)
+		return health
+	}
+	health.Details = fmt.Sprintf("%s readable and writable", c.Config.MediaPath)
+	if free, err := freeDiskBytes(c.Config.MediaPath); err == nil {
+		health.Details += fmt.Sprintf(", %.1f GB free", float64(free)/(1<<30))
+	}
+	return health
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"database/sql/driver"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestUnreadableMediaHoldsLoops(t *testing.T) {
+	// Permission bits don't stop root, so stand in a file for the directory
+	mediaPath := filepath.Join(t.TempDir(), "media")
+	if err := os.WriteFile(mediaPath, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	db := newFakeDB(t, func(query string, args []driver.Value) ([]string, [][]driver.Value, error) {
+		if strings.Contains(query, "FROM channels") {
+			cols, rows := channelRows(map[string]driver.Value{})
+			return cols, rows, nil
+		}
+		return nil, nil, nil
+	})
+	c := newTestController(&Config{MediaPath: mediaPath, MediaHoldLoops: true, SRSApiURL: fakeSRS(t, "[]").URL, SRSApp: "live"}, db)
+	docker, containers := newFakeContainers(t)
+	c.Docker = docker
+	c.Media = &localMediaStore{dir: t.TempDir()}
+
+	c.checkMediaStorage()
+	if c.mediaStorageErr() == nil {
+		t.Fatal("media path that isn't a directory passed the access check")
+	}
+	c.EnsureContainerRunning(Channel{Name: "news", SourceMode: "file", LoopSourceFile: "intro.mp4", LoopToken: "loop-secret"}, "loop-news")
+	if _, ok := containers.container("loop-news"); ok {
+		t.Error("file loop started while media storage is unavailable")
+	}
+	c.EnsureContainerRunning(Channel{Name: "bars", SourceMode: "testpattern", LoopToken: "loop-secret"}, "loop-bars")
+	if _, ok := containers.container("loop-bars"); !ok {
+		t.Error("test pattern loop, which needs no media, was held")
+	}
+	channels, err := c.GetChannels(context.Background())
+	if err != nil || len(channels) != 1 {
+		t.Fatalf("GetChannels = %v, %v", channels, err)
+	}
+	if channels[0].Status != "STORAGE_UNAVAILABLE" {
+		t.Errorf("status = %s, want STORAGE_UNAVAILABLE", channels[0].Status)
+	}
+	if h := c.mediaStorageHealth(); h.Name != "Media Storage" || h.Status != "down" || !strings.Contains(h.Details, "not a directory") {
+		t.Errorf("health = %+v, want Media Storage down", h)
+	}
+
+	// Once the directory is usable again the next check releases the hold
+	os.Remove(mediaPath)
+	if err := os.Mkdir(mediaPath, 0755); err != nil {
+		t.Fatal(err)
+	}
+	c.checkMediaStorage()
+	if err := c.mediaStorageErr(); err != nil {
+		t.Fatalf("usable media dir failed the access check: %v", err)
+	}
+	c.EnsureContainerRunning(Channel{Name: "news", SourceMode: "file", LoopSourceFile: "intro.mp4", LoopToken: "loop-secret"}, "loop-news")
+	if _, ok := containers.container("loop-news"); !ok {
+		t.Error("file loop not started after media storage recovered")
+	}
+	if h := c.mediaStorageHealth(); h.Status != "healthy" {
+		t.Errorf("health after recovery = %+v, want healthy", h)
+	}
+}
+
+func TestUnreadableMediaHoldOff(t *testing.T) {
+	c := newTestController(&Config{MediaPath: filepath.Join(t.TempDir(), "missing")}, nil)
+	docker, containers := newFakeContainers(t)
+	c.Docker = docker
+	c.Media = &localMediaStore{dir: t.TempDir()}
+
+	c.checkMediaStorage()
+	if c.mediaStorageErr() == nil {
+		t.Fatal("missing media path passed the access check")
+	}
+	c.EnsureContainerRunning(Channel{Name: "news", SourceMode: "file", LoopSourceFile: "intro.mp4", LoopToken: "loop-secret"}, "loop-news")
+	if _, ok := containers.container("loop-news"); !ok {
+		t.Error("loop held although MEDIA_UNAVAILABLE_HOLDS_LOOPS is off")
+	}
+}
@@ -0,0 +1,466 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// MediaInfo describes one stored media object.
+type MediaInfo struct {
+	Name    string
+	Size    int64
+	ModTime time.Time
+}
+
+// MediaStore is where uploaded media lives. Missing objects are reported as
+// fs.ErrNotExist by every backend.
+type MediaStore interface {
+	List() ([]MediaInfo, error)
+	Open(name string) (io.ReadCloser, error)
+	Create(name string) (io.WriteCloser, error)
+	Delete(name string) error
+	Stat(name string) (MediaInfo, error)
+}
+
+// NewMediaStore picks the backend from MEDIA_BACKEND.
+func NewMediaStore(cfg *Config) (MediaStore, error) {
+	switch cfg.MediaBackend {
+	case "", "local":
+		return &localMediaStore{dir: cfg.MediaPath}, nil
+	case "s3":
+		if cfg.S3Bucket == "" || cfg.S3AccessKey == "" || cfg.S3SecretKey == "" {
+			return nil, fmt.Errorf("MEDIA_BACKEND=s3 requires S3_BUCKET, S3_ACCESS_KEY_ID and S3_SECRET_ACCESS_KEY")
+		}
+		return &s3MediaStore{
+			bucket:    cfg.S3Bucket,
+			region:    cfg.S3Region,
+			endpoint:  strings.TrimSuffix(cfg.S3Endpoint, "/"),
+			prefix:    cfg.S3Prefix,
+			accessKey: cfg.S3AccessKey,
+			secretKey: cfg.S3SecretKey,
+			client:    &http.Client{Timeout: 30 * time.Minute},
+		}, nil
+	default:
+		return nil, fmt.Errorf("unknown MEDIA_BACKEND %q (expected local or s3)", cfg.MediaBackend)
+	}
+}
+
+// ========================================
+// Local disk
+// ========================================
+
+type localMediaStore struct {
+	dir string
+}
+
+func (s *localMediaStore) List() ([]MediaInfo, error) {
+	if err := os.MkdirAll(s.dir, 0755); err != nil {
+		return nil, err
+	}
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return nil, err
+	}
+	var files []MediaInfo
+	for _, e := range entries {
+		if e.IsDir() {
+			continue
+		}
+		info, err := e.Info()
+		if err != nil {
+			continue
+		}
+		files = append(files, MediaInfo{Name: e.Name(), Size: info.Size(), ModTime: info.ModTime()})
+	}
+	return files, nil
+}
+
+func (s *localMediaStore) Open(name string) (io.ReadCloser, error) {
+	return os.Open(filepath.Join(s.dir, name))
+}
+
+func (s *localMediaStore) Create(name string) (io.WriteCloser, error) {
+	if err := os.MkdirAll(s.dir, 0755); err != nil {
+		return nil, err
+	}
+	return os.Create(filepath.Join(s.dir, name))
+}
+
+func (s *localMediaStore) Delete(name string) error {
+	return os.Remove(filepath.Join(s.dir, name))
+}
+
+func (s *localMediaStore) Stat(name string) (MediaInfo, error) {
+	info, err := os.Stat(filepath.Join(s.dir, name))
+	if err != nil {
+		return MediaInfo{}, err
+	}
+	return MediaInfo{Name: name, Size: info.Size(), ModTime: info.ModTime()}, nil
+}
+
+// ========================================
+// S3 (SigV4 over plain HTTP, no SDK)
+// ========================================
+
+// s3MediaStore keeps media in an S3-compatible bucket. Loop containers still
+// read from the MEDIA_HOST_PATH bind mount, so syncMediaLocally copies a file
+// down before its loop starts.
+type s3MediaStore struct {
+	bucket    string
+	region    string
+	endpoint  string // Empty for AWS; set for MinIO and other S3-compatible stores
+	prefix    string
+	accessKey string
+	secretKey string
+	client    *http.Client
+}
+
+func (s *s3MediaStore) baseURL() string {
+	if s.endpoint != "" {
+		return s.endpoint + "/" + s.bucket
+	}
+	return fmt.Sprintf("https://%s.s3.%s.amazonaws.com", s.bucket, s.region)
+}
+
+func (s *s3MediaStore) do(method, key string, query url.Values, body io.Reader, size int64) (*http.Response, error) {
+	u, err := url.Parse(s.baseURL() + "/" + s3Escape(s.prefix+key, true))
+	if err != nil {
+		return nil, err
+	}
+	u.RawQuery = s3CanonicalQuery(query)
+
+	req, err := http.NewRequest(method, u.String(), body)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.ContentLength = size
+	}
+	s.sign(req)
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode == http.StatusNotFound {
+		resp.Body.Close()
+		return nil, fs.ErrNotExist
+	}
+	if resp.StatusCode >= 300 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		resp.Body.Close()
+		return nil, fmt.Errorf("s3 %s %s: %s: %s", method, key, resp.Status, strings.TrimSpace(string(msg)))
+	}
+	return resp, nil
+}
+
+// sign adds an AWS Signature Version 4 Authorization header. Payloads are sent
+// unsigned so uploads can stream without hashing the whole file first.
+func (s *s3MediaStore) sign(req *http.Request) {
+	now := time.Now().UTC()
+	amzDate := now.Format("20060102T150405Z")
+	day := now.Format("20060102")
+
+	req.Header.Set("x-amz-date", amzDate)
+	req.Header.Set("x-amz-content-sha256", "UNSIGNED-PAYLOAD")
+
+	signedHeaders := "host;x-amz-content-sha256;x-amz-date"
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		req.URL.RawQuery,
+		"host:" + req.URL.Host,
+		"x-amz-content-sha256:UNSIGNED-PAYLOAD",
+		"x-amz-date:" + amzDate,
+		"",
+		signedHeaders,
+		"UNSIGNED-PAYLOAD",
+	}, "\n")
+
+	scope := day + "/" + s.region + "/s3/aws4_request"
+	crHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(crHash[:])
+
+	key := hmacSHA256([]byte("AWS4"+s.secretKey), day)
+	key = hmacSHA256(key, s.region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		s.accessKey, scope, signedHeaders, signature))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+// s3Escape URI-encodes per the SigV4 rules (RFC 3986 unreserved characters
+// only), optionally leaving '/' intact for object keys.
+func s3Escape(v string, keepSlash bool) string {
+	var b strings.Builder
+	for i := 0; i < len(v); i++ {
+		ch := v[i]
+		if (ch >= 'A' && ch <= 'Z') || (ch >= 'a' && ch <= 'z') || (ch >= '0' && ch <= '9') ||
+			ch == '-' || ch == '_' || ch == '.' || ch == '~' || (keepSlash && ch == '/') {
+			b.WriteByte(ch)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", ch)
+		}
+	}
+	return b.String()
+}
+
+func s3CanonicalQuery(q url.Values) string {
+	keys := make([]string, 0, len(q))
+	for k := range q {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var parts []string
+	for _, k := range keys {
+		for _, v := range q[k] {
+			parts = append(parts, s3Escape(k, false)+"="+s3Escape(v, false))
+		}
+	}
+	return strings.Join(parts, "&")
+}
+
+type s3ListResult struct {
+	Contents []struct {
+		Key          string    `xml:"Key"`
+		Size         int64     `xml:"Size"`
+		LastModified time.Time `xml:"LastModified"`
+	} `xml:"Contents"`
+	IsTruncated           bool   `xml:"IsTruncated"`
+	NextContinuationToken string `xml:"NextContinuationToken"`
+}
+
+func (s *s3MediaStore) List() ([]MediaInfo, error) {
+	var files []MediaInfo
+	token := ""
+	for {
+		q := url.Values{"list-type": {"2"}}
+		if s.prefix != "" {
+			q.Set("prefix", s.prefix)
+		}
+		if token != "" {
+			q.Set("continuation-token", token)
+		}
+		resp, err := s.do("GET", "", q, nil, 0)
+		if err != nil {
+			return nil, err
+		}
+		var result s3ListResult
+		err = xml.NewDecoder(resp.Body).Decode(&result)
+		resp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		for _, obj := range result.Contents {
+			name := strings.TrimPrefix(obj.Key, s.prefix)
+			if name == "" || strings.Contains(name, "/") {
+				continue
+			}
+			files = append(files, MediaInfo{Name: name, Size: obj.Size, ModTime: obj.LastModified})
+		}
+		if !result.IsTruncated || result.NextContinuationToken == "" {
+			return files, nil
+		}
+		token = result.NextContinuationToken
+	}
+}
+
+func (s *s3MediaStore) Open(name string) (io.ReadCloser, error) {
+	resp, err := s.do("GET", name, nil, nil, 0)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+// Create spools the upload to a temp file so the PUT can carry a
+// Content-Length; the object is written to S3 on Close.
+func (s *s3MediaStore) Create(name string) (io.WriteCloser, error) {
+	f, err := os.CreateTemp("", "media-upload-*")
+	if err != nil {
+		return nil, err
+	}
+	return &s3Upload{File: f, store: s, name: name}, nil
+}
+
+type s3Upload struct {
+	*os.File
+	store *s3MediaStore
+	name  string
+}
+
+func (u *s3Upload) Close() error {
+	defer os.Remove(u.File.Name())
+	defer u.File.Close()
+
+	size, err := u.File.Seek(0, io.SeekEnd)
+	if err != nil {
+		return err
+	}
+	if _, err := u.File.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	resp, err := u.store.do("PUT", u.name, nil, u.File, size)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+func (s *s3MediaStore) Delete(name string) error {
+	// S3 DELETE succeeds for missing keys, so check first to keep 404 semantics
+	if _, err := s.Stat(name); err != nil {
+		return err
+	}
+	resp, err := s.do("DELETE", name, nil, nil, 0)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+func (s *s3MediaStore) Stat(name string) (MediaInfo, error) {
+	resp, err := s.do("HEAD", name, nil, nil, 0)
+	if err != nil {
+		return MediaInfo{}, err
+	}
+	resp.Body.Close()
+	info := MediaInfo{Name: name}
+	info.Size, _ = strconv.ParseInt(resp.Header.Get("Content-Length"), 10, 64)
+	info.ModTime, _ = http.ParseTime(resp.Header.Get("Last-Modified"))
+	return info, nil
+}
+
+// mediaSync is the state of the local copy of one remote media file, kept in
+// Controller.mediaSyncs (guarded by mu).
+type mediaSync struct {
+	ready    bool
+	checked  time.Time
+	inFlight bool
+	waiters  map[string]bool // Channels whose loop is waiting for the file
+}
+
+// mediaReady reports whether name is on disk under MediaPath for channel's
+// loop. The copy is (re)synced in the background at most once a reconcile
+// interval so a large download can't stall the reconcile; when it lands the
+// reconciler is woken for every channel that asked for it. Always true for the
+// local backend.
+func (c *Controller) mediaReady(channel, name string) bool {
+	if _, ok := c.Media.(*localMediaStore); ok || name == "" {
+		return true
+	}
+	_, statErr := os.Stat(filepath.Join(c.Config.MediaPath, name))
+
+	c.mu.Lock()
+	s := c.mediaSyncs[name]
+	if s == nil {
+		s = &mediaSync{}
+		c.mediaSyncs[name] = s
+	}
+	if statErr != nil {
+		s.ready = false // Deleted since the last sync
+	}
+	ready := s.ready
+	if !ready {
+		if s.waiters == nil {
+			s.waiters = make(map[string]bool)
+		}
+		s.waiters[channel] = true
+	}
+	start := !s.inFlight && time.Since(s.checked) >= c.Config.CheckInterval
+	if start {
+		s.inFlight = true
+	}
+	c.mu.Unlock()
+
+	if start {
+		go c.runMediaSync(name, s)
+	}
+	return ready
+}
+
+// runMediaSync syncs name and records the result.
+func (c *Controller) runMediaSync(name string, s *mediaSync) {
+	err := c.syncMediaLocally(name)
+	if err != nil {
+		c.Log("error", "media", fmt.Sprintf("Failed to sync %s from media store: %v", name, err))
+	}
+
+	c.mu.Lock()
+	s.ready, s.checked, s.inFlight = err == nil, time.Now(), false
+	var waiters []string
+	if s.ready {
+		for ch := range s.waiters {
+			waiters = append(waiters, ch)
+		}
+		s.waiters = nil
+	}
+	c.mu.Unlock()
+	for _, ch := range waiters {
+		c.requestReconcile(ch)
+	}
+}
+
+// syncMediaLocally makes sure name exists under MediaPath so the loop
+// container's bind mount can read it. It is a no-op for the local backend.
+func (c *Controller) syncMediaLocally(name string) error {
+	if _, ok := c.Media.(*localMediaStore); ok || name == "" {
+		return nil
+	}
+	dst := filepath.Join(c.Config.MediaPath, name)
+	remote, err := c.Media.Stat(name)
+	if err != nil {
+		return err
+	}
+	if local, err := os.Stat(dst); err == nil && local.Size() == remote.Size {
+		return nil
+	}
+
+	src, err := c.Media.Open(name)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	if err := os.MkdirAll(c.Config.MediaPath, 0755); err != nil {
+		return err
+	}
+	tmp := dst + ".sync.temp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(f, src); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, dst)
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+// memMediaStore is a remote-style MediaStore kept in memory. Open blocks until
+// release is closed, standing in for a slow download.
+type memMediaStore struct {
+	mu      sync.Mutex
+	files   map[string][]byte
+	release chan struct{}
+}
+
+func (m *memMediaStore) List() ([]MediaInfo, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var out []MediaInfo
+	for name, b := range m.files {
+		out = append(out, MediaInfo{Name: name, Size: int64(len(b))})
+	}
+	return out, nil
+}
+
+func (m *memMediaStore) Open(name string) (io.ReadCloser, error) {
+	<-m.release
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	b, ok := m.files[name]
+	if !ok {
+		return nil, fs.ErrNotExist
+	}
+	return io.NopCloser(bytes.NewReader(b)), nil
+}
+
+func (m *memMediaStore) Create(name string) (io.WriteCloser, error) { return nil, fs.ErrPermission }
+func (m *memMediaStore) Delete(name string) error                   { return fs.ErrPermission }
+
+func (m *memMediaStore) Stat(name string) (MediaInfo, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	b, ok := m.files[name]
+	if !ok {
+		return MediaInfo{}, fs.ErrNotExist
+	}
+	return MediaInfo{Name: name, Size: int64(len(b))}, nil
+}
+
+func TestMediaReadySyncsInBackground(t *testing.T) {
+	dir := t.TempDir()
+	store := &memMediaStore{files: map[string][]byte{"intro.mp4": []byte("video")}, release: make(chan struct{})}
+	c := &Controller{
+		Config:        &Config{MediaPath: dir, CheckInterval: time.Hour},
+		Media:         store,
+		mediaSyncs:    make(map[string]*mediaSync),
+		reconcileWake: make(chan struct{}, 1),
+		wakeChannels:  make(map[string]bool),
+	}
+
+	// The download is blocked, so the reconcile path must not wait for it
+	done := make(chan bool)
+	go func() { done <- c.mediaReady("news", "intro.mp4") }()
+	select {
+	case ready := <-done:
+		if ready {
+			t.Fatal("mediaReady = true before the file was downloaded")
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("mediaReady blocked on the download")
+	}
+
+	close(store.release)
+	select {
+	case <-c.reconcileWake:
+	case <-time.After(2 * time.Second):
+		t.Fatal("reconciler was not woken when the download finished")
+	}
+	c.mu.Lock()
+	woken := c.wakeChannels["news"]
+	c.mu.Unlock()
+	if !woken {
+		t.Error("news was not queued for reconcile")
+	}
+	if b, err := os.ReadFile(filepath.Join(dir, "intro.mp4")); err != nil || string(b) != "video" {
+		t.Fatalf("local copy = %q, %v", b, err)
+	}
+	if !c.mediaReady("news", "intro.mp4") {
+		t.Error("mediaReady = false after the file was synced")
+	}
+
+	// A local copy removed behind our back is no longer ready
+	os.Remove(filepath.Join(dir, "intro.mp4"))
+	if c.mediaReady("news", "intro.mp4") {
+		t.Error("mediaReady = true after the local copy was removed")
+	}
+}
+
+func TestMediaReadyLocalBackend(t *testing.T) {
+	c := &Controller{Config: &Config{}, Media: &localMediaStore{dir: t.TempDir()}}
+	if !c.mediaReady("news", "missing.mp4") {
+		t.Error("local backend should never wait for a sync")
+	}
+}
+
+func TestLocalMediaStore(t *testing.T) {
+	store, err := NewMediaStore(&Config{MediaPath: filepath.Join(t.TempDir(), "media")})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if files, err := store.List(); err != nil || len(files) != 0 {
+		t.Fatalf("List on a new store = %v, %v", files, err)
+	}
+
+	w, err := store.Create("intro.mp4")
+	if err != nil {
+		t.Fatal(err)
+	}
+	io.WriteString(w, "video data")
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	info, err := store.Stat("intro.mp4")
+	if err != nil || info.Name != "intro.mp4" || info.Size != 10 {
+		t.Errorf("Stat = %+v, %v", info, err)
+	}
+	files, err := store.List()
+	if err != nil || len(files) != 1 || files[0].Name != "intro.mp4" {
+		t.Errorf("List = %+v, %v", files, err)
+	}
+	r, err := store.Open("intro.mp4")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, _ := io.ReadAll(r)
+	r.Close()
+	if string(b) != "video data" {
+		t.Errorf("Open read %q", b)
+	}
+
+	if err := store.Delete("intro.mp4"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.Stat("intro.mp4"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("Stat after Delete = %v, want fs.ErrNotExist", err)
+	}
+	if _, err := store.Open("intro.mp4"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("Open after Delete = %v, want fs.ErrNotExist", err)
+	}
+}
+
+func TestNewMediaStoreRejectsIncompleteS3Config(t *testing.T) {
+	if _, err := NewMediaStore(&Config{MediaBackend: "s3", S3Bucket: "media"}); err == nil {
+		t.Error("s3 backend without credentials was accepted")
+	}
+	if _, err := NewMediaStore(&Config{MediaBackend: "ftp"}); err == nil {
+		t.Error("unknown backend was accepted")
+	}
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"database/sql"
+	"embed"
+	"fmt"
+	"io/fs"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Migrations are applied in version order, each in its own transaction, and
+// recorded in schema_migrations. 01_schema.sql is the full current schema for
+// fresh databases; later files are idempotent deltas for existing ones.
+//
+//go:embed migrations/*.sql
+var migrationFiles embed.FS
+
+type migration struct {
+	Version int
+	Name    string
+	SQL     string
+}
+
+// loadMigrations reads the embedded migrations, sorted by the numeric prefix
+// of their file names ("02_multitenancy.sql" is version 2).
+func loadMigrations(fsys fs.FS) ([]migration, error) {
+	entries, err := fs.ReadDir(fsys, "migrations")
+	if err != nil {
+		return nil, err
+	}
+
+	var migrations []migration
+	seen := map[int]string{}
+	for _, e := range entries {
+		name := e.Name()
+		if e.IsDir() || !strings.HasSuffix(name, ".sql") {
+			continue
+		}
+		prefix, _, ok := strings.Cut(name, "_")
+		if !ok {
+			return nil, fmt.Errorf("migration %s: name must be <version>_<description>.sql", name)
+		}
+		version, err := strconv.Atoi(prefix)
+		if err != nil {
+			return nil, fmt.Errorf("migration %s: invalid version %q", name, prefix)
+		}
+		if other, dup := seen[version]; dup {
+			return nil, fmt.Errorf("migrations %s and %s share version %d", other, name, version)
+		}
+		seen[version] = name
+
+		body, err := fs.ReadFile(fsys, "migrations/"+name)
+		if err != nil {
+			return nil, err
+		}
+		migrations = append(migrations, migration{Version: version, Name: name, SQL: string(body)})
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+	return migrations, nil
+}
+
+// RunMigrations brings the database schema up to date.
+func RunMigrations(db *sql.DB) error {
+	migrations, err := loadMigrations(migrationFiles)
+	if err != nil {
+		return fmt.Errorf("load migrations: %v", err)
+	}
+
+	var tracked bool
+	if err := db.QueryRow("SELECT to_regclass('schema_migrations') IS NOT NULL").Scan(&tracked); err != nil {
+		return fmt.Errorf("check schema_migrations: %v", err)
+	}
+	if !tracked {
+		if _, err := db.Exec(`
+			CREATE TABLE IF NOT EXISTS schema_migrations (
+				version INT PRIMARY KEY,
+				name TEXT NOT NULL,
+				applied_at TIMESTAMP DEFAULT NOW()
+			)
+		`); err != nil {
+			return fmt.Errorf("create schema_migrations: %v", err)
+		}
+
+		// Databases provisioned before the runner existed already have the base
+		// schema (from the Postgres init script). Re-running it would re-seed
+		// default channels that may have been deleted since.
+		var existing bool
+		db.QueryRow("SELECT to_regclass('channels') IS NOT NULL").Scan(&existing)
+		if existing && len(migrations) > 0 && migrations[0].Version == 1 {
+			if _, err := db.Exec("INSERT INTO schema_migrations (version, name) VALUES ($1, $2) ON CONFLICT DO NOTHING",
+				migrations[0].Version, migrations[0].Name); err != nil {
+				return fmt.Errorf("baseline schema_migrations: %v", err)
+			}
+			log.Printf("[MIGRATE] Existing database found, recorded %s as baseline", migrations[0].Name)
+		}
+	}
+
+	applied := map[int]bool{}
+	rows, err := db.Query("SELECT version FROM schema_migrations")
+	if err != nil {
+		return fmt.Errorf("read schema_migrations: %v", err)
+	}
+	for rows.Next() {
+		var v int
+		if rows.Scan(&v) == nil {
+			applied[v] = true
+		}
+	}
+	rows.Close()
+
+	for _, m := range migrations {
+		if applied[m.Version] {
+			continue
+		}
+		if err := applyMigration(db, m); err != nil {
+			return err
+		}
+		log.Printf("[MIGRATE] Applied %s", m.Name)
+	}
+	return nil
+}
+
+func applyMigration(db *sql.DB, m migration) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("migration %s: %v", m.Name, err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(m.SQL); err != nil {
+		return fmt.Errorf("migration %s: %v", m.Name, err)
+	}
+	if _, err := tx.Exec("INSERT INTO schema_migrations (version, name) VALUES ($1, $2)", m.Version, m.Name); err != nil {
+		return fmt.Errorf("migration %s: record version: %v", m.Name, err)
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("migration %s: commit: %v", m.Name, err)
+	}
+	return nil
+}
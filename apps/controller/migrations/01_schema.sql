@@ -36,6 +36,8 @@ CREATE TABLE IF NOT EXISTS channels (
     
     -- Loop configuration
     loop_source_file TEXT DEFAULT '/app/media/default.mp4',
+    source_mode TEXT DEFAULT 'file',      -- file / playlist / testpattern
+    playlist_files TEXT[] DEFAULT '{}',   -- media files played in order when source_mode = 'playlist'
     loop_enabled BOOLEAN DEFAULT TRUE,
     
     -- State
@@ -55,6 +57,26 @@ CREATE TABLE IF NOT EXISTS channels (
     video_bitrate INT DEFAULT 0,          -- kbps (0 = auto 4500k)
     audio_bitrate INT DEFAULT 128,        -- kbps
     output_resolution TEXT DEFAULT '',    -- e.g. "1920x1080" or empty for source
+    output_fps INT DEFAULT 30,            -- 24/25/30/50/60
+    encoder_preset TEXT DEFAULT 'ultrafast',  -- relay x264 preset
+    encoder_tune TEXT DEFAULT 'zerolatency',  -- relay x264 tune ('none' to omit)
+    obs_rw_timeout_ms INT DEFAULT 5000,   -- relay OBS pump stalled-read timeout
+    relay_probesize_kb INT DEFAULT 32000, -- relay transcoder -probesize (KB)
+    relay_analyzeduration_ms INT DEFAULT 100, -- relay transcoder -analyzeduration (ms)
+    reconcile_every INT DEFAULT 1,        -- reconcile every N cycles (1 = every cycle)
+    adaptive_bitrate BOOLEAN DEFAULT false, -- drop to abr_low_bitrate with few viewers
+    abr_low_bitrate INT DEFAULT 1000,     -- kbps below abr_viewer_threshold
+    abr_high_bitrate INT DEFAULT 0,       -- kbps at/above threshold (0 = video_bitrate)
+    abr_viewer_threshold INT DEFAULT 1,
+    abr_ladder_enabled BOOLEAN DEFAULT false, -- also publish ABR_LADDER renditions
+    relay_image TEXT DEFAULT '',          -- per-channel RELAY_IMAGE override ('' = global)
+    loop_image TEXT DEFAULT '',           -- per-channel LOOP_IMAGE override ('' = global)
+    ingest_protocol TEXT DEFAULT 'rtmp',  -- rtmp / webrtc (WHIP ingest, matched in any SRS app)
+    recording_enabled BOOLEAN DEFAULT false, -- relay records segmented MP4s
+    external_source_url TEXT DEFAULT '',  -- live source pulled when source_mode = external
+    primary_source TEXT DEFAULT 'loop',   -- loop / obs (hold the loop until OBS first publishes)
+    metadata JSONB DEFAULT '{}'::jsonb,   -- integrator-defined object (external IDs, tags, notes)
+    paused BOOLEAN DEFAULT false,         -- containers stopped but kept for a fast resume
     
     -- Organization (for multi-tenant)
     organization_id UUID,
@@ -71,6 +93,7 @@ CREATE TABLE IF NOT EXISTS destinations (
     rtmp_url TEXT NOT NULL,
     stream_key TEXT,
     enabled BOOLEAN DEFAULT TRUE,
+    paused BOOLEAN DEFAULT FALSE, -- Enabled but temporarily not pushed to
     
     -- Health status
     status TEXT DEFAULT 'UNKNOWN' CHECK (status IN ('CONNECTED', 'DISCONNECTED', 'ERROR', 'UNKNOWN')),
@@ -78,10 +101,40 @@ CREATE TABLE IF NOT EXISTS destinations (
     retry_count INT DEFAULT 0,
     last_connected_at TIMESTAMP,
     
+    -- Auto-disable after sustained failure (opt-in)
+    auto_disable BOOLEAN DEFAULT FALSE,
+    auto_disabled_reason TEXT,
+    
+    -- Optional per-destination transcode instead of copying the clean stream
+    transcode_enabled BOOLEAN DEFAULT FALSE,
+    transcode_resolution TEXT DEFAULT '',
+    transcode_video_bitrate INT DEFAULT 0,
+    transcode_audio_bitrate INT DEFAULT 0,
+    
     created_at TIMESTAMP DEFAULT NOW(),
     updated_at TIMESTAMP DEFAULT NOW()
 );
 
+-- Optional publisher IP allowlist per channel (empty = allow all)
+CREATE TABLE IF NOT EXISTS channel_ip_allowlist (
+    id SERIAL PRIMARY KEY,
+    channel_id INT NOT NULL REFERENCES channels(id) ON DELETE CASCADE,
+    cidr TEXT NOT NULL,
+    description TEXT DEFAULT '',
+    created_at TIMESTAMP DEFAULT NOW(),
+    UNIQUE (channel_id, cidr)
+);
+
+-- Issued login sessions, revocable before they expire
+CREATE TABLE IF NOT EXISTS sessions (
+    jti TEXT PRIMARY KEY,
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    issued_at TIMESTAMP DEFAULT NOW(),
+    expires_at TIMESTAMP NOT NULL,
+    revoked BOOLEAN DEFAULT FALSE,
+    revoked_at TIMESTAMP
+);
+
 -- Health metrics history
 CREATE TABLE IF NOT EXISTS health_metrics (
     id SERIAL PRIMARY KEY,
@@ -129,6 +182,8 @@ CREATE TABLE IF NOT EXISTS system_config (
 -- Indexes for performance
 CREATE INDEX IF NOT EXISTS idx_channels_name ON channels(name);
 CREATE INDEX IF NOT EXISTS idx_destinations_channel ON destinations(channel_id);
+CREATE INDEX IF NOT EXISTS idx_ip_allowlist_channel ON channel_ip_allowlist(channel_id);
+CREATE INDEX IF NOT EXISTS idx_sessions_user ON sessions(user_id, issued_at DESC);
 CREATE INDEX IF NOT EXISTS idx_health_metrics_channel_time ON health_metrics(channel_id, recorded_at DESC);
 CREATE INDEX IF NOT EXISTS idx_audit_logs_user ON audit_logs(user_email);
 CREATE INDEX IF NOT EXISTS idx_audit_logs_time ON audit_logs(created_at DESC);
@@ -139,7 +194,7 @@ CREATE INDEX IF NOT EXISTS idx_audit_logs_resource ON audit_logs(resource_type,
 INSERT INTO users (email, password_hash, name, role) 
 VALUES (
     'admin@livestream.local',
-    '$2a$10$K7L1OJ45/4Y2nIvhRVpCe.FSmhDdWoXehVzJptJ/op0lSsvqNu/X6',
+    '$2a$10$K7L1OJ45/4Y2nIvhRVpCe.Zo3nLZvhE7WLw4jrUP0e33DlpMT9jLm',
     'System Administrator',
     'ADMIN'
 ) ON CONFLICT (email) DO NOTHING;
@@ -0,0 +1,13 @@
+-- Publisher IP Allowlist
+-- Optional per-channel list of IPs/CIDRs allowed to publish. Empty = allow all.
+
+CREATE TABLE IF NOT EXISTS channel_ip_allowlist (
+    id SERIAL PRIMARY KEY,
+    channel_id INT NOT NULL REFERENCES channels(id) ON DELETE CASCADE,
+    cidr TEXT NOT NULL,             -- single IP (203.0.113.7) or CIDR (203.0.113.0/24)
+    description TEXT DEFAULT '',
+    created_at TIMESTAMP DEFAULT NOW(),
+    UNIQUE (channel_id, cidr)
+);
+
+CREATE INDEX IF NOT EXISTS idx_ip_allowlist_channel ON channel_ip_allowlist(channel_id);
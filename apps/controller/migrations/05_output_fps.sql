@@ -0,0 +1,6 @@
+-- Output Framerate Migration
+-- Per-channel output FPS used by the loop publisher and relay transcoder
+
+ALTER TABLE channels ADD COLUMN IF NOT EXISTS output_fps INTEGER DEFAULT 30;
+
+COMMENT ON COLUMN channels.output_fps IS 'Output framerate (24, 25, 30, 50 or 60); GOP = output_fps * keyframe_interval';
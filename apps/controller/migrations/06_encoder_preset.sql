@@ -0,0 +1,8 @@
+-- Encoder Preset Migration
+-- Per-channel x264 preset/tune for the relay transcoder
+
+ALTER TABLE channels ADD COLUMN IF NOT EXISTS encoder_preset TEXT DEFAULT 'ultrafast';
+ALTER TABLE channels ADD COLUMN IF NOT EXISTS encoder_tune TEXT DEFAULT 'zerolatency';
+
+COMMENT ON COLUMN channels.encoder_preset IS 'x264 preset for the relay transcoder (ultrafast ... veryslow)';
+COMMENT ON COLUMN channels.encoder_tune IS 'x264 tune for the relay transcoder, or none to omit -tune';
@@ -0,0 +1,8 @@
+-- Destination Auto-Disable Migration
+-- Destinations that keep failing can be disabled automatically instead of retried forever
+
+ALTER TABLE destinations ADD COLUMN IF NOT EXISTS auto_disable BOOLEAN DEFAULT FALSE;
+ALTER TABLE destinations ADD COLUMN IF NOT EXISTS auto_disabled_reason TEXT;
+
+COMMENT ON COLUMN destinations.auto_disable IS 'Disable this destination after DEST_AUTO_DISABLE_MINUTES of continuous failure';
+COMMENT ON COLUMN destinations.auto_disabled_reason IS 'Why the controller disabled this destination; cleared when re-enabled';
@@ -0,0 +1,6 @@
+-- OBS Read Timeout Migration
+-- Per-channel stalled-read timeout for the relay's OBS pump (failover responsiveness)
+
+ALTER TABLE channels ADD COLUMN IF NOT EXISTS obs_rw_timeout_ms INTEGER DEFAULT 5000;
+
+COMMENT ON COLUMN channels.obs_rw_timeout_ms IS 'FFmpeg -rw_timeout for the relay OBS pump, in ms (1000-60000); lower fails over faster';
@@ -0,0 +1,8 @@
+-- Loop Source Mode Migration
+-- Lets a channel loop a single file, a playlist, or a generated test pattern
+
+ALTER TABLE channels ADD COLUMN IF NOT EXISTS source_mode TEXT DEFAULT 'file';
+ALTER TABLE channels ADD COLUMN IF NOT EXISTS playlist_files TEXT[] DEFAULT '{}';
+
+COMMENT ON COLUMN channels.source_mode IS 'Loop publisher input: file, playlist or testpattern (SMPTE bars + tone + timecode)';
+COMMENT ON COLUMN channels.playlist_files IS 'Media files streamed in order, looping, when source_mode = playlist';
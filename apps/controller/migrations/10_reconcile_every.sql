@@ -0,0 +1,6 @@
+-- Reconcile Interval Migration
+-- Lets stable channels be reconciled every Nth cycle instead of every cycle
+
+ALTER TABLE channels ADD COLUMN IF NOT EXISTS reconcile_every INT DEFAULT 1;
+
+COMMENT ON COLUMN channels.reconcile_every IS 'Reconcile this channel every N reconciler cycles (1 = every cycle, for critical channels)';
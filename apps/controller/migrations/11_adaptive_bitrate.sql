@@ -0,0 +1,12 @@
+-- Adaptive Bitrate Migration
+-- Lets a channel encode at a lower bitrate while nobody is watching
+
+ALTER TABLE channels ADD COLUMN IF NOT EXISTS adaptive_bitrate BOOLEAN DEFAULT false;
+ALTER TABLE channels ADD COLUMN IF NOT EXISTS abr_low_bitrate INT DEFAULT 1000;
+ALTER TABLE channels ADD COLUMN IF NOT EXISTS abr_high_bitrate INT DEFAULT 0;
+ALTER TABLE channels ADD COLUMN IF NOT EXISTS abr_viewer_threshold INT DEFAULT 1;
+
+COMMENT ON COLUMN channels.adaptive_bitrate IS 'Switch between abr_low_bitrate and abr_high_bitrate based on SRS viewer count';
+COMMENT ON COLUMN channels.abr_low_bitrate IS 'Video kbps while viewers are below abr_viewer_threshold';
+COMMENT ON COLUMN channels.abr_high_bitrate IS 'Video kbps once viewers reach abr_viewer_threshold (0 = video_bitrate)';
+COMMENT ON COLUMN channels.abr_viewer_threshold IS 'Viewer count at which the channel moves to the high bitrate';
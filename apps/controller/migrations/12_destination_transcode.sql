@@ -0,0 +1,13 @@
+-- Per-Destination Transcode Profiles
+-- Destinations normally get a copy of the channel's clean stream; a profiled
+-- destination gets its own re-encode at a different resolution/bitrate
+
+ALTER TABLE destinations ADD COLUMN IF NOT EXISTS transcode_enabled BOOLEAN DEFAULT FALSE;
+ALTER TABLE destinations ADD COLUMN IF NOT EXISTS transcode_resolution TEXT DEFAULT '';
+ALTER TABLE destinations ADD COLUMN IF NOT EXISTS transcode_video_bitrate INT DEFAULT 0;
+ALTER TABLE destinations ADD COLUMN IF NOT EXISTS transcode_audio_bitrate INT DEFAULT 0;
+
+COMMENT ON COLUMN destinations.transcode_enabled IS 'Re-encode for this destination instead of copying the clean stream';
+COMMENT ON COLUMN destinations.transcode_resolution IS 'WIDTHxHEIGHT for the transcode; empty keeps the channel resolution';
+COMMENT ON COLUMN destinations.transcode_video_bitrate IS 'Video bitrate in kbps for the transcode; 0 keeps the channel bitrate';
+COMMENT ON COLUMN destinations.transcode_audio_bitrate IS 'Audio bitrate in kbps for the transcode; 0 keeps the channel bitrate';
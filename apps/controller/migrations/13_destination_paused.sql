@@ -0,0 +1,7 @@
+-- Destination Pause Migration
+-- A paused destination keeps its configuration and stays enabled, but the
+-- relay stops pushing to it until it is resumed
+
+ALTER TABLE destinations ADD COLUMN IF NOT EXISTS paused BOOLEAN DEFAULT FALSE;
+
+COMMENT ON COLUMN destinations.paused IS 'Temporarily excluded from the relay without disabling';
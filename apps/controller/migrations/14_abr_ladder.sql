@@ -0,0 +1,6 @@
+-- ABR Ladder Migration
+-- Lets a channel's relay publish extra renditions (ABR_LADDER) for HLS packaging
+
+ALTER TABLE channels ADD COLUMN IF NOT EXISTS abr_ladder_enabled BOOLEAN DEFAULT false;
+
+COMMENT ON COLUMN channels.abr_ladder_enabled IS 'Relay also encodes each ABR_LADDER rung to its own SRS stream (CPU-heavy)';
@@ -0,0 +1,8 @@
+-- Channel Image Override Migration
+-- Lets one channel run a different loop/relay image (e.g. a canary build)
+
+ALTER TABLE channels ADD COLUMN IF NOT EXISTS relay_image TEXT DEFAULT '';
+ALTER TABLE channels ADD COLUMN IF NOT EXISTS loop_image TEXT DEFAULT '';
+
+COMMENT ON COLUMN channels.relay_image IS 'Relay manager image for this channel ('''' = RELAY_IMAGE)';
+COMMENT ON COLUMN channels.loop_image IS 'Loop publisher image for this channel ('''' = LOOP_IMAGE)';
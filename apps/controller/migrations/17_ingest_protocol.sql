@@ -0,0 +1,7 @@
+-- Ingest Protocol Migration
+-- Channels fed over WebRTC (WHIP) may show up under another SRS app, so the
+-- controller needs to know to look for them there
+
+ALTER TABLE channels ADD COLUMN IF NOT EXISTS ingest_protocol TEXT DEFAULT 'rtmp';
+
+COMMENT ON COLUMN channels.ingest_protocol IS 'Expected OBS-side ingest: rtmp or webrtc (WHIP, matched by name in any SRS app)';
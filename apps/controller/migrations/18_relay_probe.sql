@@ -0,0 +1,9 @@
+-- Relay Probe Migration
+-- How much input the relay transcoder inspects before starting, trading
+-- startup latency against stream detection reliability
+
+ALTER TABLE channels ADD COLUMN IF NOT EXISTS relay_probesize_kb INTEGER DEFAULT 32000;
+ALTER TABLE channels ADD COLUMN IF NOT EXISTS relay_analyzeduration_ms INTEGER DEFAULT 100;
+
+COMMENT ON COLUMN channels.relay_probesize_kb IS 'FFmpeg -probesize for the relay transcoder input, in KB (32-100000)';
+COMMENT ON COLUMN channels.relay_analyzeduration_ms IS 'FFmpeg -analyzeduration for the relay transcoder input, in ms (1-10000)';
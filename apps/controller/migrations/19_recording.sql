@@ -0,0 +1,6 @@
+-- Recording Migration
+-- Channels can have their relay record the clean stream as segmented MP4s
+
+ALTER TABLE channels ADD COLUMN IF NOT EXISTS recording_enabled BOOLEAN DEFAULT false;
+
+COMMENT ON COLUMN channels.recording_enabled IS 'Relay records the clean stream under RECORDINGS_PATH/<channel>, pruned after RECORDING_RETENTION_HOURS';
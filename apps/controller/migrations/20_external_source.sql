@@ -0,0 +1,6 @@
+-- External Source Migration
+-- Channels can relay an existing RTMP/HLS stream (e.g. another CDN) as their live source
+
+ALTER TABLE channels ADD COLUMN IF NOT EXISTS external_source_url TEXT DEFAULT '';
+
+COMMENT ON COLUMN channels.external_source_url IS 'Pulled by the relay in place of OBS when source_mode = external; the loop file remains the fallback';
@@ -0,0 +1,6 @@
+-- Primary Source Migration
+-- OBS-first channels hold their loop until OBS has published
+
+ALTER TABLE channels ADD COLUMN IF NOT EXISTS primary_source TEXT DEFAULT 'loop';
+
+COMMENT ON COLUMN channels.primary_source IS 'loop: start the loop on boot; obs: show WAITING_FOR_OBS and start the loop (as fallback) only once OBS has published';
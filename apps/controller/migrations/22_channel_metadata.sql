@@ -0,0 +1,6 @@
+-- Channel Metadata Migration
+-- Free-form JSON object integrators attach to channels (external IDs, tags, notes)
+
+ALTER TABLE channels ADD COLUMN IF NOT EXISTS metadata JSONB DEFAULT '{}'::jsonb;
+
+COMMENT ON COLUMN channels.metadata IS 'Integrator-defined JSON object; not interpreted by the controller. Filter with GET /api/channels?metadata_key=&metadata_value=';
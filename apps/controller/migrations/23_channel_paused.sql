@@ -0,0 +1,6 @@
+-- Channel Pause Migration
+-- Paused channels keep their stopped loop/relay containers for a fast resume
+
+ALTER TABLE channels ADD COLUMN IF NOT EXISTS paused BOOLEAN DEFAULT false;
+
+COMMENT ON COLUMN channels.paused IS 'Containers stopped (not removed) via POST /api/channels/{id}/pause; cleared by /resume and when the channel is disabled';
@@ -0,0 +1,8 @@
+-- Seed Admin Hash Migration
+-- The seeded admin hash never matched its documented password (admin123), so
+-- the admin UI fell back to a built-in login. Replace it only where unchanged.
+
+UPDATE users
+SET password_hash = '$2a$10$K7L1OJ45/4Y2nIvhRVpCe.Zo3nLZvhE7WLw4jrUP0e33DlpMT9jLm'
+WHERE email = 'admin@livestream.local'
+  AND password_hash = '$2a$10$K7L1OJ45/4Y2nIvhRVpCe.FSmhDdWoXehVzJptJ/op0lSsvqNu/X6';
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"database/sql/driver"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestRunMigrationsRecordsVersions(t *testing.T) {
+	migrations, err := loadMigrations(migrationFiles)
+	if err != nil {
+		t.Fatal(err)
+	}
+	last := migrations[len(migrations)-1]
+
+	// An existing database that has everything but the newest migration
+	var stmts []string
+	var recorded []driver.Value
+	db := newFakeDB(t, func(query string, args []driver.Value) ([]string, [][]driver.Value, error) {
+		query = strings.TrimSpace(query)
+		switch {
+		case strings.HasPrefix(query, "SELECT to_regclass"):
+			return []string{"exists"}, [][]driver.Value{{true}}, nil
+		case query == "SELECT version FROM schema_migrations":
+			var rows [][]driver.Value
+			for _, m := range migrations[:len(migrations)-1] {
+				rows = append(rows, []driver.Value{int64(m.Version)})
+			}
+			return []string{"version"}, rows, nil
+		case strings.HasPrefix(query, "INSERT INTO schema_migrations"):
+			recorded = append(recorded, args...)
+			stmts = append(stmts, "record")
+		case query == strings.TrimSpace(last.SQL):
+			stmts = append(stmts, "migrate")
+		default:
+			stmts = append(stmts, query)
+		}
+		return nil, nil, nil
+	})
+
+	if err := RunMigrations(db); err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"BEGIN", "migrate", "record", "COMMIT"}; fmt.Sprint(stmts) != fmt.Sprint(want) {
+		t.Errorf("statements = %q, want %q", stmts, want)
+	}
+	if want := []driver.Value{int64(last.Version), last.Name}; fmt.Sprint(recorded) != fmt.Sprint(want) {
+		t.Errorf("version row = %v, want %v", recorded, want)
+	}
+}
+
+func TestLoadMigrationsOrdered(t *testing.T) {
+	migrations, err := loadMigrations(migrationFiles)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(migrations) == 0 {
+		t.Fatal("no embedded migrations")
+	}
+	if migrations[0].Version != 1 {
+		t.Errorf("first migration is %s, want version 1", migrations[0].Name)
+	}
+	for i := 1; i < len(migrations); i++ {
+		if migrations[i].Version <= migrations[i-1].Version {
+			t.Errorf("%s sorts after %s", migrations[i].Name, migrations[i-1].Name)
+		}
+	}
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/docker/docker/api/types/network"
+	"github.com/docker/docker/client"
+)
+
+// checkDockerNetwork verifies that DOCKER_NETWORK exists before the reconciler
+// starts creating loop and relay containers on it. A missing network is
+// created when CREATE_DOCKER_NETWORK is set and is fatal otherwise; any other
+// Docker error is only logged, since the daemon may still be coming up.
+func (c *Controller) checkDockerNetwork(ctx context.Context) error {
+	name := c.Config.DockerNetwork
+	_, err := c.Docker.NetworkInspect(ctx, name, network.InspectOptions{})
+	if err == nil {
+		return nil
+	}
+	if !client.IsErrNotFound(err) {
+		log.Printf("[WARN] Could not verify Docker network %q: %v", name, err)
+		return nil
+	}
+	if !c.Config.CreateDockerNetwork {
+		return fmt.Errorf("docker network %q does not exist: start the stack with docker compose so it is created, set DOCKER_NETWORK to an existing network (see `docker network ls`), or set CREATE_DOCKER_NETWORK=true", name)
+	}
+	if _, err := c.Docker.NetworkCreate(ctx, name, network.CreateOptions{
+		Driver: "bridge",
+		Labels: map[string]string{"managed_by": "livestream-controller"},
+	}); err != nil {
+		return fmt.Errorf("create docker network %q: %v", name, err)
+	}
+	log.Printf("[DOCKER] Created network %q", name)
+	return nil
+}
+
+// networkHealth reports on DOCKER_NETWORK for ServicesHealthHandler.
+func (c *Controller) networkHealth(ctx context.Context) ServiceHealth {
+	name := c.Config.DockerNetwork
+	start := time.Now()
+	info, err := c.Docker.NetworkInspect(ctx, name, network.InspectOptions{})
+	health := ServiceHealth{
+		Name:      fmt.Sprintf("Docker Network (%s)", name),
+		Status:    "healthy",
+		Latency:   time.Since(start).Milliseconds(),
+		Uptime:    "-",
+		LastCheck: time.Now().Format("15:04:05"),
+	}
+	switch {
+	case client.IsErrNotFound(err):
+		health.Status = "down"
+		health.Details = "Network not found; loop and relay containers cannot be created"
+	case err != nil:
+		health.Status = "down"
+		health.Details = err.Error()
+	default:
+		health.Details = fmt.Sprintf("Driver: %s, %d containers attached", info.Driver, len(info.Containers))
+	}
+	return health
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/docker/docker/client"
+)
+
+// fakeNetworks returns a Docker client that knows only the networks in
+// present, adding any it is asked to create, and records the create calls.
+func fakeNetworks(t *testing.T, present map[string]bool) (*client.Client, *[]string) {
+	t.Helper()
+	var names []string
+	docker := newFakeDocker(t, func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == "POST" && r.URL.Path == "/networks/create":
+			var body struct{ Name string }
+			json.NewDecoder(r.Body).Decode(&body)
+			names = append(names, body.Name)
+			present[body.Name] = true
+			w.WriteHeader(http.StatusCreated)
+			w.Write([]byte(`{"Id":"net-1"}`))
+		case r.Method == "GET" && strings.HasPrefix(r.URL.Path, "/networks/"):
+			name := strings.TrimPrefix(r.URL.Path, "/networks/")
+			if !present[name] {
+				w.WriteHeader(http.StatusNotFound)
+				w.Write([]byte(`{"message":"network ` + name + ` not found"}`))
+				return
+			}
+			w.Write([]byte(`{"Name":"` + name + `","Driver":"bridge","Containers":{"a":{},"b":{}}}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	})
+	return docker, &names
+}
+
+func TestCheckDockerNetworkMissing(t *testing.T) {
+	docker, created := fakeNetworks(t, map[string]bool{})
+	c := newTestController(&Config{DockerNetwork: "livestream-net"}, nil)
+	c.Docker = docker
+
+	err := c.checkDockerNetwork(context.Background())
+	if err == nil {
+		t.Fatal("missing network was not reported")
+	}
+	for _, want := range []string{`"livestream-net" does not exist`, "DOCKER_NETWORK", "CREATE_DOCKER_NETWORK"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("error %q does not mention %s", err, want)
+		}
+	}
+	if len(*created) != 0 {
+		t.Errorf("network created without CREATE_DOCKER_NETWORK: %q", *created)
+	}
+
+	health := c.networkHealth(context.Background())
+	if health.Status != "down" || !strings.Contains(health.Details, "not found") {
+		t.Errorf("health = %+v, want down with network not found", health)
+	}
+}
+
+func TestCheckDockerNetworkCreate(t *testing.T) {
+	docker, created := fakeNetworks(t, map[string]bool{})
+	c := newTestController(&Config{DockerNetwork: "livestream-net", CreateDockerNetwork: true}, nil)
+	c.Docker = docker
+
+	if err := c.checkDockerNetwork(context.Background()); err != nil {
+		t.Fatalf("checkDockerNetwork: %v", err)
+	}
+	if len(*created) != 1 || (*created)[0] != "livestream-net" {
+		t.Fatalf("created = %q, want [livestream-net]", *created)
+	}
+
+	// Now present, it is neither an error nor created again
+	if err := c.checkDockerNetwork(context.Background()); err != nil || len(*created) != 1 {
+		t.Errorf("second check: err = %v, created = %q", err, *created)
+	}
+	health := c.networkHealth(context.Background())
+	if health.Status != "healthy" || health.Details != "Driver: bridge, 2 containers attached" {
+		t.Errorf("health = %+v, want healthy with driver and container count", health)
+	}
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// recordingNameLayout is how the relay names segments (FFmpeg strftime
+// %Y%m%dT%H%M%SZ, in UTC), so a segment's start time can be read back from
+// its file name.
+const recordingNameLayout = "20060102T150405Z"
+
+// Recording is one recorded segment of a channel's clean stream.
+type Recording struct {
+	File       string     `json:"file"`
+	StartedAt  *time.Time `json:"started_at,omitempty"` // From the file name; nil if it doesn't parse
+	ModifiedAt time.Time  `json:"modified_at"`          // Last write, i.e. roughly when the segment ended
+	SizeBytes  int64      `json:"size_bytes"`
+	ExpiresAt  *time.Time `json:"expires_at,omitempty"` // When retention deletes it; nil if kept forever
+}
+
+// recordingsHostDir is the channel's recordings directory as Docker sees it,
+// bind-mounted into the relay at /recordings.
+func (c *Controller) recordingsHostDir(channel string) string {
+	return filepath.Join(c.Config.RecordingsHostPath, channel)
+}
+
+// recordingExpired reports whether a segment last written at modTime is past
+// retention at now. A zero retention keeps recordings forever.
+func recordingExpired(modTime, now time.Time, retention time.Duration) bool {
+	return retention > 0 && now.Sub(modTime) > retention
+}
+
+// listRecordings returns a channel's segments, oldest first. A channel that
+// has never recorded has no directory and so no recordings.
+func (c *Controller) listRecordings(channel string) ([]Recording, error) {
+	entries, err := os.ReadDir(filepath.Join(c.Config.RecordingsPath, channel))
+	if os.IsNotExist(err) {
+		return []Recording{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	recordings := []Recording{}
+	for _, e := range entries {
+		if e.IsDir() || !strings.HasSuffix(e.Name(), ".mp4") {
+			continue
+		}
+		info, err := e.Info()
+		if err != nil {
+			continue
+		}
+		rec := Recording{File: e.Name(), ModifiedAt: info.ModTime().UTC(), SizeBytes: info.Size()}
+		if t, err := time.Parse(recordingNameLayout, strings.TrimSuffix(e.Name(), ".mp4")); err == nil {
+			rec.StartedAt = &t
+		}
+		if retention := c.Config.RecordingRetention; retention > 0 {
+			expires := rec.ModifiedAt.Add(retention)
+			rec.ExpiresAt = &expires
+		}
+		recordings = append(recordings, rec)
+	}
+	sort.Slice(recordings, func(i, j int) bool { return recordings[i].File < recordings[j].File })
+	return recordings, nil
+}
+
+// pruneRecordings deletes segments older than RECORDING_RETENTION_HOURS
+// from every channel's recordings directory. The segment being written is
+// always recent, so it is never touched.
+func (c *Controller) pruneRecordings() {
+	retention := c.Config.RecordingRetention
+	if retention <= 0 {
+		return
+	}
+	channels, err := os.ReadDir(c.Config.RecordingsPath)
+	if err != nil {
+		return
+	}
+
+	now := time.Now()
+	for _, ch := range channels {
+		if !ch.IsDir() {
+			continue
+		}
+		recordings, err := c.listRecordings(ch.Name())
+		if err != nil {
+			log.Printf("[RECORDING] Failed to list recordings for %s: %v", ch.Name(), err)
+			continue
+		}
+		for _, rec := range recordings {
+			if !recordingExpired(rec.ModifiedAt, now, retention) {
+				continue
+			}
+			if err := os.Remove(filepath.Join(c.Config.RecordingsPath, ch.Name(), rec.File)); err != nil {
+				log.Printf("[RECORDING] Failed to remove %s/%s: %v", ch.Name(), rec.File, err)
+				continue
+			}
+			log.Printf("[RECORDING] Removed %s/%s (older than %v)", ch.Name(), rec.File, retention)
+		}
+	}
+}
+
+// channelRecordings serves GET /api/channels/{id}/recordings.
+func (c *Controller) channelRecordings(w http.ResponseWriter, ch Channel) {
+	recordings, err := c.listRecordings(ch.Name)
+	if err != nil {
+		c.Log("error", "api", "Failed to list recordings for "+ch.Name+": "+err.Error())
+		http.Error(w, "Failed to list recordings", http.StatusInternalServerError)
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"channel":         ch.Name,
+		"segment_seconds": int(c.Config.RecordingSegment.Seconds()),
+		"retention_hours": int(c.Config.RecordingRetention.Hours()),
+		"recordings":      recordings,
+	})
+}
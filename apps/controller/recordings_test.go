@@ -0,0 +1,84 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestRecordingExpired(t *testing.T) {
+	now := time.Date(2026, 3, 10, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		age       time.Duration
+		retention time.Duration
+		want      bool
+	}{
+		{time.Hour, 24 * time.Hour, false},
+		{24 * time.Hour, 24 * time.Hour, false}, // Exactly at the cutoff is kept
+		{24*time.Hour + time.Second, 24 * time.Hour, true},
+		{30 * 24 * time.Hour, 0, false}, // No retention keeps everything
+	}
+	for _, tt := range tests {
+		if got := recordingExpired(now.Add(-tt.age), now, tt.retention); got != tt.want {
+			t.Errorf("age %v, retention %v: expired = %v, want %v", tt.age, tt.retention, got, tt.want)
+		}
+	}
+}
+
+func TestPruneRecordings(t *testing.T) {
+	dir := t.TempDir()
+	now := time.Now()
+	segments := map[string]time.Duration{ // File -> age
+		"news/20260301T000000Z.mp4":   72 * time.Hour,
+		"news/20260303T230000Z.mp4":   25 * time.Hour,
+		"news/20260304T230000Z.mp4":   time.Hour,
+		"news/notes.txt":              72 * time.Hour, // Not a segment
+		"sports/20260301T000000Z.mp4": 72 * time.Hour,
+	}
+	for file, age := range segments {
+		path := filepath.Join(dir, file)
+		os.MkdirAll(filepath.Dir(path), 0755)
+		os.WriteFile(path, []byte("segment"), 0644)
+		os.Chtimes(path, now.Add(-age), now.Add(-age))
+	}
+	c := newTestController(&Config{RecordingsPath: dir, RecordingRetention: 24 * time.Hour}, nil)
+
+	c.pruneRecordings()
+
+	var left []string
+	for file := range segments {
+		if _, err := os.Stat(filepath.Join(dir, file)); err == nil {
+			left = append(left, file)
+		}
+	}
+	want := map[string]bool{"news/20260304T230000Z.mp4": true, "news/notes.txt": true}
+	if len(left) != len(want) {
+		t.Errorf("left after pruning: %v, want only the recent segment and the non-segment", left)
+	}
+	for _, file := range left {
+		if !want[file] {
+			t.Errorf("%s survived pruning", file)
+		}
+	}
+
+	recordings, err := c.listRecordings("news")
+	if err != nil || len(recordings) != 1 {
+		t.Fatalf("listRecordings = %v, %v; want the one kept segment", recordings, err)
+	}
+	rec := recordings[0]
+	if rec.StartedAt == nil || !rec.StartedAt.Equal(time.Date(2026, 3, 4, 23, 0, 0, 0, time.UTC)) {
+		t.Errorf("started_at = %v, want 2026-03-04T23:00:00Z from the file name", rec.StartedAt)
+	}
+	if rec.ExpiresAt == nil || !rec.ExpiresAt.Equal(rec.ModifiedAt.Add(24*time.Hour)) {
+		t.Errorf("expires_at = %v, want modified_at + 24h", rec.ExpiresAt)
+	}
+
+	// With retention off nothing is pruned
+	c.Config.RecordingRetention = 0
+	os.Chtimes(filepath.Join(dir, "news/20260304T230000Z.mp4"), now.Add(-1000*time.Hour), now.Add(-1000*time.Hour))
+	c.pruneRecordings()
+	if recordings, _ := c.listRecordings("news"); len(recordings) != 1 {
+		t.Errorf("retention 0 pruned segments, %d left", len(recordings))
+	}
+}
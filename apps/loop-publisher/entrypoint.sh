@@ -5,6 +5,7 @@ echo "============================================"
 echo "  Loop Publisher for: ${CHANNEL_NAME:-unknown}"
 echo "============================================"
 echo "Target: $RTMP_URL"
+echo "Mode:   ${SOURCE_MODE:-file}"
 echo "Source: $SOURCE_FILE"
 
 # Configuration from environment (with defaults)
@@ -12,8 +13,11 @@ VIDEO_BITRATE="${VIDEO_BITRATE:-4500}"
 AUDIO_BITRATE="${AUDIO_BITRATE:-128}"
 KEYFRAME_INTERVAL="${KEYFRAME_INTERVAL:-2}"
 OUTPUT_RESOLUTION="${OUTPUT_RESOLUTION:-}"
+OUTPUT_FPS="${OUTPUT_FPS:-30}"
+SOURCE_MODE="${SOURCE_MODE:-file}"        # file | playlist | testpattern
+PLAYLIST_FILES="${PLAYLIST_FILES:-}"      # comma-separated names under /app/media
 
-echo "[CONFIG] Video: ${VIDEO_BITRATE}kbps, Audio: ${AUDIO_BITRATE}kbps, GOP: ${KEYFRAME_INTERVAL}s"
+echo "[CONFIG] Video: ${VIDEO_BITRATE}kbps, Audio: ${AUDIO_BITRATE}kbps, GOP: ${KEYFRAME_INTERVAL}s @ ${OUTPUT_FPS}fps"
 
 # Health check function
 health_check() {
@@ -26,8 +30,8 @@ health_check() {
 # Start health check in background
 health_check &
 
-# Calculate GOP size (keyframe interval * 30fps)
-GOP_SIZE=$((KEYFRAME_INTERVAL * 30))
+# Calculate GOP size (keyframe interval * fps)
+GOP_SIZE=$((KEYFRAME_INTERVAL * OUTPUT_FPS))
 
 # Build video filter for resolution scaling
 VIDEO_FILTER=""
@@ -38,6 +42,70 @@ if [ -n "$OUTPUT_RESOLUTION" ]; then
     echo "[CONFIG] Scaling to ${OUTPUT_RESOLUTION}"
 fi
 
+# Stream SMPTE bars + 1kHz tone with a burned-in timecode, forever.
+# Used for testpattern mode so the pipeline can be verified with no media.
+stream_test_pattern() {
+    local FONT="/usr/share/fonts/dejavu/DejaVuSansMono.ttf"
+    local OVERLAY=""
+    if [ -f "$FONT" ]; then
+        OVERLAY=",drawtext=fontfile=${FONT}:timecode='00\:00\:00\:00':rate=${OUTPUT_FPS}:fontsize=64:fontcolor=white:box=1:boxcolor=black@0.6:boxborderw=12:x=(w-tw)/2:y=h-th-60"
+        OVERLAY="${OVERLAY},drawtext=fontfile=${FONT}:text='${CHANNEL_NAME:-test}':fontsize=40:fontcolor=white:box=1:boxcolor=black@0.6:boxborderw=10:x=(w-tw)/2:y=60"
+    fi
+    while true; do
+        ffmpeg -hide_banner -loglevel warning \
+            -re -f lavfi -i "smptehdbars=size=1920x1080:rate=${OUTPUT_FPS}" \
+            -f lavfi -i "sine=frequency=1000:sample_rate=48000" \
+            -vf "format=yuv420p${OVERLAY}" \
+            -c:v libx264 -preset veryfast \
+            -b:v ${VIDEO_BITRATE}k -maxrate ${VIDEO_BITRATE}k -bufsize $((VIDEO_BITRATE * 2))k \
+            -g ${GOP_SIZE} -keyint_min ${GOP_SIZE} -sc_threshold 0 \
+            -c:a aac -b:a ${AUDIO_BITRATE}k -ar 48000 \
+            -f flv \
+            "$RTMP_URL" 2>&1 | while read line; do
+                echo "[FFMPEG] $line"
+            done
+        echo "[WARN] Test pattern stream exited, restarting in 2s..."
+        sleep 2
+    done
+}
+
+if [ "$SOURCE_MODE" = "testpattern" ]; then
+    echo "[INFO] Test pattern mode (SMPTE bars + tone + timecode)"
+    stream_test_pattern
+fi
+
+# Playlist mode: build an FFmpeg concat list from the files that exist.
+# Files are stream-copied, so they should share codecs/resolution (the media
+# optimizer normalizes uploads).
+if [ "$SOURCE_MODE" = "playlist" ]; then
+    PLAYLIST_LIST="/tmp/playlist.txt"
+    : > "$PLAYLIST_LIST"
+    IFS=',' read -ra ITEMS <<< "$PLAYLIST_FILES"
+    for item in "${ITEMS[@]}"; do
+        if [ -f "/app/media/$item" ]; then
+            echo "file '/app/media/$item'" >> "$PLAYLIST_LIST"
+            echo "[INFO] Playlist item: $item"
+        else
+            echo "[WARN] Playlist item NOT found: $item"
+        fi
+    done
+    if [ -s "$PLAYLIST_LIST" ]; then
+        while true; do
+            ffmpeg -hide_banner -loglevel warning \
+                -re -stream_loop -1 -f concat -safe 0 -i "$PLAYLIST_LIST" \
+                -c copy \
+                -f flv \
+                -flvflags no_duration_filesize \
+                "$RTMP_URL" 2>&1 | while read line; do
+                    echo "[FFMPEG] $line"
+                done
+            echo "[WARN] Playlist stream exited, restarting in 5s..."
+            sleep 5
+        done
+    fi
+    echo "[WARN] No playable playlist items, falling back to single-file mode"
+fi
+
 # Retry logic
 MAX_RETRIES=10
 RETRY_DELAY=5
@@ -106,7 +174,7 @@ while true; do
 
         # Generate test pattern with tone - this always works
         ffmpeg -hide_banner -loglevel warning \
-            -re -f lavfi -i "testsrc=size=1920x1080:rate=30" \
+            -re -f lavfi -i "testsrc=size=1920x1080:rate=${OUTPUT_FPS}" \
             -f lavfi -i "sine=frequency=440:sample_rate=44100" \
             -c:v libx264 -preset ultrafast \
             -b:v ${VIDEO_BITRATE}k \
@@ -1,14 +1,24 @@
 package main
 
 import (
+	"bufio"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
 	"encoding/json"
+	"fmt"
+	"io"
 	"log"
 	"net/http"
 	"os"
 	"os/exec"
 	"os/signal"
+	"path/filepath"
+	"regexp"
+	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
 )
@@ -19,15 +29,99 @@ type Config struct {
 	VideoBitrate     int      `json:"video_bitrate"`
 	AudioBitrate     int      `json:"audio_bitrate"`
 	KeyframeInterval int      `json:"keyframe_interval"`
+	OutputFPS        int      `json:"output_fps"`
+	Preset           string   `json:"preset"`
+	Tune             string   `json:"tune"`
+	OBSReadTimeoutMs int      `json:"obs_rw_timeout_ms"` // How long the OBS pump waits on a stalled read before failing over
+	// Transcoder input probing: less starts the clean stream sooner, more
+	// copes with sources that are slow to reveal their streams
+	ProbeSizeKB       int `json:"probesize_kb"`
+	AnalyzeDurationMs int `json:"analyzeduration_ms"`
+	// Record the clean stream to recordingsDir in segments of this length
+	Record               bool `json:"record"`
+	RecordSegmentSeconds int  `json:"record_segment_seconds"`
+	// Destinations listed here get their own encode instead of a copy of the clean stream
+	Profiles map[string]DestProfile `json:"profiles"`
+	// When set, the transcoder also publishes each rung to its own SRS stream
+	Ladder []Rendition `json:"ladder"`
+}
+
+// Rendition is one rung of the ABR ladder. It is published as
+// abrStreamPrefix + Name for SRS to package alongside the others.
+type Rendition struct {
+	Name         string `json:"name"`       // Stream suffix, e.g. "720p"
+	Resolution   string `json:"resolution"` // WIDTHxHEIGHT
+	VideoBitrate int    `json:"video_bitrate"`
+	AudioBitrate int    `json:"audio_bitrate"` // 0 = 128
+}
+
+// DestProfile overrides the encode for one destination. Zero values fall back
+// to the channel settings.
+type DestProfile struct {
+	Resolution   string `json:"resolution"` // WIDTHxHEIGHT
+	VideoBitrate int    `json:"video_bitrate"`
+	AudioBitrate int    `json:"audio_bitrate"`
+}
+
+var validPresets = map[string]bool{
+	"ultrafast": true, "superfast": true, "veryfast": true, "faster": true, "fast": true,
+	"medium": true, "slow": true, "slower": true, "veryslow": true, "placebo": true,
+}
+
+var validTunes = map[string]bool{
+	"film": true, "animation": true, "grain": true, "stillimage": true,
+	"fastdecode": true, "zerolatency": true, "psnr": true, "ssim": true,
 }
 
 type SRSStreamsResponse struct {
-	Streams []struct {
-		Name    string `json:"name"`
-		Publish struct {
-			Active bool `json:"active"`
-		} `json:"publish"`
-	} `json:"streams"`
+	Streams []SRSStream `json:"streams"`
+}
+
+type SRSStream struct {
+	Name    string `json:"name"`
+	Publish struct {
+		Active bool `json:"active"`
+	} `json:"publish"`
+	Video *struct {
+		Codec   string `json:"codec"`
+		Profile string `json:"profile"`
+		Width   int    `json:"width"`
+		Height  int    `json:"height"`
+	} `json:"video"`
+	Audio *struct {
+		Codec      string `json:"codec"`
+		SampleRate int    `json:"sample_rate"`
+		Channel    int    `json:"channel"`
+	} `json:"audio"`
+}
+
+// sourceParams are the stream parameters the transcoder's decoder settles on
+// when it starts. A source with different ones mid-run can stall it.
+type sourceParams struct {
+	VideoCodec string
+	Profile    string
+	Width      int
+	Height     int
+	AudioCodec string
+	SampleRate int
+	Channels   int
+}
+
+func (p sourceParams) String() string {
+	return fmt.Sprintf("%s/%s %dx%d, %s %dHz %dch", p.VideoCodec, p.Profile, p.Width, p.Height, p.AudioCodec, p.SampleRate, p.Channels)
+}
+
+// paramsOf reads a stream's parameters from SRS. ok is false until SRS has
+// parsed the stream's video metadata.
+func paramsOf(s SRSStream) (sourceParams, bool) {
+	if s.Video == nil || s.Video.Width == 0 {
+		return sourceParams{}, false
+	}
+	p := sourceParams{VideoCodec: s.Video.Codec, Profile: s.Video.Profile, Width: s.Video.Width, Height: s.Video.Height}
+	if s.Audio != nil {
+		p.AudioCodec, p.SampleRate, p.Channels = s.Audio.Codec, s.Audio.SampleRate, s.Audio.Channel
+	}
+	return p, true
 }
 
 var (
@@ -35,31 +129,137 @@ var (
 	mu            sync.Mutex
 
 	// Pumps
-	loopCmd *exec.Cmd
-	obsCmd  *exec.Cmd
+	loopCmd    *exec.Cmd
+	obsCmd     *exec.Cmd
+	obsPumpGen int // Bumped by each startOBSPump so a replaced pump stops reconnecting
 
 	transcoderCmd *exec.Cmd
-	distributors  = make(map[string]*exec.Cmd)
-	destMu        sync.Mutex
+	recorderCmd   *exec.Cmd
+
+	// Transcoder stopped because every destination has been down for
+	// ALL_DOWN_PAUSE_SECONDS (guarded by mu)
+	transcoderPaused bool
+	pausedAt         time.Time
+	resumedAt        time.Time
+	distributors     = make(map[string]*exec.Cmd)
+	destMu           sync.Mutex
 
 	// Muxing
-	modeMutex   sync.RWMutex
-	currentMode string = "LOOP" // "LOOP" or "OBS"
-	streamChan         = make(chan []byte, 100)
+	modeMutex     sync.RWMutex
+	currentMode   string      = "LOOP" // "LOOP" or "OBS"
+	streamChan    chan []byte          // Sized from STREAM_BUFFER_CHUNKS at startup
+	droppedChunks atomic.Uint64
 
 	// Backoff Tracking
 	failureCounts = make(map[string]int)
+	failingSince  = make(map[string]time.Time) // Start of each distributor's current failure streak
+	distStartedAt = make(map[string]time.Time) // Start of each distributor's current FFmpeg process
+	restartWanted = make(map[string]bool)      // Distributors killed on request; restart without backoff
 	failureMu     sync.Mutex
 
+	// Parameters of the source last fed to the transcoder (guarded by mu)
+	pipeParams sourceParams
+
+	// Transcoder progress, parsed from FFmpeg's -progress output
+	progressMu      sync.Mutex
+	transcoderFPS   float64
+	transcoderKbps  float64
+	transcoderSpeed float64
+
 	pipePath    = "/tmp/stream_pipe"
 	pipeWriter  *os.File
-	cleanStream = "rtmp://srs:1935/live/relay_clean"
-	loopStream  = "rtmp://srs:1935/live/waheguru"
+	srsApp      = "live"                             // Overridden from SRS_APP at startup
+	cleanStream = "rtmp://srs:1935/live/relay_clean" // Overridden from CHANNEL_NAME at startup
+	loopStream  = "rtmp://srs:1935/live/waheguru"    // Overridden from CHANNEL_NAME at startup
+	abrPrefix   = "rtmp://srs:1935/live/abr_"        // Overridden from CHANNEL_NAME at startup
+	apiSecret   string                               // RELAY_API_SECRET; required on control endpoints when set
+
+	recordingsDir = "/recordings" // Bind-mounted by the controller, one directory per channel
+)
+
+// recordingLayout names recording segments by their UTC start time. The
+// controller parses the same layout back out of the file names.
+const recordingLayout = "%Y%m%dT%H%M%SZ"
+
+// channelLoopURL returns the loop publisher's output for a channel, which is
+// what the relay treats as its LOOP source.
+func channelLoopURL(channel string) string {
+	return "rtmp://srs:1935/" + srsApp + "/" + channel
+}
+
+var (
+	tokenParamPattern = regexp.MustCompile(`((?:token|key)=)[^&\s"']+`)
+	rtmpKeyPattern    = regexp.MustCompile(`(rtmps?://[^/\s"']+/[^\s"'?]+/)[^/\s"'?]+`)
 )
 
+// redactSecrets masks stream keys (the last path segment of an RTMP URL) and
+// token=/key= values before destination URLs reach the logs.
+func redactSecrets(s string) string {
+	s = tokenParamPattern.ReplaceAllString(s, "${1}****")
+	return rtmpKeyPattern.ReplaceAllString(s, "${1}****")
+}
+
+// redactingWriter passes FFmpeg output through redactSecrets. FFmpeg writes
+// whole lines, so a URL isn't split across writes in practice.
+type redactingWriter struct{ w io.Writer }
+
+func (r redactingWriter) Write(p []byte) (int, error) {
+	if _, err := io.WriteString(r.w, redactSecrets(string(p))); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// cleanStreamName is the stream a channel's transcoder publishes and its
+// distributors read. It must differ per channel: relays sharing SRS would
+// otherwise publish over each other.
+func cleanStreamName(channel string) string {
+	if channel == "" {
+		return "relay_clean"
+	}
+	return channel + "_relay_clean"
+}
+
+// abrStreamPrefix is prepended to a rung name to form the stream that rung is
+// published to, e.g. "main_abr_720p".
+func abrStreamPrefix(channel string) string {
+	if channel == "" {
+		return "abr_"
+	}
+	return channel + "_abr_"
+}
+
 func main() {
 	log.Println("[RELAY] Starting Relay Manager v27 (Pure Seamless Failover)...")
 
+	if app := os.Getenv("SRS_APP"); app != "" {
+		srsApp = app
+	}
+	channel := os.Getenv("CHANNEL_NAME")
+	if channel != "" {
+		loopStream = channelLoopURL(channel)
+	}
+	cleanStream = "rtmp://srs:1935/" + srsApp + "/" + cleanStreamName(channel)
+	abrPrefix = "rtmp://srs:1935/" + srsApp + "/" + abrStreamPrefix(channel)
+	log.Printf("[RELAY] Loop source: %s", loopStream)
+	log.Printf("[RELAY] Clean stream: %s", cleanStream)
+
+	// A live publisher on our clean stream means another relay owns it.
+	// Give a replaced container a few seconds to drop its publish first.
+	for attempt := 1; ; attempt++ {
+		active, err := srsPublishing(&http.Client{Timeout: 2 * time.Second}, cleanStreamName(channel))
+		if err != nil || !active {
+			break
+		}
+		if attempt == 10 {
+			log.Fatalf("[RELAY] %s already has an active publisher; refusing to start a second relay for it", cleanStream)
+		}
+		time.Sleep(1 * time.Second)
+	}
+
+	streamChan = make(chan []byte, streamBufferSize())
+	log.Printf("[RELAY] Stream buffer: %d chunks", cap(streamChan))
+
 	os.Remove(pipePath)
 	if err := syscall.Mkfifo(pipePath, 0666); err != nil {
 		log.Fatalf("Failed to create pipe: %v", err)
@@ -80,8 +280,18 @@ func main() {
 	// Start Loop Pump (Always Running)
 	go loopPumpLoop()
 
-	http.HandleFunc("/update", handleUpdate)
-	http.HandleFunc("/status", handleStatus)
+	go recorderLoop()
+	go watchAllDown()
+
+	apiSecret = os.Getenv("RELAY_API_SECRET")
+	if apiSecret == "" {
+		log.Println("[RELAY] Warning: RELAY_API_SECRET not set, control API is unauthenticated")
+	}
+	http.HandleFunc("/update", requireSecret(handleUpdate))
+	http.HandleFunc("/status", requireSecret(handleStatus))
+	http.HandleFunc("/metrics", handleMetrics)
+	http.HandleFunc("/healthz", handleHealthz)
+	http.HandleFunc("/distributor/restart", requireSecret(handleDistributorRestart))
 	go func() {
 		log.Println("[RELAY] Listening on :8080")
 		log.Fatal(http.ListenAndServe(":8080", nil))
@@ -105,6 +315,28 @@ func main() {
 	cleanup()
 }
 
+// streamBufferSize reads STREAM_BUFFER_CHUNKS (32KB chunks, default 100).
+func streamBufferSize() int {
+	if n, err := strconv.Atoi(os.Getenv("STREAM_BUFFER_CHUNKS")); err == nil && n > 0 {
+		return n
+	}
+	return 100
+}
+
+// sendChunk hands a pump's output to the pipe writer without blocking. If the
+// transcoder is lagging and the buffer is full the chunk is dropped: a short
+// glitch downstream is better than stalling the pump and letting FFmpeg's
+// input back up until the source times out.
+func sendChunk(data []byte) {
+	select {
+	case streamChan <- data:
+	default:
+		if n := droppedChunks.Add(1); n == 1 || n%100 == 0 {
+			log.Printf("[RELAY] Stream buffer full, dropped %d chunks so far", n)
+		}
+	}
+}
+
 func pipeWriterLoop() {
 	for b := range streamChan {
 		if _, err := pipeWriter.Write(b); err != nil {
@@ -117,14 +349,7 @@ func loopPumpLoop() {
 	for {
 		log.Println("[RELAY] Starting Loop Pump (Background)")
 
-		args := []string{
-			"-hide_banner", "-loglevel", "error",
-			"-re", "-i", loopStream,
-			"-c", "copy", "-bsf:v", "h264_mp4toannexb",
-			"-flush_packets", "1",
-			"-f", "mpegts", "pipe:1",
-		}
-		cmd := exec.Command("ffmpeg", args...)
+		cmd := exec.Command("ffmpeg", loopPumpArgs(loopStream)...)
 		stdout, err := cmd.StdoutPipe()
 		if err != nil {
 			time.Sleep(100 * time.Millisecond)
@@ -153,7 +378,7 @@ func loopPumpLoop() {
 			if active {
 				data := make([]byte, n)
 				copy(data, buf[:n])
-				streamChan <- data
+				sendChunk(data)
 			}
 		}
 		cmd.Wait()
@@ -161,6 +386,33 @@ func loopPumpLoop() {
 	}
 }
 
+// loopPumpArgs builds the FFmpeg arguments that copy the loop source into the pipe.
+func loopPumpArgs(source string) []string {
+	return []string{
+		"-hide_banner", "-loglevel", "error",
+		"-re", "-i", source,
+		"-c", "copy", "-bsf:v", "h264_mp4toannexb",
+		"-flush_packets", "1",
+		"-f", "mpegts", "pipe:1",
+	}
+}
+
+// obsPumpArgs builds the FFmpeg arguments that copy the OBS stream into the
+// pipe. timeoutMs bounds how long a stalled read blocks before FFmpeg exits and
+// the relay fails over to the loop.
+func obsPumpArgs(url string, timeoutMs int) []string {
+	if timeoutMs <= 0 {
+		timeoutMs = 5000
+	}
+	return []string{
+		"-hide_banner", "-loglevel", "error",
+		"-rw_timeout", strconv.Itoa(timeoutMs * 1000), // microseconds
+		"-i", url,
+		"-c", "copy", "-bsf:v", "h264_mp4toannexb",
+		"-f", "mpegts", "pipe:1",
+	}
+}
+
 func restartLoopPump() {
 	mu.Lock()
 	if loopCmd != nil && loopCmd.Process != nil {
@@ -177,66 +429,153 @@ func startOBSPump(url string) {
 		syscall.Kill(-obsCmd.Process.Pid, syscall.SIGKILL)
 		time.Sleep(100 * time.Millisecond)
 	}
+	obsPumpGen++
+	gen := obsPumpGen
+	timeoutMs := currentConfig.OBSReadTimeoutMs
 	mu.Unlock()
 
 	go func() {
-		log.Printf("[RELAY] Starting OBS Pump: %s", url)
-		cmd := exec.Command("ffmpeg", "-hide_banner", "-loglevel", "error", "-rw_timeout", "5000000", "-i", url, "-c", "copy", "-bsf:v", "h264_mp4toannexb", "-f", "mpegts", "pipe:1")
-		cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
-		stdout, err := cmd.StdoutPipe()
-		if err != nil {
-			log.Printf("[RELAY] OBS Pump Pipe Error: %v", err)
-			triggerFailover("ObsPipeError")
-			return
-		}
-		if err := cmd.Start(); err != nil {
-			log.Printf("[RELAY] OBS Pump Start Error: %v", err)
-			triggerFailover("ObsStartError")
-			return
+		log.Printf("[RELAY] Starting OBS Pump: %s", redactSecrets(url))
+		reason := superviseOBSPump(gen, url, obsReconnectWindow(), func(giveUpAt time.Time) (bool, string) {
+			return runOBSPump(gen, url, timeoutMs, giveUpAt)
+		})
+		if reason != "" {
+			triggerFailover(reason)
 		}
+	}()
+}
 
-		mu.Lock()
-		obsCmd = cmd
-		mu.Unlock()
+// obsPumpWanted reports whether pump generation gen is still the current one
+// and url is still the configured source.
+func obsPumpWanted(gen int, url string) bool {
+	mu.Lock()
+	defer mu.Unlock()
+	return obsPumpGen == gen && currentConfig.SourceURL == url
+}
 
-		// Immediately make active if intended
-		mu.Lock()
-		isTarget := currentConfig.SourceURL == url
-		mu.Unlock()
+// superviseOBSPump runs attempt until it is superseded or OBS stays away for
+// window, reconnecting in between. It returns the reason to fail over, or ""
+// when no failover is due (the pump was replaced or OBS wasn't on air).
+func superviseOBSPump(gen int, url string, window time.Duration, attempt func(giveUpAt time.Time) (bool, string)) string {
+	var droppedAt time.Time
+	for {
+		// A newer pump (or a source change) may have taken over while we slept
+		if !obsPumpWanted(gen, url) {
+			return ""
+		}
+		giveUpAt := time.Time{}
+		if !droppedAt.IsZero() {
+			giveUpAt = droppedAt.Add(window)
+		}
+		received, reason := attempt(giveUpAt)
+		log.Printf("[RELAY] OBS Pump Exited (%s)", reason)
 
-		if isTarget {
-			switchMode("OBS")
+		// Failover only if was active and this pump is still the one wanted
+		modeMutex.RLock()
+		wasActive := (currentMode == "OBS")
+		modeMutex.RUnlock()
+		if !wasActive || !obsPumpWanted(gen, url) {
+			return ""
 		}
 
-		buf := make([]byte, 32*1024)
-		for {
-			n, err := stdout.Read(buf)
-			if err != nil {
-				break
+		if received || droppedAt.IsZero() {
+			droppedAt = time.Now()
+		}
+		left := window - time.Since(droppedAt)
+		if left <= 0 {
+			return reason
+		}
+		log.Printf("[RELAY] Reconnecting OBS Pump (%s left before failover)", left.Round(100*time.Millisecond))
+		time.Sleep(obsReconnectDelay)
+	}
+}
+
+// obsReconnectDelay spaces reconnect attempts while OBS is unreachable.
+const obsReconnectDelay = 500 * time.Millisecond
+
+// obsReconnectWindow reads OBS_RECONNECT_SECONDS (default 3): how long the OBS
+// pump keeps reconnecting after FFmpeg exits before failing over to the loop.
+// 0 fails over immediately.
+func obsReconnectWindow() time.Duration {
+	if n, err := strconv.Atoi(os.Getenv("OBS_RECONNECT_SECONDS")); err == nil && n >= 0 {
+		return time.Duration(n) * time.Second
+	}
+	return 3 * time.Second
+}
+
+// runOBSPump copies the OBS stream into the pipe until FFmpeg exits, and
+// reports whether any data arrived along with the failover reason to use. An
+// attempt that has received nothing by giveUpAt (when set) is killed, so a
+// reconnect never outlasts the window even with a long rw_timeout.
+func runOBSPump(gen int, url string, timeoutMs int, giveUpAt time.Time) (bool, string) {
+	cmd := exec.Command("ffmpeg", obsPumpArgs(url, timeoutMs)...)
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		log.Printf("[RELAY] OBS Pump Pipe Error: %v", err)
+		return false, "ObsPipeError"
+	}
+	if err := cmd.Start(); err != nil {
+		log.Printf("[RELAY] OBS Pump Start Error: %v", err)
+		return false, "ObsStartError"
+	}
+
+	// Only the current generation may own obsCmd; a stale attempt must not
+	// clobber (and so leak) the newer pump's process
+	mu.Lock()
+	if obsPumpGen != gen {
+		mu.Unlock()
+		syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+		cmd.Wait()
+		return false, "ObsSuperseded"
+	}
+	obsCmd = cmd
+	mu.Unlock()
+
+	var received atomic.Bool
+	if !giveUpAt.IsZero() {
+		t := time.AfterFunc(time.Until(giveUpAt), func() {
+			if !received.Load() {
+				syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
 			}
+		})
+		defer t.Stop()
+	}
 
-			modeMutex.RLock()
-			active := (currentMode == "OBS")
-			modeMutex.RUnlock()
+	// Immediately make active if intended
+	mu.Lock()
+	isTarget := currentConfig.SourceURL == url
+	mu.Unlock()
 
-			if active {
-				data := make([]byte, n)
-				copy(data, buf[:n])
-				streamChan <- data
+	if isTarget {
+		switchMode("OBS")
+	}
+
+	buf := make([]byte, 32*1024)
+	for {
+		n, err := stdout.Read(buf)
+		if err != nil {
+			break
+		}
+		if !received.Load() {
+			if !giveUpAt.IsZero() {
+				log.Println("[RELAY] OBS Pump Reconnected")
 			}
+			received.Store(true)
 		}
-		cmd.Wait()
-		log.Println("[RELAY] OBS Pump Exited")
 
-		// Failover only if was active
 		modeMutex.RLock()
-		wasActive := (currentMode == "OBS")
+		active := (currentMode == "OBS")
 		modeMutex.RUnlock()
 
-		if wasActive {
-			triggerFailover("ObsProcessExit")
+		if active {
+			data := make([]byte, n)
+			copy(data, buf[:n])
+			sendChunk(data)
 		}
-	}()
+	}
+	cmd.Wait()
+	return received.Load(), "ObsProcessExit"
 }
 
 func triggerFailover(reason string) {
@@ -263,12 +602,67 @@ func switchMode(mode string) {
 	defer modeMutex.Unlock()
 	currentMode = mode
 	log.Printf("[RELAY] Muxer Mode: %s", mode)
+	go checkSourceParams(mode)
+}
+
+// checkSourceParams compares the parameters of the source now feeding the
+// pipe with those the transcoder was last fed. The pumps copy rather than
+// re-encode, so a new resolution or profile reaches the transcoder as-is; it
+// is restarted rather than left to stall on it. A freshly connected OBS
+// stream is polled briefly while SRS parses its metadata.
+func checkSourceParams(mode string) {
+	src := loopStream
+	if mode == "OBS" {
+		mu.Lock()
+		src = currentConfig.SourceURL
+		mu.Unlock()
+	}
+	// An external source isn't in SRS, so there is nothing to look up
+	if !strings.Contains(src, "srs:1935") {
+		return
+	}
+	name, _, _ := strings.Cut(src[strings.LastIndex(src, "/")+1:], "?")
+
+	client := &http.Client{Timeout: 2 * time.Second}
+	for attempt := 0; attempt < 5; attempt++ {
+		if attempt > 0 {
+			time.Sleep(1 * time.Second)
+		}
+		s, found, err := srsStream(client, name)
+		if err != nil || !found {
+			continue
+		}
+		p, ok := paramsOf(s)
+		if !ok {
+			continue
+		}
+
+		modeMutex.RLock()
+		stillActive := currentMode == mode
+		modeMutex.RUnlock()
+		if !stillActive {
+			return
+		}
+
+		mu.Lock()
+		prev := pipeParams
+		pipeParams = p
+		mu.Unlock()
+		if prev != (sourceParams{}) && prev != p {
+			log.Printf("[RELAY] Source parameters changed on %s (%s -> %s)", mode, prev, p)
+			restartTranscoder("source parameters changed")
+		}
+		return
+	}
 }
 
 func monitorSRS() {
 	client := &http.Client{Timeout: 2 * time.Second}
-	log.Println("[Tracker] SRS Stream Monitoring (v27)")
+	lostPolls := srsLostPolls()
+	log.Printf("[Tracker] SRS Stream Monitoring (v27), failover after %d missed polls", lostPolls)
 
+	misses := missCounter{limit: lostPolls}
+	lastSrc := ""
 	for {
 		time.Sleep(1 * time.Second)
 
@@ -276,6 +670,10 @@ func monitorSRS() {
 		src := currentConfig.SourceURL
 		mu.Unlock()
 
+		if src != lastSrc {
+			misses.reset()
+			lastSrc = src
+		}
 		if src == loopStream {
 			continue
 		}
@@ -289,30 +687,98 @@ func monitorSRS() {
 		}
 		streamName := parts[len(parts)-1]
 
-		resp, err := client.Get("http://srs:1985/api/v1/streams")
+		found, err := srsPublishing(client, streamName)
 		if err != nil {
 			continue
 		}
 
-		var srsResp SRSStreamsResponse
-		if err := json.NewDecoder(resp.Body).Decode(&srsResp); err != nil {
-			resp.Body.Close()
+		if found {
+			misses.reset()
 			continue
 		}
-		resp.Body.Close()
-
-		found := false
-		for _, s := range srsResp.Streams {
-			if s.Name == streamName && s.Publish.Active {
-				found = true
-				break
-			}
+		// A publisher reconnecting or one slow SRS answer shouldn't cut to loop
+		if !misses.miss() {
+			log.Printf("[Tracker] %s not publishing (%d/%d)", streamName, misses.n, lostPolls)
+			continue
 		}
+		triggerFailover("TrackerLost" + streamName)
+	}
+}
+
+// missCounter counts consecutive polls a source was missing from SRS.
+type missCounter struct {
+	limit int
+	n     int
+}
+
+// miss records a poll without the source and reports whether that makes limit
+// in a row, starting the count over if so.
+func (m *missCounter) miss() bool {
+	m.n++
+	if m.n < m.limit {
+		return false
+	}
+	m.n = 0
+	return true
+}
+
+func (m *missCounter) reset() { m.n = 0 }
+
+// srsLostPolls reads SRS_LOST_POLLS, the consecutive polls a source must be
+// missing from SRS before failing over to loop (default 3).
+func srsLostPolls() int {
+	if n, err := strconv.Atoi(os.Getenv("SRS_LOST_POLLS")); err == nil && n > 0 {
+		return n
+	}
+	return 3
+}
+
+// srsPublishing reports whether SRS has an active publisher for a stream.
+func srsPublishing(client *http.Client, streamName string) (bool, error) {
+	s, found, err := srsStream(client, streamName)
+	return found && s.Publish.Active, err
+}
 
+// srsStream looks a stream up by name in SRS's stream list.
+func srsStream(client *http.Client, streamName string) (SRSStream, bool, error) {
+	resp, err := client.Get("http://srs:1985/api/v1/streams")
+	if err != nil {
+		return SRSStream{}, false, err
+	}
+	defer resp.Body.Close()
+
+	var srsResp SRSStreamsResponse
+	if err := json.NewDecoder(resp.Body).Decode(&srsResp); err != nil {
+		return SRSStream{}, false, err
+	}
+	// Prefer the entry with an active publisher if the name appears twice
+	var match SRSStream
+	found := false
+	for _, s := range srsResp.Streams {
+		if s.Name != streamName {
+			continue
+		}
+		if s.Publish.Active {
+			return s, true, nil
+		}
 		if !found {
-			triggerFailover("TrackerLost" + streamName)
+			match, found = s, true
 		}
 	}
+	return match, found, nil
+}
+
+// requireSecret rejects requests without the controller's X-Relay-Secret, so
+// nothing else on the Docker network can repoint this channel's output.
+func requireSecret(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if apiSecret != "" && subtle.ConstantTimeCompare([]byte(r.Header.Get("X-Relay-Secret")), []byte(apiSecret)) != 1 {
+			log.Printf("[RELAY] Rejected unauthenticated %s %s from %s", r.Method, r.URL.Path, r.RemoteAddr)
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		h(w, r)
+	}
 }
 
 func handleUpdate(w http.ResponseWriter, r *http.Request) {
@@ -322,6 +788,14 @@ func handleUpdate(w http.ResponseWriter, r *http.Request) {
 	}
 	var newConfig Config
 	json.NewDecoder(r.Body).Decode(&newConfig)
+	if newConfig.Preset != "" && !validPresets[newConfig.Preset] {
+		http.Error(w, "unknown x264 preset: "+newConfig.Preset, http.StatusBadRequest)
+		return
+	}
+	if newConfig.Tune != "" && newConfig.Tune != "none" && !validTunes[newConfig.Tune] {
+		http.Error(w, "unknown x264 tune: "+newConfig.Tune, http.StatusBadRequest)
+		return
+	}
 	handleConfigChange(newConfig)
 	w.WriteHeader(http.StatusOK)
 }
@@ -333,30 +807,68 @@ func handleStatus(w http.ResponseWriter, r *http.Request) {
 	defer destMu.Unlock()
 	dests := []map[string]interface{}{}
 	for url, cmd := range distributors {
-		running := cmd != nil && cmd.ProcessState == nil
-		dests = append(dests, map[string]interface{}{"url": url, "running": running})
+		running := cmd != nil
+		failureMu.Lock()
+		fails := failureCounts[url]
+		failureMu.Unlock()
+		d := map[string]interface{}{"url": url, "running": running, "failures": fails}
+		if since, failing := distFailingSince(url, running); failing {
+			d["failing_since"] = since.Format(time.RFC3339)
+		}
+		dests = append(dests, d)
 	}
 	modeMutex.RLock()
 	mode := currentMode
 	modeMutex.RUnlock()
 	status := map[string]interface{}{
-		"source":             currentConfig.SourceURL,
-		"mode":               mode,
-		"destinations":       dests,
-		"transcoder_running": transcoderCmd != nil && transcoderCmd.ProcessState == nil,
+		"source":                currentConfig.SourceURL,
+		"mode":                  mode,
+		"destinations":          dests,
+		"transcoder_running":    transcoderCmd != nil && transcoderCmd.ProcessState == nil,
+		"recording":             recorderCmd != nil,
+		"all_destinations_down": transcoderPaused,
+		"dropped_chunks":        droppedChunks.Load(),
+		"stream_buffer":         cap(streamChan),
 	}
 	json.NewEncoder(w).Encode(status)
 }
 
+// handleHealthz reports whether the relay can take an /update: the pipe is
+// held open and the transcoder is running (or deliberately paused because
+// every destination is down). Not ready answers 503 with the reason, so the
+// controller can wait for a new relay instead of posting into its startup.
+func handleHealthz(w http.ResponseWriter, r *http.Request) {
+	mu.Lock()
+	transcoderUp := transcoderCmd != nil && transcoderCmd.Process != nil && transcoderCmd.ProcessState == nil
+	paused := transcoderPaused
+	mu.Unlock()
+
+	reason := ""
+	switch {
+	case pipeWriter == nil:
+		reason = "pipe not open"
+	case !transcoderUp && !paused:
+		reason = "transcoder not running"
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if reason != "" {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		json.NewEncoder(w).Encode(map[string]interface{}{"ready": false, "reason": reason})
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{"ready": true})
+}
+
 func handleConfigChange(newConfig Config) {
 	mu.Lock()
 	sourceChanged := newConfig.SourceURL != currentConfig.SourceURL
 	oldSrc := currentConfig.SourceURL
+	oldConfig := currentConfig
 	currentConfig = newConfig
 	mu.Unlock()
 
 	if sourceChanged {
-		log.Printf("[RELAY] Source Change: %s -> %s", oldSrc, newConfig.SourceURL)
+		log.Printf("[RELAY] Source Change: %s -> %s", redactSecrets(oldSrc), redactSecrets(newConfig.SourceURL))
 		if newConfig.SourceURL == loopStream {
 			switchMode("LOOP")
 		} else {
@@ -365,34 +877,148 @@ func handleConfigChange(newConfig Config) {
 		}
 	}
 
+	if !sameDestinations(oldConfig.Destinations, newConfig.Destinations) {
+		resumeTranscoder("destinations changed")
+	}
 	if transcoderCmd == nil || transcoderCmd.ProcessState != nil {
 		startTranscoderProcess()
+	} else if encodingChanged(oldConfig, newConfig) {
+		restartTranscoder("encoding settings changed")
 	}
 	manageDistributors(newConfig.Destinations)
+	if oldConfig.Record != newConfig.Record || strings.Join(recorderArgs(oldConfig), " ") != strings.Join(recorderArgs(newConfig), " ") {
+		restartRecorder("recording settings changed")
+	}
+
+	// Distributors read their args when (re)started, so one whose profile
+	// changed just needs a restart
+	existing := make(map[string]bool)
+	for _, d := range oldConfig.Destinations {
+		existing[d] = true
+	}
+	for _, d := range newConfig.Destinations {
+		if existing[d] && strings.Join(distributorArgs(oldConfig, d), " ") != strings.Join(distributorArgs(newConfig, d), " ") {
+			restartDistributor(d)
+		}
+	}
+}
+
+// encodingChanged reports whether two configs need a different transcoder command.
+// Comparing the built args means defaults (0 vs 30fps) don't cause restarts.
+func encodingChanged(a, b Config) bool {
+	return strings.Join(transcoderArgs(a), " ") != strings.Join(transcoderArgs(b), " ")
+}
+
+// restartTranscoder kills the transcoder; its watcher goroutine starts a fresh
+// one with the current config.
+func restartTranscoder(reason string) {
+	mu.Lock()
+	cmd := transcoderCmd
+	mu.Unlock()
+	if cmd != nil && cmd.Process != nil {
+		log.Printf("[RELAY] Restarting Transcoder (%s)", reason)
+		syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+	}
+}
+
+// recorderLoop keeps a recorder running while recording is on. FFmpeg exits
+// whenever the clean stream drops, so it is simply started again; a settings
+// change stops it and the next pass picks up the new config.
+func recorderLoop() {
+	for {
+		mu.Lock()
+		cfg := currentConfig
+		mu.Unlock()
+		if !cfg.Record {
+			time.Sleep(1 * time.Second)
+			continue
+		}
+
+		cmd := exec.Command("ffmpeg", recorderArgs(cfg)...)
+		cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+		cmd.Env = append(os.Environ(), "TZ=UTC") // Segment names are UTC
+		cmd.Stdout = os.Stdout
+		cmd.Stderr = os.Stderr
+		if err := cmd.Start(); err != nil {
+			log.Printf("[RELAY] Recorder failed to start: %v", err)
+			time.Sleep(5 * time.Second)
+			continue
+		}
+		log.Printf("[RELAY] Recording %s to %s", cleanStream, recordingsDir)
+		mu.Lock()
+		recorderCmd = cmd
+		mu.Unlock()
+		cmd.Wait()
+		mu.Lock()
+		recorderCmd = nil
+		mu.Unlock()
+		time.Sleep(2 * time.Second)
+	}
+}
+
+// restartRecorder stops the recorder; recorderLoop starts it again if
+// recording is still on. SIGINT lets FFmpeg finalize the open MP4 segment.
+func restartRecorder(reason string) {
+	mu.Lock()
+	cmd := recorderCmd
+	mu.Unlock()
+	if cmd != nil && cmd.Process != nil {
+		log.Printf("[RELAY] Stopping Recorder (%s)", reason)
+		syscall.Kill(-cmd.Process.Pid, syscall.SIGINT)
+	}
+}
+
+// recorderArgs builds the clean stream -> segmented MP4 FFmpeg command. Each
+// segment starts on a keyframe and gets its own zero-based timestamps, so any
+// file plays on its own.
+func recorderArgs(cfg Config) []string {
+	segment := cfg.RecordSegmentSeconds
+	if segment <= 0 {
+		segment = 3600
+	}
+	return []string{
+		"-hide_banner", "-loglevel", "warning",
+		"-i", cleanStream,
+		"-map", "0", "-c", "copy",
+		"-f", "segment", "-segment_time", strconv.Itoa(segment),
+		"-segment_format", "mp4", "-segment_format_options", "movflags=+faststart",
+		"-reset_timestamps", "1", "-strftime", "1",
+		filepath.Join(recordingsDir, recordingLayout+".mp4"),
+	}
 }
 
 func startTranscoderProcess() {
 	if transcoderCmd != nil && transcoderCmd.Process != nil {
 		return
 	}
-	log.Println("[RELAY] Starting Transcoder (Pipe -> SRS Clean)")
-	args := []string{
-		"-hide_banner", "-loglevel", "warning",
-		"-f", "mpegts", "-probesize", "32M", "-analyzeduration", "100000",
-		"-i", pipePath,
-		"-c:v", "libx264", "-preset", "ultrafast", "-tune", "zerolatency",
-		"-b:v", "4000k", "-maxrate", "4000k", "-bufsize", "8000k", "-pix_fmt", "yuv420p",
-		"-g", "60", "-keyint_min", "60", "-sc_threshold", "0",
-		"-c:a", "aac", "-b:a", "128k", "-ac", "2",
-		"-f", "flv", cleanStream,
+	mu.Lock()
+	cfg := currentConfig
+	paused := transcoderPaused
+	mu.Unlock()
+	if paused {
+		return
 	}
-	cmd := exec.Command("ffmpeg", args...)
+	log.Println("[RELAY] Starting Transcoder (Pipe -> SRS Clean)")
+	cmd := exec.Command("ffmpeg", transcoderArgs(cfg)...)
 	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
-	cmd.Stdout = os.Stdout
 	cmd.Stderr = os.Stderr
+	var progressDone chan struct{}
+	if stdout, err := cmd.StdoutPipe(); err == nil {
+		progressDone = make(chan struct{})
+		go func() {
+			readTranscoderProgress(stdout)
+			close(progressDone)
+		}()
+	} else {
+		cmd.Stdout = os.Stdout
+	}
 	cmd.Start()
 	transcoderCmd = cmd
 	go func() {
+		// Wait closes the pipe, so let the reader reach EOF first
+		if progressDone != nil {
+			<-progressDone
+		}
 		cmd.Wait()
 		log.Println("[RELAY] Transcoder exited")
 		time.Sleep(500 * time.Millisecond)
@@ -405,6 +1031,373 @@ func startTranscoderProcess() {
 	}()
 }
 
+// formatDuration renders ms as e.g. "2d 6h 15m" or "42s", leaving out zero
+// units. Seconds are dropped once a duration reaches a day.
+func formatDuration(ms int64) string {
+	if ms < 0 {
+		ms = 0
+	}
+	secs := ms / 1000
+	units := []struct {
+		n      int64
+		suffix string
+	}{
+		{secs / 86400, "d"},
+		{secs % 86400 / 3600, "h"},
+		{secs % 3600 / 60, "m"},
+		{secs % 60, "s"},
+	}
+	if units[0].n > 0 {
+		units = units[:3]
+	}
+
+	var parts []string
+	for _, u := range units {
+		if u.n > 0 {
+			parts = append(parts, fmt.Sprintf("%d%s", u.n, u.suffix))
+		}
+	}
+	if len(parts) == 0 {
+		return "0s"
+	}
+	return strings.Join(parts, " ")
+}
+
+// allDownPause reads ALL_DOWN_PAUSE_SECONDS: how long every destination must
+// have been failing before the transcoder is stopped to save CPU (0 = never).
+func allDownPause() time.Duration {
+	if n, err := strconv.Atoi(os.Getenv("ALL_DOWN_PAUSE_SECONDS")); err == nil && n > 0 {
+		return time.Duration(n) * time.Second
+	}
+	return 0
+}
+
+// allDownRetry reads ALL_DOWN_RETRY_SECONDS (default 60), how long a paused
+// transcoder stays stopped before it is restarted to give destinations
+// another try. Distributors read the clean stream, so none can recover while
+// the transcoder is paused.
+func allDownRetry() time.Duration {
+	if n, err := strconv.Atoi(os.Getenv("ALL_DOWN_RETRY_SECONDS")); err == nil && n > 0 {
+		return time.Duration(n) * time.Second
+	}
+	return 60 * time.Second
+}
+
+// allDestinationsDown reports whether every destination has been failing for
+// at least threshold. No destinations means nothing is down.
+func allDestinationsDown(destinations []string, threshold time.Duration, now time.Time) bool {
+	if len(destinations) == 0 {
+		return false
+	}
+	for _, url := range destinations {
+		destMu.Lock()
+		cmd := distributors[url]
+		destMu.Unlock()
+		since, failing := distFailingSince(url, cmd != nil)
+		if !failing || now.Sub(since) < threshold {
+			return false
+		}
+	}
+	return true
+}
+
+// watchAllDown pauses the transcoder once every destination has been down
+// for ALL_DOWN_PAUSE_SECONDS and restarts it every ALL_DOWN_RETRY_SECONDS so
+// the distributors can try again. A resumed transcoder gets another full
+// threshold before it can be paused again. Recording keeps it running.
+func watchAllDown() {
+	threshold := allDownPause()
+	if threshold == 0 {
+		return
+	}
+	log.Printf("[RELAY] Transcoder pauses after all destinations are down for %s", formatDuration(threshold.Milliseconds()))
+	for range time.Tick(1 * time.Second) {
+		mu.Lock()
+		cfg := currentConfig
+		paused, pausedSince, resumedSince := transcoderPaused, pausedAt, resumedAt
+		mu.Unlock()
+
+		now := time.Now()
+		if paused {
+			if now.Sub(pausedSince) >= allDownRetry() {
+				resumeTranscoder("retrying destinations")
+			}
+			continue
+		}
+		if !cfg.Record && now.Sub(resumedSince) >= threshold && allDestinationsDown(cfg.Destinations, threshold, now) {
+			pauseTranscoder(threshold)
+		}
+	}
+}
+
+func pauseTranscoder(threshold time.Duration) {
+	mu.Lock()
+	transcoderPaused = true
+	pausedAt = time.Now()
+	cmd := transcoderCmd
+	mu.Unlock()
+	log.Printf("[RELAY] All destinations down for over %s, pausing transcoder", formatDuration(threshold.Milliseconds()))
+	if cmd != nil && cmd.Process != nil {
+		syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+	}
+}
+
+func resumeTranscoder(reason string) {
+	mu.Lock()
+	if !transcoderPaused {
+		mu.Unlock()
+		return
+	}
+	transcoderPaused = false
+	resumedAt = time.Now()
+	mu.Unlock()
+	log.Printf("[RELAY] Resuming transcoder (%s)", reason)
+	startTranscoderProcess()
+}
+
+// sameDestinations reports whether two destination lists hold the same URLs.
+func sameDestinations(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	set := make(map[string]bool, len(a))
+	for _, d := range a {
+		set[d] = true
+	}
+	for _, d := range b {
+		if !set[d] {
+			return false
+		}
+	}
+	return true
+}
+
+// transcoderArgs builds the pipe -> clean stream FFmpeg command. The GOP is
+// derived from fps * keyframe interval so keyframes land on whole seconds.
+// With a ladder the decoded video is split once and each rung is scaled and
+// encoded as an extra output, so all renditions share keyframe positions.
+func transcoderArgs(cfg Config) []string {
+	fps := cfg.OutputFPS
+	if fps <= 0 {
+		fps = 30
+	}
+	keyframeInterval := cfg.KeyframeInterval
+	if keyframeInterval <= 0 {
+		keyframeInterval = 2
+	}
+	gop := strconv.Itoa(fps * keyframeInterval)
+	videoKbps := cfg.VideoBitrate
+	if videoKbps <= 0 {
+		videoKbps = 4000
+	}
+	audioKbps := cfg.AudioBitrate
+	if audioKbps <= 0 {
+		audioKbps = 128
+	}
+	probeSizeKB := cfg.ProbeSizeKB
+	if probeSizeKB <= 0 {
+		probeSizeKB = 32000
+	}
+	analyzeDurationMs := cfg.AnalyzeDurationMs
+	if analyzeDurationMs <= 0 {
+		analyzeDurationMs = 100
+	}
+
+	// Unknown values fall back to the low-latency defaults rather than
+	// letting FFmpeg refuse to start.
+	preset := cfg.Preset
+	if !validPresets[preset] {
+		preset = "ultrafast"
+	}
+	var tune []string
+	switch {
+	case cfg.Tune == "none":
+	case validTunes[cfg.Tune]:
+		tune = []string{"-tune", cfg.Tune}
+	default:
+		tune = []string{"-tune", "zerolatency"}
+	}
+	encode := func(videoKbps, audioKbps int, out string) []string {
+		args := append([]string{"-c:v", "libx264", "-preset", preset}, tune...)
+		return append(args,
+			"-b:v", fmt.Sprintf("%dk", videoKbps), "-maxrate", fmt.Sprintf("%dk", videoKbps),
+			"-bufsize", fmt.Sprintf("%dk", videoKbps*2), "-pix_fmt", "yuv420p",
+			"-r", strconv.Itoa(fps), "-g", gop, "-keyint_min", gop, "-sc_threshold", "0",
+			"-c:a", "aac", "-b:a", fmt.Sprintf("%dk", audioKbps), "-ac", "2",
+			"-f", "flv", out,
+		)
+	}
+
+	args := []string{
+		"-hide_banner", "-loglevel", "warning", "-progress", "pipe:1",
+		"-f", "mpegts",
+		"-probesize", strconv.Itoa(probeSizeKB * 1000),
+		"-analyzeduration", strconv.Itoa(analyzeDurationMs * 1000), // microseconds
+		"-i", pipePath,
+	}
+	rungs := validRenditions(cfg.Ladder)
+	if len(rungs) == 0 {
+		return append(args, encode(videoKbps, audioKbps, cleanStream)...)
+	}
+
+	// [0:v] split into the clean output plus one scaled branch per rung
+	var filter strings.Builder
+	fmt.Fprintf(&filter, "[0:v]split=%d[vclean]", len(rungs)+1)
+	for i := range rungs {
+		fmt.Fprintf(&filter, "[v%d]", i)
+	}
+	for i, r := range rungs {
+		w, h, _ := strings.Cut(r.Resolution, "x")
+		fmt.Fprintf(&filter, ";[v%d]scale=%s:%s:force_original_aspect_ratio=decrease,pad=%s:%s:(ow-iw)/2:(oh-ih)/2[r%d]", i, w, h, w, h, i)
+	}
+	args = append(args, "-filter_complex", filter.String())
+	args = append(args, "-map", "[vclean]", "-map", "0:a?")
+	args = append(args, encode(videoKbps, audioKbps, cleanStream)...)
+	for i, r := range rungs {
+		rungAudioKbps := r.AudioBitrate
+		if rungAudioKbps <= 0 {
+			rungAudioKbps = 128
+		}
+		args = append(args, "-map", fmt.Sprintf("[r%d]", i), "-map", "0:a?")
+		args = append(args, encode(r.VideoBitrate, rungAudioKbps, abrPrefix+r.Name)...)
+	}
+	return args
+}
+
+// validRenditions drops ladder rungs FFmpeg couldn't be given: a missing or
+// duplicate name, a malformed resolution or no video bitrate. It runs on every
+// config comparison, so it stays quiet; the controller validates the ladder.
+func validRenditions(ladder []Rendition) []Rendition {
+	var out []Rendition
+	seen := make(map[string]bool)
+	for _, r := range ladder {
+		w, h, ok := strings.Cut(r.Resolution, "x")
+		_, werr := strconv.Atoi(w)
+		_, herr := strconv.Atoi(h)
+		if r.Name == "" || seen[r.Name] || strings.ContainsAny(r.Name, "/?&") || !ok || werr != nil || herr != nil || r.VideoBitrate <= 0 {
+			continue
+		}
+		seen[r.Name] = true
+		out = append(out, r)
+	}
+	return out
+}
+
+// readTranscoderProgress parses FFmpeg's key=value progress blocks until the
+// transcoder exits, then zeroes the gauges.
+func readTranscoderProgress(r io.Reader) {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		key, value, ok := strings.Cut(scanner.Text(), "=")
+		if !ok {
+			continue
+		}
+		value = strings.TrimSpace(value)
+		progressMu.Lock()
+		switch key {
+		case "fps":
+			transcoderFPS, _ = strconv.ParseFloat(value, 64)
+		case "bitrate":
+			transcoderKbps, _ = strconv.ParseFloat(strings.TrimSuffix(value, "kbits/s"), 64)
+		case "speed":
+			transcoderSpeed, _ = strconv.ParseFloat(strings.TrimSuffix(value, "x"), 64)
+		}
+		progressMu.Unlock()
+	}
+	progressMu.Lock()
+	transcoderFPS, transcoderKbps, transcoderSpeed = 0, 0, 0
+	progressMu.Unlock()
+}
+
+// destLabel identifies a destination in metrics without exposing its stream
+// key: the host plus a short hash of the full URL.
+func destLabel(destURL string) (host, id string) {
+	host = destURL
+	if i := strings.Index(host, "://"); i >= 0 {
+		host = host[i+3:]
+	}
+	if i := strings.Index(host, "/"); i >= 0 {
+		host = host[:i]
+	}
+	sum := sha256.Sum256([]byte(destURL))
+	return host, hex.EncodeToString(sum[:4])
+}
+
+func boolGauge(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// handleMetrics exposes relay state in the Prometheus text format.
+func handleMetrics(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+
+	modeMutex.RLock()
+	mode := currentMode
+	modeMutex.RUnlock()
+	mu.Lock()
+	transcoderUp := transcoderCmd != nil && transcoderCmd.ProcessState == nil
+	paused := transcoderPaused
+	mu.Unlock()
+	progressMu.Lock()
+	fps, kbps, speed := transcoderFPS, transcoderKbps, transcoderSpeed
+	progressMu.Unlock()
+
+	fmt.Fprintln(w, "# HELP relay_mode Active source mode (1 for the current mode).")
+	fmt.Fprintln(w, "# TYPE relay_mode gauge")
+	for _, m := range []string{"LOOP", "OBS"} {
+		fmt.Fprintf(w, "relay_mode{mode=%q} %d\n", m, boolGauge(mode == m))
+	}
+	fmt.Fprintln(w, "# HELP relay_transcoder_running Whether the clean-stream transcoder is running.")
+	fmt.Fprintln(w, "# TYPE relay_transcoder_running gauge")
+	fmt.Fprintf(w, "relay_transcoder_running %d\n", boolGauge(transcoderUp))
+	fmt.Fprintln(w, "# HELP relay_transcoder_paused Whether the transcoder is paused because all destinations are down.")
+	fmt.Fprintln(w, "# TYPE relay_transcoder_paused gauge")
+	fmt.Fprintf(w, "relay_transcoder_paused %d\n", boolGauge(paused))
+	fmt.Fprintln(w, "# HELP relay_transcoder_fps Output frames per second reported by FFmpeg.")
+	fmt.Fprintln(w, "# TYPE relay_transcoder_fps gauge")
+	fmt.Fprintf(w, "relay_transcoder_fps %g\n", fps)
+	fmt.Fprintln(w, "# HELP relay_transcoder_bitrate_kbps Output bitrate reported by FFmpeg.")
+	fmt.Fprintln(w, "# TYPE relay_transcoder_bitrate_kbps gauge")
+	fmt.Fprintf(w, "relay_transcoder_bitrate_kbps %g\n", kbps)
+	fmt.Fprintln(w, "# HELP relay_transcoder_speed Encoding speed relative to realtime.")
+	fmt.Fprintln(w, "# TYPE relay_transcoder_speed gauge")
+	fmt.Fprintf(w, "relay_transcoder_speed %g\n", speed)
+
+	fmt.Fprintln(w, "# HELP relay_stream_dropped_chunks_total Pump output chunks dropped because the stream buffer was full.")
+	fmt.Fprintln(w, "# TYPE relay_stream_dropped_chunks_total counter")
+	fmt.Fprintf(w, "relay_stream_dropped_chunks_total %d\n", droppedChunks.Load())
+
+	destMu.Lock()
+	type distState struct {
+		url     string
+		running bool
+	}
+	var dists []distState
+	for url, cmd := range distributors {
+		dists = append(dists, distState{url, cmd != nil})
+	}
+	destMu.Unlock()
+
+	fmt.Fprintln(w, "# HELP relay_distributor_up Whether the distributor FFmpeg for a destination is running.")
+	fmt.Fprintln(w, "# TYPE relay_distributor_up gauge")
+	for _, d := range dists {
+		host, id := destLabel(d.url)
+		fmt.Fprintf(w, "relay_distributor_up{host=%q,id=%q} %d\n", host, id, boolGauge(d.running))
+	}
+	fmt.Fprintln(w, "# HELP relay_distributor_failures Consecutive short-lived distributor runs.")
+	fmt.Fprintln(w, "# TYPE relay_distributor_failures gauge")
+	for _, d := range dists {
+		host, id := destLabel(d.url)
+		failureMu.Lock()
+		fails := failureCounts[d.url]
+		failureMu.Unlock()
+		fmt.Fprintf(w, "relay_distributor_failures{host=%q,id=%q} %d\n", host, id, fails)
+	}
+}
+
 func manageDistributors(destinations []string) {
 	destMu.Lock()
 	defer destMu.Unlock()
@@ -420,12 +1413,15 @@ func manageDistributors(destinations []string) {
 			delete(distributors, url)
 			failureMu.Lock()
 			delete(failureCounts, url)
+			delete(failingSince, url)
+			delete(distStartedAt, url)
+			delete(restartWanted, url)
 			failureMu.Unlock()
 		}
 	}
 	for _, url := range destinations {
 		if _, exists := distributors[url]; !exists {
-			log.Printf("[RELAY] Starting Dist: %s", url)
+			log.Printf("[RELAY] Starting Dist: %s", redactSecrets(url))
 			distributors[url] = nil
 			startDistributor(url)
 		}
@@ -441,32 +1437,48 @@ func startDistributor(destURL string) {
 			time.Sleep(time.Duration(fails) * 2 * time.Second)
 		}
 
-		args := []string{"-hide_banner", "-loglevel", "warning", "-i", cleanStream, "-c", "copy", "-f", "flv", destURL}
+		mu.Lock()
+		args := distributorArgs(currentConfig, destURL)
+		mu.Unlock()
 		cmd := exec.Command("ffmpeg", args...)
 		cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
 		cmd.Stdout = os.Stdout
-		cmd.Stderr = os.Stderr
+		cmd.Stderr = redactingWriter{os.Stderr} // FFmpeg errors echo the destination URL
 		start := time.Now()
 		if err := cmd.Start(); err != nil {
-			failureMu.Lock()
-			failureCounts[destURL]++
-			failureMu.Unlock()
+			recordDistFailure(destURL)
 			startDistributor(destURL)
 			return
 		}
+		failureMu.Lock()
+		distStartedAt[destURL] = start
+		failureMu.Unlock()
 		destMu.Lock()
 		distributors[destURL] = cmd
 		destMu.Unlock()
 		cmd.Wait()
+		// Wait sets ProcessState without a lock, so the exit is recorded under
+		// destMu instead: an entry is non-nil only while its FFmpeg runs
+		destMu.Lock()
+		if distributors[destURL] == cmd {
+			distributors[destURL] = nil
+		}
+		destMu.Unlock()
+
+		failureMu.Lock()
+		requested := restartWanted[destURL]
+		delete(restartWanted, destURL)
+		failureMu.Unlock()
 
-		if time.Since(start) > 60*time.Second {
+		if requested {
+			log.Printf("[RELAY] Dist restarted on request: %s", redactSecrets(destURL))
+		} else if time.Since(start) > 60*time.Second {
 			failureMu.Lock()
 			failureCounts[destURL] = 0
+			delete(failingSince, destURL)
 			failureMu.Unlock()
 		} else {
-			failureMu.Lock()
-			failureCounts[destURL]++
-			failureMu.Unlock()
+			recordDistFailure(destURL)
 		}
 
 		mu.Lock()
@@ -488,6 +1500,126 @@ func startDistributor(destURL string) {
 	}()
 }
 
+// distributorArgs builds the clean stream -> destination FFmpeg command. Plain
+// destinations are a cheap stream copy; profiled ones get their own encode,
+// keeping the channel's fps and keyframe cadence so segments stay aligned.
+func distributorArgs(cfg Config, destURL string) []string {
+	p, ok := cfg.Profiles[destURL]
+	if !ok {
+		return []string{"-hide_banner", "-loglevel", "warning", "-i", cleanStream, "-c", "copy", "-f", "flv", destURL}
+	}
+
+	fps := cfg.OutputFPS
+	if fps <= 0 {
+		fps = 30
+	}
+	keyframeInterval := cfg.KeyframeInterval
+	if keyframeInterval <= 0 {
+		keyframeInterval = 2
+	}
+	gop := strconv.Itoa(fps * keyframeInterval)
+	videoKbps := p.VideoBitrate
+	if videoKbps <= 0 {
+		videoKbps = cfg.VideoBitrate
+	}
+	if videoKbps <= 0 {
+		videoKbps = 4000
+	}
+	audioKbps := p.AudioBitrate
+	if audioKbps <= 0 {
+		audioKbps = cfg.AudioBitrate
+	}
+	if audioKbps <= 0 {
+		audioKbps = 128
+	}
+	preset := cfg.Preset
+	if !validPresets[preset] {
+		preset = "ultrafast"
+	}
+
+	args := []string{"-hide_banner", "-loglevel", "warning", "-i", cleanStream}
+	if w, h, ok := strings.Cut(p.Resolution, "x"); ok {
+		args = append(args, "-vf", fmt.Sprintf("scale=%s:%s:force_original_aspect_ratio=decrease,pad=%s:%s:(ow-iw)/2:(oh-ih)/2", w, h, w, h))
+	}
+	vb := fmt.Sprintf("%dk", videoKbps)
+	return append(args,
+		"-c:v", "libx264", "-preset", preset, "-tune", "zerolatency",
+		"-b:v", vb, "-maxrate", vb, "-bufsize", fmt.Sprintf("%dk", videoKbps*2), "-pix_fmt", "yuv420p",
+		"-r", strconv.Itoa(fps), "-g", gop, "-keyint_min", gop, "-sc_threshold", "0",
+		"-c:a", "aac", "-b:a", fmt.Sprintf("%dk", audioKbps), "-ac", "2",
+		"-f", "flv", destURL,
+	)
+}
+
+// handleDistributorRestart kills one distributor's FFmpeg so its supervisor
+// goroutine starts a fresh one straight away. The transcoder and the other
+// distributors are left alone.
+func handleDistributorRestart(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "POST only", http.StatusMethodNotAllowed)
+		return
+	}
+	var req struct {
+		URL string `json:"url"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.URL == "" {
+		http.Error(w, "url required", http.StatusBadRequest)
+		return
+	}
+	if !restartDistributor(req.URL) {
+		http.Error(w, "unknown destination", http.StatusNotFound)
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]string{"status": "restarting"})
+}
+
+// restartDistributor reports false if destURL isn't a current destination. A
+// distributor waiting out its backoff has no process yet; clearing its failure
+// count makes the next attempt start without further delay.
+func restartDistributor(destURL string) bool {
+	destMu.Lock()
+	defer destMu.Unlock()
+	cmd, ok := distributors[destURL]
+	if !ok {
+		return false
+	}
+
+	failureMu.Lock()
+	failureCounts[destURL] = 0
+	if cmd != nil {
+		restartWanted[destURL] = true
+	}
+	failureMu.Unlock()
+
+	if cmd != nil {
+		log.Printf("[RELAY] Restarting Dist: %s", redactSecrets(destURL))
+		syscall.Kill(-cmd.Process.Pid, syscall.SIGTERM)
+	}
+	return true
+}
+
+func recordDistFailure(destURL string) {
+	failureMu.Lock()
+	defer failureMu.Unlock()
+	failureCounts[destURL]++
+	if _, ok := failingSince[destURL]; !ok {
+		failingSince[destURL] = time.Now()
+	}
+}
+
+// distFailingSince returns when a distributor's failure streak began. A process
+// that has stayed up for over a minute counts as recovered even though the
+// streak is only cleared once it exits.
+func distFailingSince(destURL string, running bool) (time.Time, bool) {
+	failureMu.Lock()
+	defer failureMu.Unlock()
+	since, ok := failingSince[destURL]
+	if ok && running && time.Since(distStartedAt[destURL]) > 60*time.Second {
+		return time.Time{}, false
+	}
+	return since, ok
+}
+
 func cleanup() {
 	mu.Lock()
 	defer mu.Unlock()
@@ -500,6 +1632,9 @@ func cleanup() {
 	if transcoderCmd != nil && transcoderCmd.Process != nil {
 		syscall.Kill(-transcoderCmd.Process.Pid, syscall.SIGKILL)
 	}
+	if recorderCmd != nil && recorderCmd.Process != nil {
+		syscall.Kill(-recorderCmd.Process.Pid, syscall.SIGINT)
+	}
 	destMu.Lock()
 	for _, cmd := range distributors {
 		if cmd != nil && cmd.Process != nil {
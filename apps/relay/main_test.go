@@ -0,0 +1,832 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestRedactSecrets(t *testing.T) {
+	tests := []struct{ in, want string }{
+		{"rtmp://a.rtmp.youtube.com/live2/abcd-efgh-ijkl", "rtmp://a.rtmp.youtube.com/live2/****"},
+		{"rtmps://live-api-s.facebook.com:443/rtmp/FB-123?s_bl=1&key=xyz", "rtmps://live-api-s.facebook.com:443/rtmp/****?s_bl=1&key=****"},
+		{"rtmp://srs:1935/live/news-obs?token=s3cret", "rtmp://srs:1935/live/****?token=****"},
+		{"[RELAY] Source Change: rtmp://srs:1935/live/news?token=abc -> none", "[RELAY] Source Change: rtmp://srs:1935/live/****?token=**** -> none"},
+		{"no secrets here", "no secrets here"},
+	}
+	for _, tt := range tests {
+		if got := redactSecrets(tt.in); got != tt.want {
+			t.Errorf("redactSecrets(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+// setOBSPumpState makes url the configured source with OBS on air and
+// returns a fresh pump generation for it.
+func setOBSPumpState(t *testing.T, url string) int {
+	t.Helper()
+	mu.Lock()
+	currentConfig.SourceURL = url
+	obsPumpGen++
+	gen := obsPumpGen
+	mu.Unlock()
+	modeMutex.Lock()
+	currentMode = "OBS"
+	modeMutex.Unlock()
+	return gen
+}
+
+func TestSuperviseOBSPumpReconnectsAfterBriefDrop(t *testing.T) {
+	const url = "rtmp://srs:1935/live/news-obs"
+	gen := setOBSPumpState(t, url)
+
+	attempts := 0
+	reason := superviseOBSPump(gen, url, 3*time.Second, func(giveUpAt time.Time) (bool, string) {
+		attempts++
+		switch attempts {
+		case 1:
+			// Streaming, then the connection blips
+			return true, "ObsProcessExit"
+		case 2:
+			if giveUpAt.IsZero() {
+				t.Error("reconnect attempt has no give-up deadline")
+			}
+			// OBS is back; the controller later replaces this pump
+			mu.Lock()
+			obsPumpGen++
+			mu.Unlock()
+			return true, "ObsProcessExit"
+		}
+		t.Fatalf("unexpected attempt %d", attempts)
+		return false, ""
+	})
+	if reason != "" {
+		t.Errorf("failed over (%s) although OBS came back", reason)
+	}
+	if attempts != 2 {
+		t.Errorf("attempts = %d, want 2", attempts)
+	}
+}
+
+func TestSuperviseOBSPumpFailsOverAfterWindow(t *testing.T) {
+	const url = "rtmp://srs:1935/live/news-obs"
+	gen := setOBSPumpState(t, url)
+
+	attempts := 0
+	reason := superviseOBSPump(gen, url, time.Second, func(giveUpAt time.Time) (bool, string) {
+		attempts++
+		return attempts == 1, "ObsProcessExit"
+	})
+	if reason != "ObsProcessExit" {
+		t.Errorf("reason = %q, want ObsProcessExit", reason)
+	}
+	if attempts < 2 {
+		t.Errorf("attempts = %d, want at least one reconnect", attempts)
+	}
+}
+
+func TestSuperviseOBSPumpStopsWhenSupersededDuringBackoff(t *testing.T) {
+	const url = "rtmp://srs:1935/live/news-obs"
+	gen := setOBSPumpState(t, url)
+
+	attempts := 0
+	reason := superviseOBSPump(gen, url, 3*time.Second, func(giveUpAt time.Time) (bool, string) {
+		attempts++
+		// A new pump starts while this one waits to reconnect
+		time.AfterFunc(obsReconnectDelay/2, func() {
+			mu.Lock()
+			obsPumpGen++
+			mu.Unlock()
+		})
+		return true, "ObsProcessExit"
+	})
+	if reason != "" || attempts != 1 {
+		t.Errorf("reason = %q, attempts = %d; want no failover and no reconnect", reason, attempts)
+	}
+}
+
+func TestReadTranscoderProgress(t *testing.T) {
+	r, w := io.Pipe()
+	done := make(chan struct{})
+	go func() {
+		readTranscoderProgress(r)
+		close(done)
+	}()
+
+	io.WriteString(w, "frame=100\nfps=29.97\nbitrate=2500.5kbits/s\nspeed=1.01x\nprogress=continue\n")
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		progressMu.Lock()
+		fps, kbps, speed := transcoderFPS, transcoderKbps, transcoderSpeed
+		progressMu.Unlock()
+		if fps == 29.97 && kbps == 2500.5 && speed == 1.01 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("gauges = %v fps, %v kbps, %vx", fps, kbps, speed)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	w.Close()
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("reader did not return at EOF")
+	}
+	progressMu.Lock()
+	defer progressMu.Unlock()
+	if transcoderFPS != 0 || transcoderKbps != 0 || transcoderSpeed != 0 {
+		t.Errorf("gauges not reset after exit: %v fps, %v kbps, %vx", transcoderFPS, transcoderKbps, transcoderSpeed)
+	}
+}
+
+func TestRestartDistributor(t *testing.T) {
+	const running, waiting = "rtmp://a.example/live/key1", "rtmp://b.example/live/key2"
+	cmd := exec.Command("sleep", "30")
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	if err := cmd.Start(); err != nil {
+		t.Skipf("can't start sleep: %v", err)
+	}
+	exited := make(chan struct{})
+	go func() {
+		cmd.Wait()
+		close(exited)
+	}()
+
+	destMu.Lock()
+	distributors[running] = cmd
+	distributors[waiting] = nil // Waiting out its backoff
+	destMu.Unlock()
+	failureMu.Lock()
+	failureCounts[waiting] = 3
+	failureMu.Unlock()
+	t.Cleanup(func() {
+		destMu.Lock()
+		delete(distributors, running)
+		delete(distributors, waiting)
+		destMu.Unlock()
+		failureMu.Lock()
+		delete(failureCounts, running)
+		delete(failureCounts, waiting)
+		delete(restartWanted, running)
+		failureMu.Unlock()
+	})
+
+	if restartDistributor("rtmp://unknown.example/live/x") {
+		t.Error("restartDistributor accepted an unknown destination")
+	}
+
+	if !restartDistributor(waiting) {
+		t.Fatal("restartDistributor rejected a destination in backoff")
+	}
+	failureMu.Lock()
+	fails, wanted := failureCounts[waiting], restartWanted[waiting]
+	failureMu.Unlock()
+	if fails != 0 || wanted {
+		t.Errorf("backoff destination: failures = %d, restartWanted = %v; want 0, false", fails, wanted)
+	}
+
+	if !restartDistributor(running) {
+		t.Fatal("restartDistributor rejected a running destination")
+	}
+	select {
+	case <-exited:
+	case <-time.After(5 * time.Second):
+		t.Fatal("running distributor was not signalled")
+	}
+	failureMu.Lock()
+	wanted = restartWanted[running]
+	failureMu.Unlock()
+	if !wanted {
+		t.Error("restartWanted not set for the killed distributor")
+	}
+}
+
+func TestFormatDuration(t *testing.T) {
+	tests := []struct {
+		ms   int64
+		want string
+	}{
+		{0, "0s"},
+		{45_000, "45s"},
+		{90_000, "1m 30s"},
+		{3_600_000, "1h"},
+		{30 * 3_600_000, "1d 6h"},
+		{86_400_000 + 61_000, "1d 1m"},
+	}
+	for _, tt := range tests {
+		if got := formatDuration(tt.ms); got != tt.want {
+			t.Errorf("formatDuration(%d) = %q, want %q", tt.ms, got, tt.want)
+		}
+	}
+}
+
+func TestLoopPumpTargetsChannelURL(t *testing.T) {
+	defer func(app string) { srsApp = app }(srsApp)
+	srsApp = "broadcast"
+
+	url := channelLoopURL("news")
+	if url != "rtmp://srs:1935/broadcast/news" {
+		t.Fatalf("channelLoopURL(news) = %q", url)
+	}
+	if channelLoopURL("sports") == url {
+		t.Error("distinct channels share a loop URL")
+	}
+	args := loopPumpArgs(url)
+	for i, a := range args {
+		if a == "-i" {
+			if i+1 >= len(args) || args[i+1] != url {
+				t.Errorf("loop pump input = %v, want %s", args[i+1:], url)
+			}
+			return
+		}
+	}
+	t.Errorf("loop pump args have no -i: %v", args)
+}
+
+// argAfter returns the value following the first occurrence of flag in args,
+// or "" when the flag is absent.
+func argAfter(args []string, flag string) string {
+	for i := 0; i+1 < len(args); i++ {
+		if args[i] == flag {
+			return args[i+1]
+		}
+	}
+	return ""
+}
+
+func TestTranscoderArgsFrameRate(t *testing.T) {
+	tests := []struct {
+		fps, keyframe int
+		wantR, wantG  string
+	}{
+		{60, 2, "60", "120"},
+		{25, 2, "25", "50"},
+		{30, 4, "30", "120"},
+		{0, 0, "30", "60"}, // Defaults
+	}
+	for _, tt := range tests {
+		args := transcoderArgs(Config{OutputFPS: tt.fps, KeyframeInterval: tt.keyframe})
+		if r, g := argAfter(args, "-r"), argAfter(args, "-g"); r != tt.wantR || g != tt.wantG {
+			t.Errorf("fps %d, keyframe %ds: -r %s -g %s, want -r %s -g %s", tt.fps, tt.keyframe, r, g, tt.wantR, tt.wantG)
+		}
+		if k := argAfter(args, "-keyint_min"); k != tt.wantG {
+			t.Errorf("fps %d, keyframe %ds: -keyint_min %s, want %s", tt.fps, tt.keyframe, k, tt.wantG)
+		}
+	}
+}
+
+func TestTranscoderArgsPresetTune(t *testing.T) {
+	tests := []struct {
+		preset, tune         string
+		wantPreset, wantTune string
+	}{
+		{"veryfast", "film", "veryfast", "film"},
+		{"medium", "none", "medium", ""},
+		{"", "", "ultrafast", "zerolatency"},
+		{"warp9", "bogus", "ultrafast", "zerolatency"},
+	}
+	for _, tt := range tests {
+		args := transcoderArgs(Config{Preset: tt.preset, Tune: tt.tune})
+		if p := argAfter(args, "-preset"); p != tt.wantPreset {
+			t.Errorf("preset %q: -preset %q, want %q", tt.preset, p, tt.wantPreset)
+		}
+		if tn := argAfter(args, "-tune"); tn != tt.wantTune {
+			t.Errorf("tune %q: -tune %q, want %q", tt.tune, tn, tt.wantTune)
+		}
+	}
+}
+
+func TestTranscoderArgsBitrate(t *testing.T) {
+	tests := []struct {
+		video, audio   int
+		wantV, wantMax string
+		wantBuf, wantA string
+	}{
+		{2500, 96, "2500k", "2500k", "5000k", "96k"},
+		{0, 0, "4000k", "4000k", "8000k", "128k"}, // Defaults
+	}
+	for _, tt := range tests {
+		args := transcoderArgs(Config{VideoBitrate: tt.video, AudioBitrate: tt.audio})
+		if v, m, b, a := argAfter(args, "-b:v"), argAfter(args, "-maxrate"), argAfter(args, "-bufsize"), argAfter(args, "-b:a"); v != tt.wantV || m != tt.wantMax || b != tt.wantBuf || a != tt.wantA {
+			t.Errorf("video %d, audio %d: -b:v %s -maxrate %s -bufsize %s -b:a %s, want %s %s %s %s",
+				tt.video, tt.audio, v, m, b, a, tt.wantV, tt.wantMax, tt.wantBuf, tt.wantA)
+		}
+	}
+
+	// A bitrate change is an encoding change, so the transcoder restarts
+	if !encodingChanged(Config{VideoBitrate: 4000}, Config{VideoBitrate: 6000}) {
+		t.Error("video bitrate change did not count as an encoding change")
+	}
+	if !encodingChanged(Config{AudioBitrate: 128}, Config{AudioBitrate: 192}) {
+		t.Error("audio bitrate change did not count as an encoding change")
+	}
+	if encodingChanged(Config{}, Config{VideoBitrate: 4000, AudioBitrate: 128}) {
+		t.Error("spelling out the default bitrates counted as an encoding change")
+	}
+}
+
+func TestHandleMetrics(t *testing.T) {
+	const dest = "rtmp://a.example/live/secret-key"
+	destMu.Lock()
+	distributors[dest] = nil
+	destMu.Unlock()
+	failureMu.Lock()
+	failureCounts[dest] = 3
+	failureMu.Unlock()
+	t.Cleanup(func() {
+		destMu.Lock()
+		delete(distributors, dest)
+		destMu.Unlock()
+		failureMu.Lock()
+		delete(failureCounts, dest)
+		failureMu.Unlock()
+	})
+
+	rec := httptest.NewRecorder()
+	handleMetrics(rec, httptest.NewRequest("GET", "/metrics", nil))
+	body := rec.Body.String()
+
+	for _, name := range []string{
+		"relay_mode", "relay_transcoder_running", "relay_transcoder_paused",
+		"relay_transcoder_fps", "relay_transcoder_bitrate_kbps", "relay_transcoder_speed",
+		"relay_stream_dropped_chunks_total", "relay_distributor_up", "relay_distributor_failures",
+	} {
+		if !strings.Contains(body, "# TYPE "+name+" ") {
+			t.Errorf("metrics missing %s", name)
+		}
+	}
+	host, id := destLabel(dest)
+	if want := fmt.Sprintf("relay_distributor_failures{host=%q,id=%q} 3", host, id); !strings.Contains(body, want) {
+		t.Errorf("metrics missing %q", want)
+	}
+	if strings.Contains(body, "secret-key") {
+		t.Error("metrics expose a destination stream key")
+	}
+}
+
+func TestOBSPumpArgsReadTimeout(t *testing.T) {
+	tests := []struct {
+		timeoutMs int
+		want      string
+	}{
+		{2000, "2000000"},
+		{15000, "15000000"},
+		{0, "5000000"}, // Default
+	}
+	for _, tt := range tests {
+		args := obsPumpArgs("rtmp://srs:1935/live/news-obs", tt.timeoutMs)
+		if got := argAfter(args, "-rw_timeout"); got != tt.want {
+			t.Errorf("timeout %dms: -rw_timeout %s, want %s", tt.timeoutMs, got, tt.want)
+		}
+	}
+}
+
+func TestSendChunkDropsWhenConsumerStalls(t *testing.T) {
+	orig := streamChan
+	defer func() { streamChan = orig }()
+	streamChan = make(chan []byte, 2)
+	before := droppedChunks.Load()
+
+	// Nothing reads streamChan, like a transcoder that has stopped keeping up
+	done := make(chan struct{})
+	go func() {
+		for i := 0; i < 10; i++ {
+			sendChunk([]byte{byte(i)})
+		}
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("sendChunk blocked on a full buffer")
+	}
+
+	if n := droppedChunks.Load() - before; n != 8 {
+		t.Errorf("dropped %d chunks, want 8", n)
+	}
+	// The oldest chunks are the ones kept
+	if b := <-streamChan; b[0] != 0 {
+		t.Errorf("first buffered chunk = %d, want 0", b[0])
+	}
+}
+
+func TestDistributorArgsProfiles(t *testing.T) {
+	const plain, profiled, partial = "rtmp://a.example/live/k1", "rtmp://b.example/live/k2", "rtmp://c.example/live/k3"
+	cfg := Config{
+		VideoBitrate: 6000, AudioBitrate: 160, OutputFPS: 60, KeyframeInterval: 2, Preset: "veryfast",
+		Profiles: map[string]DestProfile{
+			profiled: {Resolution: "1280x720", VideoBitrate: 2500, AudioBitrate: 96},
+			partial:  {Resolution: "854x480"}, // Bitrates fall back to the channel's
+		},
+	}
+
+	// Unprofiled destinations keep the stream copy
+	args := distributorArgs(cfg, plain)
+	if argAfter(args, "-c") != "copy" || argAfter(args, "-c:v") != "" || args[len(args)-1] != plain {
+		t.Errorf("plain destination args = %v, want a stream copy", args)
+	}
+
+	tests := []struct {
+		dest                    string
+		wantScale, wantV, wantA string
+		wantBufsize, wantPreset string
+	}{
+		{profiled, "scale=1280:720:force_original_aspect_ratio=decrease,pad=1280:720:(ow-iw)/2:(oh-ih)/2", "2500k", "96k", "5000k", "veryfast"},
+		{partial, "scale=854:480:force_original_aspect_ratio=decrease,pad=854:480:(ow-iw)/2:(oh-ih)/2", "6000k", "160k", "12000k", "veryfast"},
+	}
+	for _, tt := range tests {
+		args := distributorArgs(cfg, tt.dest)
+		if got := argAfter(args, "-vf"); got != tt.wantScale {
+			t.Errorf("%s: -vf %q, want %q", tt.dest, got, tt.wantScale)
+		}
+		if v, a := argAfter(args, "-b:v"), argAfter(args, "-b:a"); v != tt.wantV || a != tt.wantA {
+			t.Errorf("%s: -b:v %s -b:a %s, want %s and %s", tt.dest, v, a, tt.wantV, tt.wantA)
+		}
+		if got := argAfter(args, "-bufsize"); got != tt.wantBufsize {
+			t.Errorf("%s: -bufsize %s, want %s", tt.dest, got, tt.wantBufsize)
+		}
+		if got := argAfter(args, "-preset"); got != tt.wantPreset {
+			t.Errorf("%s: -preset %s, want %s", tt.dest, got, tt.wantPreset)
+		}
+		// Keyframes stay aligned with the channel's encode
+		if r, g := argAfter(args, "-r"), argAfter(args, "-g"); r != "60" || g != "120" {
+			t.Errorf("%s: -r %s -g %s, want -r 60 -g 120", tt.dest, r, g)
+		}
+		if args[len(args)-1] != tt.dest {
+			t.Errorf("%s: output %s", tt.dest, args[len(args)-1])
+		}
+	}
+}
+
+func TestCleanStreamNamePerChannel(t *testing.T) {
+	channels := []string{"main", "news", "news2", "sports", "main_relay"}
+	seen := map[string]string{}
+	for _, ch := range channels {
+		name := cleanStreamName(ch)
+		if other, dup := seen[name]; dup {
+			t.Errorf("channels %q and %q share clean stream %q", other, ch, name)
+		}
+		seen[name] = ch
+	}
+	if got := cleanStreamName("news"); got != "news_relay_clean" {
+		t.Errorf(`cleanStreamName("news") = %q, want "news_relay_clean"`, got)
+	}
+}
+
+func TestTranscoderArgsLadder(t *testing.T) {
+	args := transcoderArgs(Config{Ladder: []Rendition{
+		{Name: "1080p", Resolution: "1920x1080", VideoBitrate: 6000, AudioBitrate: 192},
+		{Name: "720p", Resolution: "1280x720", VideoBitrate: 3000},
+		{Name: "480p", Resolution: "854x480", VideoBitrate: 1200, AudioBitrate: 96},
+	}})
+
+	wantFilter := "[0:v]split=4[vclean][v0][v1][v2]" +
+		";[v0]scale=1920:1080:force_original_aspect_ratio=decrease,pad=1920:1080:(ow-iw)/2:(oh-ih)/2[r0]" +
+		";[v1]scale=1280:720:force_original_aspect_ratio=decrease,pad=1280:720:(ow-iw)/2:(oh-ih)/2[r1]" +
+		";[v2]scale=854:480:force_original_aspect_ratio=decrease,pad=854:480:(ow-iw)/2:(oh-ih)/2[r2]"
+	if got := argAfter(args, "-filter_complex"); got != wantFilter {
+		t.Errorf("-filter_complex %q\nwant %q", got, wantFilter)
+	}
+
+	// Each output is introduced by its -map pair and ends with -f flv <url>
+	type output struct{ video, vbitrate, abitrate, url string }
+	var outputs []output
+	var cur output
+	for i := 0; i+1 < len(args); i++ {
+		switch args[i] {
+		case "-map":
+			if strings.HasPrefix(args[i+1], "[") {
+				cur.video = args[i+1]
+			}
+		case "-b:v":
+			cur.vbitrate = args[i+1]
+		case "-b:a":
+			cur.abitrate = args[i+1]
+		case "-f":
+			if args[i+1] == "flv" && i+2 < len(args) {
+				cur.url = args[i+2]
+				outputs = append(outputs, cur)
+				cur = output{}
+			}
+		}
+	}
+	want := []output{
+		{"[vclean]", "4000k", "128k", cleanStream},
+		{"[r0]", "6000k", "192k", abrPrefix + "1080p"},
+		{"[r1]", "3000k", "128k", abrPrefix + "720p"},
+		{"[r2]", "1200k", "96k", abrPrefix + "480p"},
+	}
+	if fmt.Sprint(outputs) != fmt.Sprint(want) {
+		t.Errorf("outputs = %+v\nwant %+v", outputs, want)
+	}
+
+	// Without a ladder there's a single clean output and no filter graph
+	args = transcoderArgs(Config{})
+	if argAfter(args, "-filter_complex") != "" || args[len(args)-1] != cleanStream {
+		t.Errorf("no ladder: args end %q, want a single %s output", args[len(args)-3:], cleanStream)
+	}
+}
+
+// routeSRSTo sends the relay's requests to srs:1985 to srv instead.
+func routeSRSTo(t *testing.T, srv *httptest.Server) {
+	t.Helper()
+	orig := http.DefaultTransport
+	dialer := &net.Dialer{Timeout: time.Second}
+	http.DefaultTransport = &http.Transport{
+		DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			if addr == "srs:1985" {
+				addr = srv.Listener.Addr().String()
+			}
+			return dialer.DialContext(ctx, network, addr)
+		},
+	}
+	t.Cleanup(func() { http.DefaultTransport = orig })
+}
+
+func TestSourceParamChangeRestartsTranscoder(t *testing.T) {
+	var srsMu sync.Mutex
+	width, height := 1920, 1080
+	srs := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		srsMu.Lock()
+		defer srsMu.Unlock()
+		fmt.Fprintf(w, `{"streams":[{"name":"waheguru","publish":{"active":true},
+			"video":{"codec":"H264","profile":"High","width":%d,"height":%d},
+			"audio":{"codec":"AAC","sample_rate":48000,"channel":2}}]}`, width, height)
+	}))
+	defer srs.Close()
+	routeSRSTo(t, srs)
+
+	startTranscoder := func() chan struct{} {
+		cmd := exec.Command("sleep", "30")
+		cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+		if err := cmd.Start(); err != nil {
+			t.Skipf("can't start sleep: %v", err)
+		}
+		exited := make(chan struct{})
+		go func() {
+			cmd.Wait()
+			close(exited)
+		}()
+		t.Cleanup(func() { syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL) })
+		mu.Lock()
+		transcoderCmd = cmd
+		mu.Unlock()
+		return exited
+	}
+	mu.Lock()
+	origCmd, origParams := transcoderCmd, pipeParams
+	pipeParams = sourceParams{}
+	mu.Unlock()
+	modeMutex.Lock()
+	origMode := currentMode
+	currentMode = "LOOP"
+	modeMutex.Unlock()
+	t.Cleanup(func() {
+		mu.Lock()
+		transcoderCmd, pipeParams = origCmd, origParams
+		mu.Unlock()
+		modeMutex.Lock()
+		currentMode = origMode
+		modeMutex.Unlock()
+	})
+
+	// The first parameters seen are a baseline; seeing them again is no change
+	exited := startTranscoder()
+	checkSourceParams("LOOP")
+	checkSourceParams("LOOP")
+	select {
+	case <-exited:
+		t.Fatal("transcoder restarted without a parameter change")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	// The same source comes back at 720p
+	srsMu.Lock()
+	width, height = 1280, 720
+	srsMu.Unlock()
+	checkSourceParams("LOOP")
+	select {
+	case <-exited:
+	case <-time.After(5 * time.Second):
+		t.Fatal("transcoder was not restarted after the resolution changed")
+	}
+	mu.Lock()
+	got := pipeParams.Width
+	mu.Unlock()
+	if got != 1280 {
+		t.Errorf("tracked width = %d, want 1280", got)
+	}
+}
+
+func TestRequireSecret(t *testing.T) {
+	orig := apiSecret
+	apiSecret = "relay-secret"
+	t.Cleanup(func() { apiSecret = orig })
+
+	var served int
+	h := requireSecret(func(w http.ResponseWriter, r *http.Request) { served++ })
+	tests := []struct {
+		name, secret string
+		want         int
+	}{
+		{"no secret", "", http.StatusUnauthorized},
+		{"wrong secret", "guess", http.StatusUnauthorized},
+		{"controller's secret", "relay-secret", http.StatusOK},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest("POST", "/update", strings.NewReader(`{"destinations":["rtmp://attacker.example/live/x"]}`))
+		if tt.secret != "" {
+			req.Header.Set("X-Relay-Secret", tt.secret)
+		}
+		rec := httptest.NewRecorder()
+		h(rec, req)
+		if rec.Code != tt.want {
+			t.Errorf("%s: status %d, want %d", tt.name, rec.Code, tt.want)
+		}
+	}
+	if served != 1 {
+		t.Errorf("handler ran %d times, want only for the authenticated request", served)
+	}
+}
+
+func TestMissCounterNeedsConsecutiveMisses(t *testing.T) {
+	m := missCounter{limit: 3}
+	// found, missing, missing, found: an SRS hiccup and a reconnect, no failover
+	for i, found := range []bool{true, false, false, true, false, false} {
+		if found {
+			m.reset()
+		} else if m.miss() {
+			t.Fatalf("poll %d: failover after fewer than 3 consecutive misses", i)
+		}
+	}
+	if !m.miss() {
+		t.Fatal("3 consecutive misses did not trigger failover")
+	}
+	// The count starts over after a failover
+	if m.miss() || m.miss() || !m.miss() {
+		t.Error("second failover not after another 3 misses")
+	}
+
+	for env, want := range map[string]int{"": 3, "5": 5, "0": 3, "many": 3} {
+		t.Setenv("SRS_LOST_POLLS", env)
+		if got := srsLostPolls(); got != want {
+			t.Errorf("SRS_LOST_POLLS=%q: %d polls, want %d", env, got, want)
+		}
+	}
+}
+
+func TestTranscoderArgsProbeAnalyze(t *testing.T) {
+	tests := []struct {
+		probeKB, analyzeMs     int
+		wantProbe, wantAnalyze string
+	}{
+		{500, 50, "500000", "50000"},
+		{64000, 2000, "64000000", "2000000"},
+		{0, 0, "32000000", "100000"}, // Defaults
+	}
+	for _, tt := range tests {
+		args := transcoderArgs(Config{ProbeSizeKB: tt.probeKB, AnalyzeDurationMs: tt.analyzeMs})
+		if p, a := argAfter(args, "-probesize"), argAfter(args, "-analyzeduration"); p != tt.wantProbe || a != tt.wantAnalyze {
+			t.Errorf("%dKB, %dms: -probesize %s -analyzeduration %s, want %s and %s", tt.probeKB, tt.analyzeMs, p, a, tt.wantProbe, tt.wantAnalyze)
+		}
+	}
+}
+
+func TestAllDestinationsDownPausesTranscoder(t *testing.T) {
+	const a, b = "rtmp://a.example/live/key1", "rtmp://b.example/live/key2"
+	now := time.Now()
+	failureMu.Lock()
+	failingSince[a] = now.Add(-2 * time.Minute)
+	failingSince[b] = now.Add(-30 * time.Second)
+	failureMu.Unlock()
+	t.Cleanup(func() {
+		failureMu.Lock()
+		delete(failingSince, a)
+		delete(failingSince, b)
+		failureMu.Unlock()
+	})
+
+	// b hasn't been down for the whole minute yet
+	if allDestinationsDown([]string{a, b}, time.Minute, now) {
+		t.Error("all down before every destination passed the threshold")
+	}
+	if !allDestinationsDown([]string{a, b}, time.Minute, now.Add(time.Minute)) {
+		t.Fatal("not all down once every destination passed the threshold")
+	}
+	if allDestinationsDown(nil, time.Minute, now) {
+		t.Error("no destinations counted as all down")
+	}
+
+	cmd := exec.Command("sleep", "30")
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	if err := cmd.Start(); err != nil {
+		t.Skipf("can't start sleep: %v", err)
+	}
+	exited := make(chan struct{})
+	go func() {
+		cmd.Wait()
+		close(exited)
+	}()
+	mu.Lock()
+	origCmd := transcoderCmd
+	transcoderCmd = cmd
+	mu.Unlock()
+	t.Cleanup(func() {
+		mu.Lock()
+		transcoderCmd, transcoderPaused = origCmd, false
+		mu.Unlock()
+	})
+
+	pauseTranscoder(time.Minute)
+	select {
+	case <-exited:
+	case <-time.After(5 * time.Second):
+		t.Fatal("transcoder still running after the pause")
+	}
+	rec := httptest.NewRecorder()
+	handleStatus(rec, httptest.NewRequest("GET", "/status", nil))
+	if !strings.Contains(rec.Body.String(), `"all_destinations_down":true`) {
+		t.Errorf("status %s does not report all_destinations_down", rec.Body.String())
+	}
+}
+
+func TestHealthzNotReadyBeforeTranscoder(t *testing.T) {
+	mu.Lock()
+	origCmd, origPaused := transcoderCmd, transcoderPaused
+	transcoderCmd, transcoderPaused = nil, false
+	mu.Unlock()
+	origPipe := pipeWriter
+	pipeWriter = nil
+	t.Cleanup(func() {
+		mu.Lock()
+		transcoderCmd, transcoderPaused = origCmd, origPaused
+		mu.Unlock()
+		pipeWriter = origPipe
+	})
+
+	probe := func() (int, string) {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		handleHealthz(rec, httptest.NewRequest("GET", "/healthz", nil))
+		var body struct {
+			Ready  bool   `json:"ready"`
+			Reason string `json:"reason"`
+		}
+		json.NewDecoder(rec.Body).Decode(&body)
+		if body.Ready != (rec.Code == http.StatusOK) {
+			t.Errorf("status %d disagrees with ready=%v", rec.Code, body.Ready)
+		}
+		return rec.Code, body.Reason
+	}
+
+	if code, reason := probe(); code != http.StatusServiceUnavailable || reason != "pipe not open" {
+		t.Errorf("before the pipe opens: %d %q, want 503 pipe not open", code, reason)
+	}
+
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	defer w.Close()
+	pipeWriter = w
+	if code, reason := probe(); code != http.StatusServiceUnavailable || reason != "transcoder not running" {
+		t.Errorf("before the transcoder starts: %d %q, want 503 transcoder not running", code, reason)
+	}
+
+	cmd := exec.Command("sleep", "30")
+	if err := cmd.Start(); err != nil {
+		t.Skipf("can't start sleep: %v", err)
+	}
+	t.Cleanup(func() {
+		cmd.Process.Kill()
+		cmd.Wait()
+	})
+	mu.Lock()
+	transcoderCmd = cmd
+	mu.Unlock()
+	if code, _ := probe(); code != http.StatusOK {
+		t.Errorf("with the transcoder running: %d, want 200", code)
+	}
+
+	// Deliberately paused because every destination is down is still ready
+	mu.Lock()
+	transcoderCmd, transcoderPaused = nil, true
+	mu.Unlock()
+	if code, _ := probe(); code != http.StatusOK {
+		t.Errorf("with the transcoder paused: %d, want 200", code)
+	}
+}
@@ -1,11 +1,13 @@
 import { NextResponse } from 'next/server';
+import { controllerHeaders } from "@/lib/api";
 
 const CONTROLLER_URL = process.env.CONTROLLER_API_URL || 'http://controller:8080';
 
 export async function GET() {
     try {
         const res = await fetch(`${CONTROLLER_URL}/api/audit-logs`, {
-            cache: 'no-store'
+            cache: 'no-store',
+            headers: await controllerHeaders(),
         });
         if (!res.ok) {
             throw new Error(`Controller responded: ${res.status}`);
@@ -1,4 +1,5 @@
 import { NextResponse } from 'next/server';
+import { controllerHeaders } from "@/lib/api";
 
 const CONTROLLER_URL = process.env.CONTROLLER_API_URL || 'http://controller:8080';
 
@@ -12,7 +13,7 @@ export async function PUT(
         const body = await request.json();
         const res = await fetch(`${CONTROLLER_URL}/api/channels/${id}`, {
             method: 'PUT',
-            headers: { 'Content-Type': 'application/json' },
+            headers: await controllerHeaders({ 'Content-Type': 'application/json' }),
             body: JSON.stringify(body),
         });
 
@@ -40,6 +41,7 @@ export async function DELETE(
     try {
         const res = await fetch(`${CONTROLLER_URL}/api/channels/${id}`, {
             method: 'DELETE',
+            headers: await controllerHeaders(),
         });
 
         if (!res.ok) {
@@ -1,10 +1,15 @@
 import { NextResponse } from 'next/server';
+import { controllerHeaders } from "@/lib/api";
 
 const CONTROLLER_URL = process.env.CONTROLLER_API_URL || 'http://controller:8080';
 
 export async function GET() {
     try {
-        const res = await fetch(`${CONTROLLER_URL}/api/channels`, { cache: 'no-store' });
+        // The controller omits ingest tokens unless the caller is OPERATOR or above
+        const res = await fetch(`${CONTROLLER_URL}/api/channels`, {
+            cache: 'no-store',
+            headers: await controllerHeaders(),
+        });
         if (!res.ok) {
             throw new Error(`Controller responded: ${res.status}`);
         }
@@ -21,7 +26,7 @@ export async function POST(request: Request) {
         const body = await request.json();
         const res = await fetch(`${CONTROLLER_URL}/api/channels`, {
             method: 'POST',
-            headers: { 'Content-Type': 'application/json' },
+            headers: await controllerHeaders({ 'Content-Type': 'application/json' }),
             body: JSON.stringify(body),
         });
         const data = await res.json();
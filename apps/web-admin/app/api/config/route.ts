@@ -1,10 +1,11 @@
 import { NextResponse } from 'next/server';
+import { controllerHeaders } from "@/lib/api";
 
 const CONTROLLER_URL = process.env.CONTROLLER_API_URL || 'http://controller:8080';
 
 export async function GET() {
     try {
-        const res = await fetch(`${CONTROLLER_URL}/api/config`, { cache: 'no-store' });
+        const res = await fetch(`${CONTROLLER_URL}/api/config`, { cache: 'no-store', headers: await controllerHeaders() });
         if (!res.ok) throw new Error("Failed");
         const data = await res.json();
         return NextResponse.json(data);
@@ -18,7 +19,7 @@ export async function PUT(request: Request) {
         const body = await request.json();
         const res = await fetch(`${CONTROLLER_URL}/api/config`, {
             method: 'PUT',
-            headers: { 'Content-Type': 'application/json' },
+            headers: await controllerHeaders({ 'Content-Type': 'application/json' }),
             body: JSON.stringify(body)
         });
         if (!res.ok) throw new Error("Failed");
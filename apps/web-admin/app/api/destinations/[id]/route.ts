@@ -1,4 +1,5 @@
 import { NextResponse } from 'next/server';
+import { controllerHeaders } from "@/lib/api";
 
 const CONTROLLER_URL = process.env.CONTROLLER_API_URL || 'http://controller:8080';
 
@@ -11,6 +12,7 @@ export async function DELETE(
     try {
         const res = await fetch(`${CONTROLLER_URL}/api/destinations/${id}`, {
             method: 'DELETE',
+            headers: await controllerHeaders(),
         });
 
         if (!res.ok) {
@@ -38,7 +40,7 @@ export async function PUT(
 
         const res = await fetch(`${CONTROLLER_URL}/api/destinations/${id}`, {
             method: 'PUT',
-            headers: { 'Content-Type': 'application/json' },
+            headers: await controllerHeaders({ 'Content-Type': 'application/json' }),
             body: JSON.stringify(body),
         });
 
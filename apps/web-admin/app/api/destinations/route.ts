@@ -1,4 +1,5 @@
 import { NextResponse } from 'next/server';
+import { controllerHeaders } from "@/lib/api";
 
 const CONTROLLER_URL = process.env.CONTROLLER_API_URL || 'http://controller:8080';
 
@@ -7,7 +8,7 @@ export async function POST(request: Request) {
         const body = await request.json();
         const res = await fetch(`${CONTROLLER_URL}/api/destinations`, {
             method: 'POST',
-            headers: { 'Content-Type': 'application/json' },
+            headers: await controllerHeaders({ 'Content-Type': 'application/json' }),
             body: JSON.stringify(body),
         });
 
@@ -1,10 +1,11 @@
 import { NextResponse } from 'next/server';
+import { controllerHeaders } from "@/lib/api";
 
 const CONTROLLER_URL = process.env.CONTROLLER_API_URL || 'http://controller:8080';
 
 export async function GET() {
     try {
-        const res = await fetch(`${CONTROLLER_URL}/api/health/services`, { cache: 'no-store' });
+        const res = await fetch(`${CONTROLLER_URL}/api/health/services`, { cache: 'no-store', headers: await controllerHeaders() });
         if (!res.ok) {
             throw new Error(`Controller responded: ${res.status}`);
         }
@@ -1,4 +1,5 @@
 import { NextResponse } from 'next/server';
+import { controllerHeaders } from "@/lib/api";
 
 const CONTROLLER_URL = process.env.CONTROLLER_API_URL || 'http://controller:8080';
 
@@ -10,7 +11,7 @@ export async function GET(request: Request) {
     try {
         const res = await fetch(
             `${CONTROLLER_URL}/api/logs?level=${level}&limit=${limit}`,
-            { cache: 'no-store' }
+            { cache: 'no-store', headers: await controllerHeaders() }
         );
         if (!res.ok) {
             throw new Error(`Controller responded: ${res.status}`);
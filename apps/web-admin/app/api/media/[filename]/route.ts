@@ -1,4 +1,5 @@
 import { NextResponse } from 'next/server';
+import { controllerHeaders } from "@/lib/api";
 
 const CONTROLLER_URL = process.env.CONTROLLER_API_URL || 'http://controller:8080';
 
@@ -11,6 +12,7 @@ export async function GET(
     try {
         const res = await fetch(`${CONTROLLER_URL}/api/media/${filename}`, {
             method: 'GET',
+            headers: await controllerHeaders(),
         });
 
         if (!res.ok) {
@@ -52,6 +54,7 @@ export async function DELETE(
     try {
         const res = await fetch(`${CONTROLLER_URL}/api/media/${filename}`, {
             method: 'DELETE',
+            headers: await controllerHeaders(),
         });
 
         if (!res.ok) {
@@ -1,22 +1,34 @@
 import { NextResponse } from 'next/server';
+import { controllerHeaders } from "@/lib/api";
 
 const CONTROLLER_URL = process.env.CONTROLLER_API_URL || 'http://controller:8080';
 
 export async function POST(request: Request) {
     try {
         const contentType = request.headers.get("content-type") || "";
+        const contentLength = request.headers.get("content-length");
 
         // We proxy the stream directly to preserve multipart boundaries and efficiency
         // We must pass the Content-Type header so the backend knows the boundary
         const res = await fetch(`${CONTROLLER_URL}/api/media/upload`, {
             method: "POST",
-            headers: {
+            headers: await controllerHeaders({
                 "Content-Type": contentType,
-            },
+                // Lets the controller reject oversized uploads before reading the body
+                ...(contentLength ? { "Content-Length": contentLength } : {}),
+            }),
             body: request.body,
             duplex: 'half', // Required for streaming bodies in fetch
         } as RequestInit & { duplex: string });
 
+        // Pass size/storage/busy rejections through so the UI can explain them
+        if (res.status === 413 || res.status === 507 || res.status === 503) {
+            const retryAfter = res.headers.get("retry-after");
+            return new NextResponse(await res.text(), {
+                status: res.status,
+                headers: retryAfter ? { "Retry-After": retryAfter } : undefined,
+            });
+        }
         if (!res.ok) {
             throw new Error(`Controller responded: ${res.status}`);
         }
@@ -14,11 +14,13 @@ export async function GET(request: NextRequest) {
     // Use env override if set, otherwise use detected host
     const rtmpHost = process.env.RTMP_HOST || process.env.PUBLIC_HOST || detectedHost;
     const rtmpPort = process.env.RTMP_PORT || '1935';
+    const srsApp = process.env.SRS_APP || 'live';
 
     return NextResponse.json({
         rtmp_host: rtmpHost,
         rtmp_port: rtmpPort,
         rtmp_url: rtmpHost ? `rtmp://${rtmpHost}:${rtmpPort}` : '',
+        srs_app: srsApp,
         auto_detected: !process.env.RTMP_HOST && !process.env.PUBLIC_HOST,
     });
 }
@@ -1,6 +1,7 @@
 import { NextResponse } from 'next/server';
 import { getServerSession } from "next-auth";
 import { authOptions } from "@/lib/auth";
+import { controllerHeaders } from "@/lib/api";
 
 const CONTROLLER_URL = process.env.CONTROLLER_API_URL || 'http://controller:8080';
 
@@ -21,7 +22,7 @@ export async function POST(
     try {
         const res = await fetch(`${CONTROLLER_URL}/api/takeover/${channel}`, {
             method: 'POST',
-            headers: { 'Content-Type': 'application/json' }
+            headers: await controllerHeaders({ 'Content-Type': 'application/json' })
         });
 
         if (!res.ok) {
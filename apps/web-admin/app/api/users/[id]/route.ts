@@ -1,4 +1,5 @@
 import { NextResponse } from 'next/server';
+import { controllerHeaders } from "@/lib/api";
 
 const CONTROLLER_URL = process.env.CONTROLLER_API_URL || 'http://controller:8080';
 
@@ -7,7 +8,7 @@ export async function GET(
     { params }: { params: { id: string } }
 ) {
     try {
-        const res = await fetch(`${CONTROLLER_URL}/api/users/${params.id}`);
+        const res = await fetch(`${CONTROLLER_URL}/api/users/${params.id}`, { headers: await controllerHeaders() });
         if (!res.ok) {
             throw new Error(`Controller responded: ${res.status}`);
         }
@@ -27,7 +28,7 @@ export async function PUT(
         const body = await request.json();
         const res = await fetch(`${CONTROLLER_URL}/api/users/${params.id}`, {
             method: 'PUT',
-            headers: { 'Content-Type': 'application/json' },
+            headers: await controllerHeaders({ 'Content-Type': 'application/json' }),
             body: JSON.stringify(body),
         });
 
@@ -49,6 +50,7 @@ export async function DELETE(
     try {
         const res = await fetch(`${CONTROLLER_URL}/api/users/${params.id}`, {
             method: 'DELETE',
+            headers: await controllerHeaders(),
         });
 
         if (!res.ok) {
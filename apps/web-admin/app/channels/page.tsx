@@ -7,7 +7,7 @@ import { Tabs, TabsContent, TabsList, TabsTrigger } from "@/components/ui/tabs";
 import { Switch } from "@/components/ui/switch";
 import {
     Radio, Play, Square, RefreshCw, Eye, EyeOff, Copy, Plus, Trash2, Save, X, Pencil,
-    Tv, Settings2, Send, Zap, Activity, CheckCircle2, XCircle, AlertCircle,
+    Tv, Settings2, Send, Zap, Activity, CheckCircle2, XCircle, AlertCircle, Pause,
 } from "lucide-react";
 
 interface Destination {
@@ -17,7 +17,12 @@ interface Destination {
     rtmp_url: string;
     stream_key?: string;
     enabled: boolean;
+    paused?: boolean;
     status: string;
+    transcode_enabled?: boolean;
+    transcode_resolution?: string;
+    transcode_video_bitrate?: number;
+    transcode_audio_bitrate?: number;
 }
 
 interface Channel {
@@ -29,6 +34,8 @@ interface Channel {
     obs_token: string;
     loop_token: string;
     loop_source_file: string;
+    source_mode: string;
+    playlist_files: string[];
     active_source: string;
     loop_enabled: boolean;
     obs_override_enabled: boolean;
@@ -38,9 +45,30 @@ interface Channel {
     video_bitrate: number;
     audio_bitrate: number;
     output_resolution: string;
+    output_fps: number;
+    encoder_preset: string;
+    encoder_tune: string;
+    obs_rw_timeout_ms: number;
+    relay_probesize_kb?: number;
+    relay_analyzeduration_ms?: number;
+    reconcile_every: number;
+    adaptive_bitrate: boolean;
+    abr_low_bitrate: number;
+    abr_high_bitrate: number;
+    abr_viewer_threshold: number;
+    abr_ladder_enabled?: boolean;
+    ingest_protocol?: string;
+    recording_enabled?: boolean;
+    external_source_url?: string;
+    primary_source?: string;
+    paused?: boolean;
     bitrate: number;
     uptime: string;
     destinations: Destination[];
+    max_destinations: number;
+    enabled_destinations: number;
+    ingest_hint?: string;
+    crash_loop?: { fast_exits: number; tripped: boolean; exit_code: number; last_logs: string[] };
 }
 
 interface ChannelCardProps {
@@ -49,25 +77,29 @@ interface ChannelCardProps {
     onAction: (id: number, action: string) => Promise<void>;
     onAddDestination: (dest: Partial<Destination>) => Promise<void>;
     onToggleDestination: (id: number, enabled: boolean) => Promise<void>;
+    onPauseDestination: (id: number, paused: boolean) => Promise<void>;
     onDeleteDestination: (id: number) => Promise<void>;
     onUpdateChannel: (id: number, settings: Record<string, unknown>) => Promise<void>;
     onDeleteChannel: (id: number) => Promise<void>;
     onUpdateDestination: (id: number, updates: Partial<Destination>) => Promise<void>;
 }
 
-function ChannelCard({ channel, mediaFiles, onAction, onAddDestination, onToggleDestination, onDeleteDestination, onUpdateChannel, onDeleteChannel, onUpdateDestination }: ChannelCardProps) {
+function ChannelCard({ channel, mediaFiles, onAction, onAddDestination, onToggleDestination, onPauseDestination, onDeleteDestination, onUpdateChannel, onDeleteChannel, onUpdateDestination }: ChannelCardProps) {
     const [showOBSToken, setShowOBSToken] = useState(false);
     const [showLoopToken, setShowLoopToken] = useState(false);
     const [loading, setLoading] = useState<string | null>(null);
     const [isAddingDest, setIsAddingDest] = useState(false);
     const [newDest, setNewDest] = useState({ name: "", rtmp_url: "", stream_key: "" });
     const [editingDestId, setEditingDestId] = useState<number | null>(null);
-    const [editDest, setEditDest] = useState({ name: "", rtmp_url: "", stream_key: "" });
+    const [editDest, setEditDest] = useState({ name: "", rtmp_url: "", stream_key: "", transcode_enabled: false, transcode_resolution: "", transcode_video_bitrate: 0, transcode_audio_bitrate: 0 });
     const [isDirty, setIsDirty] = useState(false);
     const [hostname, setHostname] = useState("localhost");
+    const [srsApp, setSrsApp] = useState("live");
     const [settings, setSettings] = useState({
         display_name: channel.display_name,
         loop_source_file: channel.loop_source_file,
+        source_mode: channel.source_mode || "file",
+        playlist_files: channel.playlist_files || [],
         obs_override_enabled: channel.obs_override_enabled,
         auto_restart_loop: channel.auto_restart_loop,
         loop_enabled: channel.loop_enabled,
@@ -75,7 +107,23 @@ function ChannelCard({ channel, mediaFiles, onAction, onAddDestination, onToggle
         keyframe_interval: channel.keyframe_interval || 2,
         video_bitrate: channel.video_bitrate || 0,
         audio_bitrate: channel.audio_bitrate || 128,
-        output_resolution: channel.output_resolution || ""
+        output_resolution: channel.output_resolution || "",
+        output_fps: channel.output_fps || 30,
+        encoder_preset: channel.encoder_preset || "ultrafast",
+        encoder_tune: channel.encoder_tune || "zerolatency",
+        obs_rw_timeout_ms: channel.obs_rw_timeout_ms || 5000,
+        relay_probesize_kb: channel.relay_probesize_kb || 32000,
+        relay_analyzeduration_ms: channel.relay_analyzeduration_ms || 100,
+        reconcile_every: channel.reconcile_every || 1,
+        adaptive_bitrate: channel.adaptive_bitrate || false,
+        abr_low_bitrate: channel.abr_low_bitrate || 1000,
+        abr_high_bitrate: channel.abr_high_bitrate || 0,
+        abr_viewer_threshold: channel.abr_viewer_threshold || 1,
+        abr_ladder_enabled: channel.abr_ladder_enabled || false,
+        ingest_protocol: channel.ingest_protocol || "rtmp",
+        recording_enabled: channel.recording_enabled || false,
+        external_source_url: channel.external_source_url || "",
+        primary_source: channel.primary_source || "loop"
     });
 
     useEffect(() => {
@@ -84,6 +132,7 @@ function ChannelCard({ channel, mediaFiles, onAction, onAddDestination, onToggle
                 const res = await fetch('/api/server-info');
                 if (res.ok) {
                     const data = await res.json();
+                    if (data.srs_app) setSrsApp(data.srs_app);
                     if (data.rtmp_host) { setHostname(data.rtmp_host); return; }
                 }
             } catch { /* ignore */ }
@@ -102,6 +151,8 @@ function ChannelCard({ channel, mediaFiles, onAction, onAddDestination, onToggle
             setSettings({
                 display_name: channel.display_name,
                 loop_source_file: channel.loop_source_file,
+                source_mode: channel.source_mode || "file",
+                playlist_files: channel.playlist_files || [],
                 obs_override_enabled: channel.obs_override_enabled,
                 auto_restart_loop: channel.auto_restart_loop,
                 loop_enabled: channel.loop_enabled,
@@ -109,10 +160,26 @@ function ChannelCard({ channel, mediaFiles, onAction, onAddDestination, onToggle
                 keyframe_interval: channel.keyframe_interval || 2,
                 video_bitrate: channel.video_bitrate || 0,
                 audio_bitrate: channel.audio_bitrate || 128,
-                output_resolution: channel.output_resolution || ""
+                output_resolution: channel.output_resolution || "",
+                output_fps: channel.output_fps || 30,
+                encoder_preset: channel.encoder_preset || "ultrafast",
+                encoder_tune: channel.encoder_tune || "zerolatency",
+                obs_rw_timeout_ms: channel.obs_rw_timeout_ms || 5000,
+                relay_probesize_kb: channel.relay_probesize_kb || 32000,
+                relay_analyzeduration_ms: channel.relay_analyzeduration_ms || 100,
+                reconcile_every: channel.reconcile_every || 1,
+                adaptive_bitrate: channel.adaptive_bitrate || false,
+                abr_low_bitrate: channel.abr_low_bitrate || 1000,
+                abr_high_bitrate: channel.abr_high_bitrate || 0,
+                abr_viewer_threshold: channel.abr_viewer_threshold || 1,
+                abr_ladder_enabled: channel.abr_ladder_enabled || false,
+                ingest_protocol: channel.ingest_protocol || "rtmp",
+                recording_enabled: channel.recording_enabled || false,
+                external_source_url: channel.external_source_url || "",
+                primary_source: channel.primary_source || "loop"
             });
         }
-    }, [channel.id, isDirty, channel.display_name, channel.loop_source_file, channel.obs_override_enabled, channel.auto_restart_loop, channel.loop_enabled, channel.failover_timeout_seconds, channel.keyframe_interval, channel.video_bitrate, channel.audio_bitrate, channel.output_resolution]);
+    }, [channel.id, isDirty, channel.display_name, channel.loop_source_file, channel.obs_override_enabled, channel.auto_restart_loop, channel.loop_enabled, channel.failover_timeout_seconds, channel.keyframe_interval, channel.video_bitrate, channel.audio_bitrate, channel.output_resolution, channel.output_fps, channel.encoder_preset, channel.encoder_tune, channel.obs_rw_timeout_ms, channel.relay_probesize_kb, channel.relay_analyzeduration_ms, channel.source_mode, channel.playlist_files, channel.reconcile_every, channel.adaptive_bitrate, channel.abr_low_bitrate, channel.abr_high_bitrate, channel.abr_viewer_threshold, channel.abr_ladder_enabled, channel.ingest_protocol, channel.recording_enabled, channel.external_source_url, channel.primary_source]);
 
     const copyToClipboard = (text: string) => { navigator.clipboard.writeText(text); };
 
@@ -183,12 +250,30 @@ function ChannelCard({ channel, mediaFiles, onAction, onAddDestination, onToggle
                     <div className="flex items-center gap-3">
                         <div className="text-right">
                             <p className="text-sm font-medium">{connectedDests}/{totalDests}</p>
-                            <p className="text-xs text-muted-foreground">Destinations</p>
+                            <p className="text-xs text-muted-foreground">Destinations{channel.max_destinations > 0 && ` (max ${channel.max_destinations})`}</p>
                         </div>
                         <Switch checked={channel.enabled} onCheckedChange={() => handleAction(channel.enabled ? 'disable' : 'enable')} disabled={loading !== null} />
                     </div>
                 </div>
 
+                {channel.ingest_hint && (
+                    <div className="flex items-start gap-2 mt-4 p-3 rounded-lg border border-amber-500/30 bg-amber-500/5 text-sm text-amber-600">
+                        <AlertCircle className="h-4 w-4 mt-0.5 shrink-0" />
+                        <span>{channel.ingest_hint}</span>
+                    </div>
+                )}
+                {channel.crash_loop?.tripped && (
+                    <div className="flex items-start gap-2 mt-4 p-3 rounded-lg border border-red-500/30 bg-red-500/5 text-sm text-red-600">
+                        <XCircle className="h-4 w-4 mt-0.5 shrink-0" />
+                        <div className="min-w-0">
+                            <p>Loop container exited {channel.crash_loop.fast_exits} times right after starting (exit code {channel.crash_loop.exit_code}). Fix the source and restart the loop.</p>
+                            {channel.crash_loop.last_logs?.length > 0 && (
+                                <pre className="mt-2 text-xs whitespace-pre-wrap break-all opacity-80">{channel.crash_loop.last_logs.join("\n")}</pre>
+                            )}
+                        </div>
+                    </div>
+                )}
+
                 <div className="flex items-center gap-2 mt-4 pt-4 border-t border-border/50">
                     <span className="text-sm text-muted-foreground mr-2">Source:</span>
                     <Button size="sm" variant={channel.active_source === "LOOP" ? "default" : "outline"} onClick={() => handleAction('switch-to-loop')} disabled={loading !== null || channel.active_source === "LOOP"} className={channel.active_source === "LOOP" ? "bg-blue-500 hover:bg-blue-600" : ""}>
@@ -198,6 +283,11 @@ function ChannelCard({ channel, mediaFiles, onAction, onAddDestination, onToggle
                         <Tv className="h-4 w-4 mr-1" /> OBS
                     </Button>
                     <div className="flex-1" />
+                    {channel.enabled && (
+                        <Button size="sm" variant="ghost" onClick={() => handleAction(channel.paused ? 'resume' : 'pause')} disabled={loading !== null} className="text-muted-foreground">
+                            {channel.paused ? <><Play className="h-4 w-4 mr-1" /> Resume</> : <><Pause className="h-4 w-4 mr-1" /> Pause</>}
+                        </Button>
+                    )}
                     <Button size="sm" variant="ghost" onClick={() => handleAction('restart')} disabled={loading !== null} className="text-muted-foreground">
                         <RefreshCw className={`h-4 w-4 mr-1 ${loading === 'restart' ? 'animate-spin' : ''}`} /> Restart
                     </Button>
@@ -223,8 +313,8 @@ function ChannelCard({ channel, mediaFiles, onAction, onAddDestination, onToggle
                                 <div>
                                     <label className="text-xs font-medium text-muted-foreground uppercase tracking-wider">Server URL</label>
                                     <div className="flex items-center gap-2 mt-1">
-                                        <code className="flex-1 text-sm bg-background/80 p-3 rounded-lg border font-mono">rtmp://{hostname}:1935/live</code>
-                                        <Button size="icon" variant="ghost" onClick={() => copyToClipboard(`rtmp://${hostname}:1935/live`)}><Copy className="h-4 w-4" /></Button>
+                                        <code className="flex-1 text-sm bg-background/80 p-3 rounded-lg border font-mono">rtmp://{hostname}:1935/{srsApp}</code>
+                                        <Button size="icon" variant="ghost" onClick={() => copyToClipboard(`rtmp://${hostname}:1935/${srsApp}`)}><Copy className="h-4 w-4" /></Button>
                                     </div>
                                 </div>
                                 <div>
@@ -271,6 +361,17 @@ function ChannelCard({ channel, mediaFiles, onAction, onAddDestination, onToggle
                                             <input className="w-full h-10 rounded-lg border bg-background px-3 text-sm" placeholder="Name" value={editDest.name} onChange={(e) => setEditDest({ ...editDest, name: e.target.value })} />
                                             <input className="w-full h-10 rounded-lg border bg-background px-3 text-sm font-mono" placeholder="RTMP URL" value={editDest.rtmp_url} onChange={(e) => setEditDest({ ...editDest, rtmp_url: e.target.value })} />
                                             <input type="password" className="w-full h-10 rounded-lg border bg-background px-3 text-sm" placeholder="Stream Key (leave empty to keep)" value={editDest.stream_key} onChange={(e) => setEditDest({ ...editDest, stream_key: e.target.value })} />
+                                            <label className="flex items-center gap-2 text-sm">
+                                                <input type="checkbox" checked={editDest.transcode_enabled} onChange={(e) => setEditDest({ ...editDest, transcode_enabled: e.target.checked })} />
+                                                Separate transcode for this destination
+                                            </label>
+                                            {editDest.transcode_enabled && (
+                                                <div className="grid grid-cols-3 gap-2">
+                                                    <input className="h-10 rounded-lg border bg-background px-3 text-sm font-mono" placeholder="1280x720" value={editDest.transcode_resolution} onChange={(e) => setEditDest({ ...editDest, transcode_resolution: e.target.value })} />
+                                                    <input type="number" min={0} className="h-10 rounded-lg border bg-background px-3 text-sm" placeholder="Video kbps" value={editDest.transcode_video_bitrate || ""} onChange={(e) => setEditDest({ ...editDest, transcode_video_bitrate: parseInt(e.target.value) || 0 })} />
+                                                    <input type="number" min={0} className="h-10 rounded-lg border bg-background px-3 text-sm" placeholder="Audio kbps" value={editDest.transcode_audio_bitrate || ""} onChange={(e) => setEditDest({ ...editDest, transcode_audio_bitrate: parseInt(e.target.value) || 0 })} />
+                                                </div>
+                                            )}
                                         </div>
                                         <div className="flex gap-2 justify-end">
                                             <Button variant="ghost" size="sm" onClick={() => setEditingDestId(null)}>Cancel</Button>
@@ -284,6 +385,11 @@ function ChannelCard({ channel, mediaFiles, onAction, onAddDestination, onToggle
                                             <div className="space-y-1">
                                                 <p className="font-medium">{dest.name}</p>
                                                 <p className="text-xs text-muted-foreground font-mono truncate max-w-[300px]">{dest.rtmp_url}</p>
+                                                {dest.transcode_enabled && (
+                                                    <p className="text-xs text-muted-foreground">
+                                                        Transcode: {dest.transcode_resolution || "channel resolution"}{dest.transcode_video_bitrate ? ` @ ${dest.transcode_video_bitrate}k` : ""}
+                                                    </p>
+                                                )}
                                                 {dest.stream_key && (
                                                     <p className="text-xs font-mono bg-muted/50 px-2 py-1 rounded inline-block">
                                                         Key: {dest.stream_key}
@@ -301,13 +407,18 @@ function ChannelCard({ channel, mediaFiles, onAction, onAddDestination, onToggle
                                                     <Play className="h-3 w-3 mr-1" /> Start
                                                 </Button>
                                             )}
+                                            {dest.enabled && (
+                                                <Button size="sm" variant="ghost" onClick={() => onPauseDestination(dest.id, !dest.paused)} title={dest.paused ? "Resume sending" : "Pause without disabling"}>
+                                                    {dest.paused ? <Play className="h-3 w-3" /> : <Pause className="h-3 w-3" />}
+                                                </Button>
+                                            )}
                                             <Button size="sm" variant="ghost" onClick={async () => { await onToggleDestination(dest.id, false); setTimeout(() => onToggleDestination(dest.id, true), 1000); }} title="Restart connection">
                                                 <RefreshCw className="h-3 w-3" />
                                             </Button>
-                                            <span className={`inline-flex items-center rounded-full px-2 py-0.5 text-xs font-semibold ${dest.status === "CONNECTED" ? "bg-emerald-500 text-white" : dest.enabled ? "bg-amber-500 text-white" : "bg-gray-400 text-white"}`}>
-                                                {dest.enabled ? dest.status : "STOPPED"}
+                                            <span className={`inline-flex items-center rounded-full px-2 py-0.5 text-xs font-semibold ${dest.status === "CONNECTED" ? "bg-emerald-500 text-white" : dest.enabled && !dest.paused ? "bg-amber-500 text-white" : "bg-gray-400 text-white"}`}>
+                                                {!dest.enabled ? "STOPPED" : dest.paused ? "PAUSED" : dest.status}
                                             </span>
-                                            <Button size="icon" variant="ghost" onClick={() => { setEditingDestId(dest.id); setEditDest({ name: dest.name, rtmp_url: dest.rtmp_url, stream_key: dest.stream_key || "" }); }}><Pencil className="h-4 w-4" /></Button>
+                                            <Button size="icon" variant="ghost" onClick={() => { setEditingDestId(dest.id); setEditDest({ name: dest.name, rtmp_url: dest.rtmp_url, stream_key: dest.stream_key || "", transcode_enabled: !!dest.transcode_enabled, transcode_resolution: dest.transcode_resolution || "", transcode_video_bitrate: dest.transcode_video_bitrate || 0, transcode_audio_bitrate: dest.transcode_audio_bitrate || 0 }); }}><Pencil className="h-4 w-4" /></Button>
                                             <Button size="icon" variant="ghost" className="text-destructive" onClick={() => onDeleteDestination(dest.id)}><Trash2 className="h-4 w-4" /></Button>
                                         </div>
                                     </div>
@@ -345,13 +456,51 @@ function ChannelCard({ channel, mediaFiles, onAction, onAddDestination, onToggle
                             </div>
 
                             <div className="p-4 rounded-xl border">
-                                <label className="text-sm font-medium">Loop Source File</label>
-                                <select className="w-full h-10 rounded-lg border bg-background px-3 text-sm mt-2" value={settings.loop_source_file} onChange={(e) => updateSettings({ loop_source_file: e.target.value })}>
-                                    <option value="">Select a file...</option>
-                                    {mediaFiles.filter((f: string) => !f.includes('.temp') && !f.includes('.original')).map((f: string) => <option key={f} value={f}>{f}</option>)}
+                                <label className="text-sm font-medium">Loop Source Mode</label>
+                                <select className="w-full h-10 rounded-lg border bg-background px-3 text-sm mt-2" value={settings.source_mode} onChange={(e) => updateSettings({ source_mode: e.target.value })}>
+                                    <option value="file">Single file</option>
+                                    <option value="playlist">Playlist</option>
+                                    <option value="testpattern">Test pattern (bars + tone)</option>
+                                    <option value="external">External source (RTMP/HLS)</option>
                                 </select>
+                                {settings.source_mode === 'testpattern' && <p className="text-xs text-muted-foreground mt-2">Streams SMPTE bars with a tone and timecode. Useful for checking destinations without uploading media.</p>}
+                                {settings.source_mode === 'external' && (
+                                    <>
+                                        <input className="w-full h-10 rounded-lg border bg-background px-3 text-sm mt-2 font-mono" placeholder="rtmp://cdn.example.com/live/stream or https://.../index.m3u8" value={settings.external_source_url} onChange={(e) => updateSettings({ external_source_url: e.target.value })} />
+                                        <p className="text-xs text-muted-foreground mt-2">Relayed in place of OBS while reachable; the loop file below plays when it isn't.</p>
+                                    </>
+                                )}
                             </div>
 
+                            <div className="p-4 rounded-xl border">
+                                <label className="text-sm font-medium">Primary Source on Boot</label>
+                                <select className="w-full h-10 rounded-lg border bg-background px-3 text-sm mt-2" value={settings.primary_source} onChange={(e) => updateSettings({ primary_source: e.target.value })}>
+                                    <option value="loop">Loop</option>
+                                    <option value="obs">Wait for OBS</option>
+                                </select>
+                                {settings.primary_source === 'obs' && <p className="text-xs text-muted-foreground mt-2">The loop stays off and the channel shows WAITING_FOR_OBS until OBS first publishes; after that the loop covers OBS drops as usual.</p>}
+                            </div>
+
+                            {(settings.source_mode === 'file' || settings.source_mode === 'external') && (
+                                <div className="p-4 rounded-xl border">
+                                    <label className="text-sm font-medium">Loop Source File</label>
+                                    <select className="w-full h-10 rounded-lg border bg-background px-3 text-sm mt-2" value={settings.loop_source_file} onChange={(e) => updateSettings({ loop_source_file: e.target.value })}>
+                                        <option value="">Select a file...</option>
+                                        {mediaFiles.filter((f: string) => !f.includes('.temp') && !f.includes('.original')).map((f: string) => <option key={f} value={f}>{f}</option>)}
+                                    </select>
+                                </div>
+                            )}
+
+                            {settings.source_mode === 'playlist' && (
+                                <div className="p-4 rounded-xl border">
+                                    <label className="text-sm font-medium">Playlist Files</label>
+                                    <select multiple className="w-full h-32 rounded-lg border bg-background px-3 text-sm mt-2" value={settings.playlist_files} onChange={(e) => updateSettings({ playlist_files: Array.from(e.target.selectedOptions).map((o) => o.value) })}>
+                                        {mediaFiles.filter((f: string) => !f.includes('.temp') && !f.includes('.original')).map((f: string) => <option key={f} value={f}>{f}</option>)}
+                                    </select>
+                                    <p className="text-xs text-muted-foreground mt-1">Played in list order and looped. Files should share the same encoding.</p>
+                                </div>
+                            )}
+
                             <div className="grid grid-cols-1 md:grid-cols-2 gap-4">
                                 <div className="flex items-center justify-between p-4 rounded-xl border">
                                     <div><p className="font-medium text-sm">OBS Override</p><p className="text-xs text-muted-foreground">Auto-switch when OBS connects</p></div>
@@ -367,7 +516,30 @@ function ChannelCard({ channel, mediaFiles, onAction, onAddDestination, onToggle
                                 </div>
                                 <div className="flex items-center justify-between p-4 rounded-xl border">
                                     <div><p className="font-medium text-sm">Failover Timeout</p><p className="text-xs text-muted-foreground">Seconds before switch</p></div>
-                                    <input type="number" className="w-20 h-8 rounded border bg-background px-2 text-sm text-center" value={settings.failover_timeout_seconds} onChange={(e) => updateSettings({ failover_timeout_seconds: parseInt(e.target.value) || 0 })} />
+                                    <input type="number" className="w-20 h-8 rounded border bg-background px-2 text-sm text-center" min={2} max={300} value={settings.failover_timeout_seconds} onChange={(e) => updateSettings({ failover_timeout_seconds: parseInt(e.target.value) || 0 })} />
+                                </div>
+                                <div className="flex items-center justify-between p-4 rounded-xl border">
+                                    <div><p className="font-medium text-sm">Ingest Protocol</p><p className="text-xs text-muted-foreground">WebRTC also matches WHIP publishes in other SRS apps</p></div>
+                                    <select className="h-8 rounded border bg-background px-2 text-sm" value={settings.ingest_protocol} onChange={(e) => updateSettings({ ingest_protocol: e.target.value })}>
+                                        <option value="rtmp">RTMP</option>
+                                        <option value="webrtc">WebRTC (WHIP)</option>
+                                    </select>
+                                </div>
+                                <div className="flex items-center justify-between p-4 rounded-xl border">
+                                    <div><p className="font-medium text-sm">OBS Read Timeout</p><p className="text-xs text-muted-foreground">ms of silence before failover (1000-60000)</p></div>
+                                    <input type="number" min="1000" max="60000" step="500" className="w-20 h-8 rounded border bg-background px-2 text-sm text-center" value={settings.obs_rw_timeout_ms} onChange={(e) => updateSettings({ obs_rw_timeout_ms: parseInt(e.target.value) || 5000 })} />
+                                </div>
+                                <div className="flex items-center justify-between p-4 rounded-xl border">
+                                    <div><p className="font-medium text-sm">Relay Probe Size</p><p className="text-xs text-muted-foreground">KB inspected before encoding (lower starts faster)</p></div>
+                                    <input type="number" min="32" max="100000" step="1000" className="w-20 h-8 rounded border bg-background px-2 text-sm text-center" value={settings.relay_probesize_kb} onChange={(e) => updateSettings({ relay_probesize_kb: parseInt(e.target.value) || 32000 })} />
+                                </div>
+                                <div className="flex items-center justify-between p-4 rounded-xl border">
+                                    <div><p className="font-medium text-sm">Relay Analyze Time</p><p className="text-xs text-muted-foreground">ms analyzed before encoding (1-10000)</p></div>
+                                    <input type="number" min="1" max="10000" step="50" className="w-20 h-8 rounded border bg-background px-2 text-sm text-center" value={settings.relay_analyzeduration_ms} onChange={(e) => updateSettings({ relay_analyzeduration_ms: parseInt(e.target.value) || 100 })} />
+                                </div>
+                                <div className="flex items-center justify-between p-4 rounded-xl border">
+                                    <div><p className="font-medium text-sm">Check Every</p><p className="text-xs text-muted-foreground">Reconcile cycles (1 = critical, up to 30)</p></div>
+                                    <input type="number" min="1" max="30" className="w-20 h-8 rounded border bg-background px-2 text-sm text-center" value={settings.reconcile_every} onChange={(e) => updateSettings({ reconcile_every: parseInt(e.target.value) || 1 })} />
                                 </div>
                             </div>
 
@@ -387,7 +559,57 @@ function ChannelCard({ channel, mediaFiles, onAction, onAddDestination, onToggle
                                         <label className="text-xs font-medium text-muted-foreground">Keyframe Interval (s)</label>
                                         <input type="number" min="1" max="10" className="w-full h-10 rounded-lg border bg-background px-3 text-sm mt-1" value={settings.keyframe_interval} onChange={(e) => updateSettings({ keyframe_interval: parseInt(e.target.value) || 2 })} />
                                     </div>
+                                    <div>
+                                        <label className="text-xs font-medium text-muted-foreground">Output FPS</label>
+                                        <select className="w-full h-10 rounded-lg border bg-background px-3 text-sm mt-1" value={settings.output_fps} onChange={(e) => updateSettings({ output_fps: parseInt(e.target.value) })}>
+                                            {[24, 25, 30, 50, 60].map((fps) => <option key={fps} value={fps}>{fps}</option>)}
+                                        </select>
+                                    </div>
+                                    <div>
+                                        <label className="text-xs font-medium text-muted-foreground">Encoder Preset</label>
+                                        <select className="w-full h-10 rounded-lg border bg-background px-3 text-sm mt-1" value={settings.encoder_preset} onChange={(e) => updateSettings({ encoder_preset: e.target.value })}>
+                                            {["ultrafast", "superfast", "veryfast", "faster", "fast", "medium", "slow", "slower", "veryslow"].map((p) => <option key={p} value={p}>{p}</option>)}
+                                        </select>
+                                        <p className="text-xs text-muted-foreground mt-1">Slower = better quality, more latency/CPU</p>
+                                    </div>
+                                    <div>
+                                        <label className="text-xs font-medium text-muted-foreground">Encoder Tune</label>
+                                        <select className="w-full h-10 rounded-lg border bg-background px-3 text-sm mt-1" value={settings.encoder_tune} onChange={(e) => updateSettings({ encoder_tune: e.target.value })}>
+                                            {["zerolatency", "none", "film", "animation", "grain", "stillimage", "fastdecode", "psnr", "ssim"].map((t) => <option key={t} value={t}>{t}</option>)}
+                                        </select>
+                                    </div>
+                                </div>
+                                <div className="flex items-center justify-between mt-4 pt-4 border-t">
+                                    <div><p className="font-medium text-sm">Adaptive Bitrate</p><p className="text-xs text-muted-foreground">Encode at a lower bitrate while few viewers are watching</p></div>
+                                    <Switch checked={settings.adaptive_bitrate} onCheckedChange={(c: boolean) => updateSettings({ adaptive_bitrate: c })} />
                                 </div>
+                                {settings.adaptive_bitrate && (
+                                    <div className="grid grid-cols-2 md:grid-cols-3 gap-4 mt-4">
+                                        <div>
+                                            <label className="text-xs font-medium text-muted-foreground">Low Bitrate (kbps)</label>
+                                            <input type="number" className="w-full h-10 rounded-lg border bg-background px-3 text-sm mt-1" value={settings.abr_low_bitrate} onChange={(e) => updateSettings({ abr_low_bitrate: parseInt(e.target.value) || 1000 })} />
+                                        </div>
+                                        <div>
+                                            <label className="text-xs font-medium text-muted-foreground">High Bitrate (kbps)</label>
+                                            <input type="number" className="w-full h-10 rounded-lg border bg-background px-3 text-sm mt-1" value={settings.abr_high_bitrate} onChange={(e) => updateSettings({ abr_high_bitrate: parseInt(e.target.value) || 0 })} />
+                                            <p className="text-xs text-muted-foreground mt-1">0 = Video Bitrate</p>
+                                        </div>
+                                        <div>
+                                            <label className="text-xs font-medium text-muted-foreground">Viewer Threshold</label>
+                                            <input type="number" min="1" className="w-full h-10 rounded-lg border bg-background px-3 text-sm mt-1" value={settings.abr_viewer_threshold} onChange={(e) => updateSettings({ abr_viewer_threshold: parseInt(e.target.value) || 1 })} />
+                                            <p className="text-xs text-muted-foreground mt-1">Viewers needed for the high bitrate</p>
+                                        </div>
+                                    </div>
+                                )}
+                                <div className="flex items-center justify-between mt-4 pt-4 border-t">
+                                    <div><p className="font-medium text-sm">ABR Ladder</p><p className="text-xs text-muted-foreground">Also publish 1080p/720p/480p renditions for adaptive playback (CPU-heavy)</p></div>
+                                    <Switch checked={settings.abr_ladder_enabled} onCheckedChange={(c: boolean) => updateSettings({ abr_ladder_enabled: c })} />
+                                </div>
+                            </div>
+
+                            <div className="flex items-center justify-between p-4 rounded-xl border">
+                                <div><p className="font-medium text-sm">Record Stream</p><p className="text-xs text-muted-foreground">Save the output as MP4 segments under recordings/{channel.name}</p></div>
+                                <Switch checked={settings.recording_enabled} onCheckedChange={(c: boolean) => updateSettings({ recording_enabled: c })} />
                             </div>
 
                             <div className="flex items-center justify-between pt-4 border-t">
@@ -441,6 +663,11 @@ export default function ChannelsPage() {
         await fetchChannels();
     };
 
+    const handlePauseDestination = async (id: number, paused: boolean) => {
+        await fetch(`/api/destinations/${id}/${paused ? 'pause' : 'resume'}`, { method: 'POST' });
+        await fetchChannels();
+    };
+
     const handleDeleteDestination = async (id: number) => {
         if (!confirm('Delete this destination?')) return;
         await fetch(`/api/destinations/${id}`, { method: 'DELETE' });
@@ -536,7 +763,7 @@ export default function ChannelsPage() {
             <div className="space-y-6">
                 {channels.length > 0 ? (
                     channels.map((channel: Channel) => (
-                        <ChannelCard key={channel.id} channel={channel} mediaFiles={mediaFiles} onAction={handleAction} onAddDestination={handleAddDestination} onToggleDestination={handleToggleDestination} onDeleteDestination={handleDeleteDestination} onUpdateChannel={handleUpdateChannel} onDeleteChannel={handleDeleteChannel} onUpdateDestination={handleUpdateDestination} />
+                        <ChannelCard key={channel.id} channel={channel} mediaFiles={mediaFiles} onAction={handleAction} onAddDestination={handleAddDestination} onToggleDestination={handleToggleDestination} onPauseDestination={handlePauseDestination} onDeleteDestination={handleDeleteDestination} onUpdateChannel={handleUpdateChannel} onDeleteChannel={handleDeleteChannel} onUpdateDestination={handleUpdateDestination} />
                     ))
                 ) : !isCreating && (
                     <Card className="border-dashed">
@@ -36,6 +36,8 @@ export default function MediaPage() {
     const [isDragging, setIsDragging] = useState(false);
     const [error, setError] = useState<string | null>(null);
     const [success, setSuccess] = useState<string | null>(null);
+    const [maxUploadBytes, setMaxUploadBytes] = useState<number | null>(null);
+    const [allowedExtensions, setAllowedExtensions] = useState<string[]>([".mp4", ".mov", ".mkv"]);
     const fileInputRef = useRef<HTMLInputElement>(null);
 
     const fetchFiles = async () => {
@@ -72,6 +74,18 @@ export default function MediaPage() {
         }
     };
 
+    useEffect(() => {
+        fetch("/api/media/limits")
+            .then((res) => (res.ok ? res.json() : null))
+            .then((data) => {
+                if (data?.max_upload_bytes) setMaxUploadBytes(data.max_upload_bytes);
+                if (Array.isArray(data?.allowed_extensions) && data.allowed_extensions.length > 0) {
+                    setAllowedExtensions(data.allowed_extensions);
+                }
+            })
+            .catch(() => { /* server still enforces the limit */ });
+    }, []);
+
     useEffect(() => {
         fetchFiles();
         fetchChannels();
@@ -106,6 +120,10 @@ export default function MediaPage() {
     };
 
     const handleUpload = async (file: File) => {
+        if (maxUploadBytes && file.size > maxUploadBytes) {
+            setError(`${file.name} is larger than the ${(maxUploadBytes / (1 << 30)).toFixed(1)} GB upload limit.`);
+            return;
+        }
         setUploading(true);
         setUploadProgress(0);
         setError(null);
@@ -125,6 +143,13 @@ export default function MediaPage() {
                 if (xhr.status === 200) {
                     setSuccess(`${file.name} uploaded successfully`);
                     fetchFiles();
+                } else if (xhr.status === 413) {
+                    setError("File is larger than the server's upload limit.");
+                } else if (xhr.status === 507) {
+                    setError("Not enough disk space on the server for this file.");
+                } else if (xhr.status === 503) {
+                    const retryAfter = xhr.getResponseHeader("Retry-After");
+                    setError(`The server is busy with other uploads. Try again${retryAfter ? ` in ${retryAfter} seconds` : " shortly"}.`);
                 } else {
                     setError("Upload failed. Please try again.");
                 }
@@ -347,7 +372,7 @@ export default function MediaPage() {
                     <input
                         ref={fileInputRef}
                         type="file"
-                        accept=".mp4,.mov,.mkv,.avi,.webm"
+                        accept={allowedExtensions.join(",")}
                         className="hidden"
                         onChange={handleFileSelect}
                         disabled={uploading}
@@ -399,7 +424,7 @@ export default function MediaPage() {
                                             {isDragging ? "Drop to upload" : "Drag & drop video files"}
                                         </p>
                                         <p className="text-sm text-muted-foreground">
-                                            or click to browse • Supports MP4, MOV, MKV, AVI, WebM
+                                            or click to browse • Supports {allowedExtensions.map((e) => e.replace(/^\./, "").toUpperCase()).join(", ")}
                                         </p>
                                     </div>
                                     <Badge variant="secondary" className="mt-2">
@@ -20,6 +20,8 @@ import {
     Wifi,
     Server,
     ArrowUpRight,
+    Loader2,
+    Pause,
 } from "lucide-react";
 
 interface Channel {
@@ -47,6 +49,14 @@ interface SystemStatus {
 }
 
 function getStatusBadge(status: string, activeSource: string) {
+    if (status === "PAUSED") {
+        return (
+            <Badge className="gap-1.5 bg-slate-500/20 text-slate-600 dark:text-slate-400 border-slate-500/30 hover:bg-slate-500/30">
+                <Pause className="h-3 w-3" />
+                PAUSED
+            </Badge>
+        );
+    }
     if (status === "LIVE" || activeSource === "OBS") {
         return (
             <Badge className="gap-1.5 bg-emerald-500/20 text-emerald-600 dark:text-emerald-400 border-emerald-500/30 hover:bg-emerald-500/30">
@@ -58,6 +68,22 @@ function getStatusBadge(status: string, activeSource: string) {
             </Badge>
         );
     }
+    if (status === "STARTING") {
+        return (
+            <Badge className="gap-1.5 bg-sky-500/20 text-sky-600 dark:text-sky-400 border-sky-500/30 hover:bg-sky-500/30">
+                <Loader2 className="h-3 w-3 animate-spin" />
+                STARTING
+            </Badge>
+        );
+    }
+    if (status === "WAITING_FOR_OBS") {
+        return (
+            <Badge className="gap-1.5 bg-sky-500/20 text-sky-600 dark:text-sky-400 border-sky-500/30 hover:bg-sky-500/30">
+                <Clock className="h-3 w-3" />
+                WAITING FOR OBS
+            </Badge>
+        );
+    }
     if (activeSource === "LOOP") {
         return (
             <Badge className="gap-1.5 bg-blue-500/20 text-blue-600 dark:text-blue-400 border-blue-500/30 hover:bg-blue-500/30">
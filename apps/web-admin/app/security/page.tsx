@@ -116,8 +116,8 @@ export default function SecurityPage() {
             setMsg({ type: 'error', text: "New passwords do not match" });
             return;
         }
-        if (passData.new.length < 6) {
-            setMsg({ type: 'error', text: "Password must be at least 6 characters" });
+        if (passData.new.length < 10) {
+            setMsg({ type: 'error', text: "Password must be at least 10 characters" });
             return;
         }
         setLoading(true);
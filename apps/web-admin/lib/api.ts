@@ -1,15 +1,30 @@
+import { getServerSession } from "next-auth";
+import { authOptions } from "@/lib/auth";
+
 const CONTROLLER_URL = process.env.CONTROLLER_API_URL || 'http://controller:8080';
 
+// controllerHeaders returns the signed-in user's session for a controller
+// request, plus any extra headers. The controller looks up the user's role,
+// email and organization from the X-Session-ID and rejects requests without a
+// live one, so revoking a session locks it out on the next request.
+export async function controllerHeaders(extra?: Record<string, string>): Promise<Record<string, string>> {
+    const session = await getServerSession(authOptions);
+    const user = session?.user as any;
+    const headers: Record<string, string> = { ...extra };
+    if (user?.sessionId) headers['X-Session-ID'] = user.sessionId;
+    return headers;
+}
+
 export async function fetchFromController(endpoint: string, options?: RequestInit) {
     const url = `${CONTROLLER_URL}${endpoint}`;
     try {
         const res = await fetch(url, {
             ...options,
             cache: 'no-store',
-            headers: {
+            headers: await controllerHeaders({
                 'Content-Type': 'application/json',
-                ...options?.headers,
-            },
+                ...(options?.headers as Record<string, string>),
+            }),
         });
         if (!res.ok) {
             throw new Error(`Controller error: ${res.status}`);
@@ -14,7 +14,7 @@ export const authOptions: NextAuthOptions = {
                     return null;
                 }
 
-                // Try to authenticate against backend API
+                // Authenticate against the controller, which opens the session
                 const apiUrl = process.env.CONTROLLER_API_URL || process.env.API_URL || 'http://controller:8080';
                 try {
                     const res = await fetch(`${apiUrl}/api/auth/login`, {
@@ -32,22 +32,13 @@ export const authOptions: NextAuthOptions = {
                             id: user.id || '1',
                             email: user.email || credentials.email,
                             name: user.name || 'Admin',
-                            role: user.role || 'ADMIN',
+                            role: user.role || 'VIEWER',
+                            sessionId: user.session_id,
                         };
                     }
                 } catch (error) {
-                    console.log('Backend auth not available, using fallback login');
-                }
-
-                // Fallback login for when backend auth endpoint is not available 
-                // Matches database user: admin@livestream.local / admin123
-                if (credentials.email === 'admin@livestream.local' && credentials.password === 'admin123') {
-                    return {
-                        id: '1',
-                        email: 'admin@livestream.local',
-                        name: 'System Administrator',
-                        role: 'ADMIN',
-                    };
+                    // No fallback: without a controller session every API call would be rejected
+                    console.error('Controller login failed:', error);
                 }
 
                 return null;
@@ -67,6 +58,8 @@ export const authOptions: NextAuthOptions = {
             if (user) {
                 token.role = (user as any).role;
                 token.id = user.id;
+                // Controller session ID; sent as X-Session-ID so revocation applies
+                token.sid = (user as any).sessionId;
             }
             return token;
         },
@@ -74,6 +67,7 @@ export const authOptions: NextAuthOptions = {
             if (session.user) {
                 (session.user as any).role = token.role;
                 (session.user as any).id = token.id;
+                (session.user as any).sessionId = token.sid;
             }
             return session;
         },
@@ -91,6 +91,7 @@ setup_directories() {
     
     mkdir -p media
     mkdir -p logs
+    mkdir -p recordings
     
     echo -e "${GREEN}✓ Directories created${NC}"
     echo ""